@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// MIME types of the images accepted from a --chapter-art directory, by file extension.
+var chapterArtTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+}
+
+// Builds the frames for --chapters: a table of contents and a CHAP frame for each input. Each
+// chapter is titled with its input's title, or its filename if it has none, and carries an
+// image from [artDir] if one matches, or otherwise the input's own cover art, if it has any.
+// Chapters with an entry in [urls] link to it. The table of contents lists at most 255 chapters.
+func chapterFrames(starts []inputStart, total time.Duration, artDir string, urls *chapterURLs) ([]*mp3lib.ID3v2Frame, error) {
+	var art map[string]string
+	if artDir != "" {
+		var err error
+		if art, err = listChapterArt(artDir); err != nil {
+			return nil, err
+		}
+	}
+
+	var ids []string
+	var chapters []*mp3lib.ID3v2Frame
+	for i, start := range starts {
+		id := fmt.Sprintf("chp%d", i+1)
+		ids = append(ids, id)
+
+		end := total
+		if i+1 < len(starts) {
+			end = starts[i+1].timestamp
+		}
+
+		tag := readTag(start.path)
+		subframes := []*mp3lib.ID3v2Frame{mp3lib.NewTextFrame("TIT2", inputTitle(start.path, tag))}
+
+		picture, err := chapterPicture(art, i+1, start.path, tag)
+		if err != nil {
+			return nil, err
+		}
+		if picture != nil {
+			subframes = append(subframes, picture)
+		}
+		if url := urls.lookup(i+1, start.path); url != "" {
+			subframes = append(subframes, mp3lib.NewURLFrame("", url))
+		}
+
+		chapters = append(chapters, mp3lib.NewChapterFrame(id, start.timestamp, end, subframes...))
+	}
+
+	return append([]*mp3lib.ID3v2Frame{mp3lib.NewTOCFrame("toc", ids)}, chapters...), nil
+}
+
+// Returns the frames of [tag] which can be copied into a new tag: frames with IDs from ID3v2.2
+// which have no ID3v2.3 equivalent, compressed or encrypted frames, and any existing chapters
+// are left out.
+func copyableFrames(tag *mp3lib.ID3v2Tag) []*mp3lib.ID3v2Frame {
+	frames, _ := tag.Frames()
+	unsupported := unsupportedFrameFlags(tag)
+
+	var kept []*mp3lib.ID3v2Frame
+	for _, frame := range frames {
+		if len(frame.ID) != 4 || frame.Flags&unsupported != 0 {
+			continue
+		}
+		if frame.ID == "CHAP" || frame.ID == "CTOC" {
+			continue
+		}
+		kept = append(kept, frame)
+	}
+	return kept
+}
+
+// Returns the chapters and tables of contents of [tag] which can be copied into a new tag, i.e.
+// those which aren't compressed or encrypted. Returns nil if [tag] is nil.
+func copyableChapterFrames(tag *mp3lib.ID3v2Tag) []*mp3lib.ID3v2Frame {
+	if tag == nil {
+		return nil
+	}
+	frames, _ := tag.Frames()
+	unsupported := unsupportedFrameFlags(tag)
+
+	var kept []*mp3lib.ID3v2Frame
+	for _, frame := range frames {
+		if (frame.ID == "CHAP" || frame.ID == "CTOC") && frame.Flags&unsupported == 0 {
+			kept = append(kept, frame)
+		}
+	}
+	return kept
+}
+
+// Returns the flags which mark the frames of [tag] as compressed or encrypted. They moved
+// between versions 2.3 and 2.4.
+func unsupportedFrameFlags(tag *mp3lib.ID3v2Tag) uint16 {
+	if tag.Version() == 4 {
+		return 0x000C
+	}
+	return 0x00C0
+}
+
+// Returns the image for the [index]-th chapter, from the input at [path]: the image in [art]
+// named after the input or numbered with the chapter's index, e.g. '03.jpg', or otherwise the
+// input's own cover art from [tag]. Returns nil if there's no image.
+func chapterPicture(art map[string]string, index int, path string, tag *mp3lib.ID3v2Tag) (*mp3lib.ID3v2Frame, error) {
+	stem := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	artpath, found := art[stem]
+	if !found {
+		artpath, found = art[strconv.Itoa(index)]
+	}
+	if found {
+		image, err := os.ReadFile(artpath)
+		if err != nil {
+			return nil, err
+		}
+		mimeType := chapterArtTypes[strings.ToLower(filepath.Ext(artpath))]
+		return mp3lib.NewPictureFrame(mimeType, mp3lib.PictureFrontCover, "", image), nil
+	}
+
+	if tag != nil {
+		return tag.Picture(), nil
+	}
+	return nil, nil
+}
+
+// Lists the images in the --chapter-art directory [dir]. Returns a map from each image's name,
+// lowercased and without its extension, to its path. Numeric names are also listed by their
+// value, so '03.jpg' matches the third chapter.
+func listChapterArt(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	art := make(map[string]string)
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || chapterArtTypes[ext] == "" {
+			continue
+		}
+		stem := strings.ToLower(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		path := filepath.Join(dir, entry.Name())
+		art[stem] = path
+		if n, err := strconv.Atoi(stem); err == nil {
+			if _, found := art[strconv.Itoa(n)]; !found {
+				art[strconv.Itoa(n)] = path
+			}
+		}
+	}
+	return art, nil
+}
+
+// Chapter links loaded from a --chapter-urls file, keyed by chapter number and by filename.
+type chapterURLs struct {
+	byIndex map[int]string
+	byName  map[string]string
+}
+
+// Loads a --chapter-urls file. Each line gives a chapter number or an input's filename, then
+// the chapter's URL, separated by whitespace. Blank lines and lines beginning with '#' are
+// ignored.
+func loadChapterURLs(path string) (*chapterURLs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := &chapterURLs{byIndex: make(map[int]string), byName: make(map[string]string)}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Filenames may contain spaces but URLs can't, so the URL is the last field.
+		index := strings.LastIndexAny(line, " \t")
+		if index < 0 {
+			return nil, fmt.Errorf("'%v' line %v: expected '<chapter> <url>'", path, i+1)
+		}
+		key, url := strings.TrimSpace(line[:index]), line[index+1:]
+
+		if n, err := strconv.Atoi(key); err == nil {
+			urls.byIndex[n] = url
+		} else {
+			urls.byName[strings.ToLower(filepath.Base(key))] = url
+		}
+	}
+	return urls, nil
+}
+
+// Returns the URL for the [index]-th chapter, from the input at [path], or an empty string if
+// there isn't one. An entry for the input's filename takes precedence over one for the index.
+func (urls *chapterURLs) lookup(index int, path string) string {
+	if urls == nil {
+		return ""
+	}
+	if url, found := urls.byName[strings.ToLower(filepath.Base(path))]; found {
+		return url
+	}
+	return urls.byIndex[index]
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns a new hash for the named checksum algorithm: 'sha256', 'sha1', or 'md5'.
+func newHasher(name string) (hash.Hash, error) {
+	switch strings.ToLower(name) {
+	case "sha256", "sha-256":
+		return sha256.New(), nil
+	case "sha1", "sha-1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, conditionErrorf(errUsage, "unsupported checksum algorithm '%v', expected sha256, sha1, or md5", name)
+}
+
+// Digests loaded from a checksum file in the format written by sha256sum, sha1sum, and md5sum.
+type checksumList struct {
+	path      string            // Path of the checksum file.
+	algorithm string            // Algorithm inferred from the length of the digests.
+	digests   map[string]string // Lowercase hex digests keyed by absolute path.
+}
+
+// Load a checksum file. Each line holds a hex digest, a space, then either a space or '*'
+// (binary mode), then a path. Relative paths are resolved against the current directory, as
+// with 'sha256sum --check', and also against the checksum file's directory.
+func loadChecksums(path string) (*checksumList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &checksumList{path: path, digests: make(map[string]string)}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		digest, name, found := strings.Cut(line, " ")
+		if !found || len(name) < 2 || (name[0] != ' ' && name[0] != '*') {
+			return nil, fmt.Errorf("'%v' line %v: expected '<digest>  <file>'", path, i+1)
+		}
+		name = name[1:]
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, fmt.Errorf("'%v' line %v: invalid digest '%v'", path, i+1, digest)
+		}
+
+		var algorithm string
+		switch len(digest) {
+		case 64:
+			algorithm = "sha256"
+		case 40:
+			algorithm = "sha1"
+		case 32:
+			algorithm = "md5"
+		default:
+			return nil, fmt.Errorf("'%v' line %v: unrecognised digest length", path, i+1)
+		}
+		if list.algorithm != "" && list.algorithm != algorithm {
+			return nil, fmt.Errorf("'%v' mixes checksum algorithms", path)
+		}
+		list.algorithm = algorithm
+
+		digest = strings.ToLower(digest)
+		keys := []string{name}
+		if !filepath.IsAbs(name) {
+			keys = append(keys, filepath.Join(filepath.Dir(path), name))
+		}
+		for _, key := range keys {
+			if abspath, err := filepath.Abs(key); err == nil {
+				if _, exists := list.digests[abspath]; !exists {
+					list.digests[abspath] = digest
+				}
+			}
+		}
+	}
+
+	if len(list.digests) == 0 {
+		return nil, fmt.Errorf("'%v' contains no checksums", path)
+	}
+	return list, nil
+}
+
+// Returns the recorded digest for the file at [path].
+func (list *checksumList) lookup(path string) (string, bool) {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	digest, found := list.digests[abspath]
+	return digest, found
+}
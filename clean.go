@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dmulholl/argo/v4"
+)
+
+var cleanHelptext = fmt.Sprintf(`
+Usage: %s clean --dir <dir> --out-dir <dir>
+
+  Writes a cleaned copy of every MP3 file in a directory tree to another
+  directory, keeping their relative paths. The original files aren't
+  changed.
+
+    $ mp3cat clean --dir in/ --out-dir cleaned/
+
+  Each copy holds only the file's audio frames: ID3v1, ID3v2, and APEv2
+  tags, garbage data, truncated frames, and frames which fail their CRC
+  check are dropped, as with 'mp3cat repair'. A fresh VBR header is
+  written if the file has multiple bitrates.
+
+Options:
+  -d, --dir <path>        Directory of files to clean.
+  -o, --out-dir <path>    Directory for the cleaned files.
+  --workers <n>           Clean up to n files at once. Defaults to 1. With
+                          more than one worker, only warnings, errors, and a
+                          summary are printed.
+
+Flags:
+  -f, --force             Overwrite existing files in the output directory.
+  -h, --help              Display this help text and exit.
+  --include-hidden        Include hidden files and directories.
+  --keep-tags             Keep each file's ID3v2 tag.
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+`, filepath.Base(os.Args[0]))
+
+// Run the 'clean' command. Returns the process exit code.
+func runClean(ctx context.Context, parser *argo.ArgParser) int {
+	setQuiet(parser.Found("quiet"), false)
+
+	if !parser.Found("dir") || !parser.Found("out-dir") {
+		printErrorf(errUsage, "you must specify the --dir to clean and an --out-dir for the cleaned files")
+		return 1
+	}
+	indir := fixLongPath(parser.StringValue("dir"))
+	outdir := fixLongPath(parser.StringValue("out-dir"))
+	if isSameFile(indir, outdir) {
+		printErrorf(errUsage, "the --out-dir can't be the same as the --dir")
+		return 1
+	}
+
+	files, err := findFiles(indir, "", parser.Found("include-hidden"))
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	if len(files) == 0 {
+		printErrorf(errNoFiles, "no files found")
+		return 1
+	}
+
+	workers, err := parseWorkers(parser)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	// The output of files cleaned at the same time would be interleaved, so only warnings and
+	// errors are shown until they're done.
+	level := logLevel.Level()
+	if workers > 1 {
+		setQuiet(true, false)
+	}
+
+	exitCode := 0
+	work := func(ctx context.Context, i int) error {
+		return cleanFile(ctx, files[i], indir, outdir, parser.Found("keep-tags"), parser.Found("force"))
+	}
+	runWorkers(ctx, len(files), workers, work, func(i int, err error) bool {
+		if ctx.Err() != nil {
+			printError(ctx.Err())
+			exitCode = 1
+			return false
+		}
+		if err != nil {
+			printError(fmt.Errorf("cannot clean '%v': %w", files[i], err))
+			exitCode = 1
+			return false
+		}
+		return true
+	})
+	logLevel.Set(level)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	printInfo("Cleaned %v files.", len(files))
+	return 0
+}
+
+// Writes a cleaned copy of [file], which is in the directory tree [indir], to the same relative
+// path in [outdir]. If [keepTags] is true, the file's ID3v2 tag is copied.
+func cleanFile(ctx context.Context, file, indir, outdir string, keepTags, force bool) error {
+	relpath, err := filepath.Rel(indir, file)
+	if err != nil {
+		return err
+	}
+	outpath := filepath.Join(outdir, relpath)
+	if err := os.MkdirAll(filepath.Dir(outpath), 0755); err != nil {
+		return err
+	}
+
+	var tagpath string
+	if keepTags {
+		tagpath = file
+	}
+	return merge(ctx, []string{file}, &mergeOptions{
+		outpath: outpath,
+		tagpath: tagpath,
+		force:   force,
+		repair:  true,
+	})
+}
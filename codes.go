@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+var codesHelptext = fmt.Sprintf(`
+Usage: %s codes
+
+  Lists the codes which identify the conditions mp3cat warns about or fails
+  on. Warnings have W codes, errors E codes, and notes from 'verify' I codes.
+
+  Each warning and error message shows its code, e.g.
+
+    Warning W001: 'b.mp3' has a sample rate of 22050 Hz but the output has 44100 Hz.
+
+  With --log-format json, each record has 'code' and 'condition' fields,
+  e.g. "code": "W001", "condition": "mixed-samplerate". Codes and their
+  names are never changed or reused, so scripts can rely on them to ignore
+  or escalate specific conditions.
+
+Flags:
+  -h, --help              Display this help text and exit.
+`, filepath.Base(os.Args[0]))
+
+// A condition which mp3cat warns about or fails on, with a stable code, e.g. 'W001', and name,
+// e.g. 'mixed-samplerate'.
+type condition struct {
+	code        string
+	name        string
+	description string
+}
+
+// Every condition, in order of code, for the 'codes' command.
+var conditions []condition
+
+// Registers a condition.
+func newCondition(code, name, description string) condition {
+	c := condition{code, name, description}
+	conditions = append(conditions, c)
+	return c
+}
+
+// Warnings.
+var (
+	warnMixedSampleRate  = newCondition("W001", "mixed-samplerate", "An input has a different sample rate from the output.")
+	warnMixedChannels    = newCondition("W002", "mixed-channels", "An input is mono and the output stereo, or the reverse.")
+	warnMixedVersion     = newCondition("W003", "mixed-mpeg-version", "An input has a different MPEG version from the output.")
+	warnMixedLayer       = newCondition("W004", "mixed-layer", "An input has a different MPEG layer from the output.")
+	warnMixedHeaderBits  = newCondition("W005", "mixed-header-bits", "An input's emphasis, copyright, original, or private bits differ.")
+	warnMixedCRC         = newCondition("W006", "mixed-crc", "Some inputs are CRC-protected and others aren't.")
+	warnReservoirJoin    = newCondition("W007", "reservoir-join", "An input's first frame uses audio data from the previous input.")
+	warnAlignFailed      = newCondition("W008", "align-failed", "No silent frame could be made to align an input.")
+	warnResyncLimit      = newCondition("W009", "resync-limit", "An input was cut short at the --max-resync limit.")
+	warnNoFrames         = newCondition("W010", "no-frames", "An input contains no MP3 frames.")
+	warnMissingVBRHeader = newCondition("W011", "missing-vbr-header", "A file has a variable bitrate but no VBR header.")
+	warnVBRUnsupported   = newCondition("W012", "vbr-header-unsupported", "The output has multiple bitrates but can't have a VBR header.")
+	warnVBRFrameCount    = newCondition("W013", "vbr-frame-overflow", "The output has too many frames to count in its VBR header.")
+	warnVBRByteCount     = newCondition("W014", "vbr-byte-overflow", "The output is too large to record its size in its VBR header.")
+	warnSkippedOutput    = newCondition("W015", "skipped-output-file", "The output file was listed as an input and skipped.")
+	warnSkippedSmall     = newCondition("W016", "skipped-small-file", "An input was skipped by --min-size.")
+	warnSkippedShort     = newCondition("W017", "skipped-short-file", "An input was skipped by --skip-shorter-than.")
+	warnSkippedLong      = newCondition("W018", "skipped-long-file", "An input was skipped by --skip-longer-than.")
+	warnSimilarRecording = newCondition("W019", "similar-recording", "An input appears to be the same recording as an earlier one.")
+	warnDuplicateFile    = newCondition("W020", "duplicate-file", "An input duplicates an earlier one.")
+	warnChapterLimit     = newCondition("W021", "chapter-limit", "There are too many chapters to list in the table of contents.")
+	warnTagText          = newCondition("W022", "tag-text-replaced", "Tag text couldn't be written in ISO-8859-1.")
+	warnTagFrameDropped  = newCondition("W023", "tag-frame-dropped", "A tag frame was dropped to fit --max-tag-size.")
+	warnDamagedTag       = newCondition("W024", "damaged-tag", "A file's ID3v2 tag is damaged.")
+	warnChaptersPastEnd  = newCondition("W025", "chapters-past-end", "Chapters start after the end of a file's audio.")
+	warnWatchFailed      = newCondition("W026", "watch-failed", "A watched file or directory couldn't be read.")
+	warnGarbageData      = newCondition("W027", "garbage-data", "A file contains unrecognised data between frames.")
+	warnSkippedInput     = newCondition("W028", "skipped-input", "An input which couldn't be read was skipped, with --keep-going.")
+	warnReadFailed       = newCondition("W029", "read-failed", "An input couldn't be read to the end, with --keep-going.")
+)
+
+// Errors.
+var (
+	errOther          = newCondition("E000", "error", "An error without a more specific code, e.g. an I/O error.")
+	errUsage          = newCondition("E001", "usage", "Invalid command line arguments.")
+	errNoFiles        = newCondition("E002", "no-input-files", "No input files were found.")
+	errFileNotFound   = newCondition("E003", "file-not-found", "An input file doesn't exist.")
+	errOutputExists   = newCondition("E004", "output-exists", "An output file already exists.")
+	errNoMetadata     = newCondition("E005", "missing-metadata", "A file has no tag or chapters to read.")
+	errNotMP3         = newCondition("E006", "not-mp3", "An input is in some other format.")
+	errRequirement    = newCondition("E007", "requirement-failed", "An input failed a --require-* check.")
+	errChecksum       = newCondition("E008", "checksum-mismatch", "An input doesn't match its checksum.")
+	errVerifyOutput   = newCondition("E009", "output-verification", "The output failed --verify-output.")
+	errInterrupted    = newCondition("E010", "interrupted", "mp3cat was interrupted.")
+	errLocked         = newCondition("E011", "output-locked", "Another mp3cat process is writing the output.")
+	errDiskSpace      = newCondition("E012", "disk-space", "There isn't enough free space for the output.")
+	errHook           = newCondition("E013", "hook-failed", "A --pre-exec or --post-exec command failed.")
+	errMixedFormat    = newCondition("E101", "mixed-format", "Inputs have different formats, with --strict.")
+	errMixedCRC       = newCondition("E102", "mixed-crc", "Some inputs are CRC-protected, with --normalize-crc error.")
+	errGarbageData    = newCondition("E103", "garbage-data", "A file contains unrecognised data, with --strict-parse.")
+	errTruncatedFrame = newCondition("E104", "truncated-frame", "A file ends with a truncated frame.")
+	errCRCFailure     = newCondition("E105", "crc-failure", "A frame failed its CRC check.")
+	errCRCStrip       = newCondition("E106", "crc-strip-failed", "The CRCs couldn't be removed from an input.")
+)
+
+// Notes from 'verify'.
+var (
+	noteBitrateChange = newCondition("I001", "bitrate-change", "A file's bitrate changes.")
+)
+
+// An error with the condition it reports, so printError can show its code.
+type conditionError struct {
+	condition condition
+	err       error
+}
+
+func (e *conditionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *conditionError) Unwrap() error {
+	return e.err
+}
+
+// Returns a formatted error reporting [cond]. As with fmt.Errorf, the %w verb wraps an error.
+func conditionErrorf(cond condition, format string, args ...any) error {
+	return &conditionError{cond, fmt.Errorf(format, args...)}
+}
+
+// Returns the condition reported by [err], or errOther if it has none.
+func errorCondition(err error) condition {
+	var condErr *conditionError
+	if errors.As(err, &condErr) {
+		return condErr.condition
+	}
+	if errors.Is(err, context.Canceled) {
+		return errInterrupted
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return errFileNotFound
+	}
+	return errOther
+}
+
+// Returns the condition for an issue found by mp3lib's validator.
+func issueCondition(issue mp3lib.Issue) condition {
+	switch issue.Kind {
+	case mp3lib.IssueCRCFailure:
+		return errCRCFailure
+	case mp3lib.IssueTruncatedFrame:
+		return errTruncatedFrame
+	case mp3lib.IssueBitrateChange:
+		return noteBitrateChange
+	}
+	return warnGarbageData
+}
+
+// Run the 'codes' command. Returns the process exit code.
+func runCodes(parser *argo.ArgParser) int {
+	for _, c := range conditions {
+		fmt.Printf("%v  %-24v %v\n", c.code, c.name, c.description)
+	}
+	return 0
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// Terminals on other platforms handle ANSI escape codes without any setup.
+func enableANSI(file *os.File) (func(), error) {
+	return func() {}, nil
+}
+
+// Explorer only starts mp3cat with a console window of its own on Windows.
+func startedFromExplorer() bool {
+	return false
+}
@@ -0,0 +1,37 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Turns on ANSI escape code handling for a Windows console, which older consoles leave off.
+// Returns a function which restores the console's previous mode.
+func enableANSI(file *os.File) (func(), error) {
+	handle := windows.Handle(file.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		return nil, err
+	}
+	return func() { windows.SetConsoleMode(handle, mode) }, nil
+}
+
+var procGetConsoleProcessList = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetConsoleProcessList")
+
+// Returns true if mp3cat has a console window of its own, i.e. it was started from Explorer
+// rather than a shell. A console opened by a shell is shared with the shell's process.
+func startedFromExplorer() bool {
+	if procGetConsoleProcessList.Find() != nil {
+		return false
+	}
+	pids := make([]uint32, 2)
+	count, _, _ := procGetConsoleProcessList.Call(uintptr(unsafe.Pointer(&pids[0])), uintptr(len(pids)))
+	return count == 1
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Number of frames at the start and end of a file hashed for its fingerprint.
+const fingerprintFrames = 32
+
+// Checks the list of input files for duplicates: the same file listed twice, e.g. by a glob and
+// an explicit argument, or two files with identical content. Duplicates are reported with a
+// warning, or dropped from the list if [skip] is true. If [similar] is true, files which appear
+// to contain the same recording as an earlier file, e.g. with a different tag, are also
+// reported. Returns the list of files to merge.
+func checkDuplicates(files []string, skip, similar bool) []string {
+	// Only files with the same size can have the same content, so we only hash those.
+	sizes := make(map[int64]int)
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			sizes[info.Size()]++
+		}
+	}
+
+	var kept []string
+	seen := make(map[string]string)
+	fingerprints := make(map[string]string)
+	for _, file := range files {
+		original := findDuplicate(file, kept, seen, sizes)
+		if original == "" {
+			if similar {
+				if other := findSimilar(file, fingerprints); other != "" {
+					printWarning(warnSimilarRecording, "'%v' appears to be the same recording as '%v'", file, other)
+				}
+			}
+			kept = append(kept, file)
+			continue
+		}
+		if skip {
+			printWarning(warnDuplicateFile, "skipping '%v' as it duplicates '%v'", file, original)
+			continue
+		}
+		printWarning(warnDuplicateFile, "'%v' duplicates '%v' (use --dedupe to skip duplicates)", file, original)
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// Returns the earlier file in [kept] which [file] duplicates, or an empty string if there isn't
+// one. [seen] maps the content hashes of earlier files to their paths; [file]'s hash is added
+// if it's computed.
+func findDuplicate(file string, kept []string, seen map[string]string, sizes map[int64]int) string {
+	for _, other := range kept {
+		if isSameFile(file, other) {
+			return other
+		}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil || sizes[info.Size()] < 2 {
+		return ""
+	}
+
+	digest, err := hashFile(file)
+	if err != nil {
+		return ""
+	}
+	if other, found := seen[digest]; found {
+		return other
+	}
+	seen[digest] = file
+	return ""
+}
+
+// Returns the earlier file whose fingerprint matches [file]'s, or an empty string if there isn't
+// one. [fingerprints] maps the fingerprints of earlier files to their paths; [file]'s is added.
+func findSimilar(file string, fingerprints map[string]string) string {
+	digest, err := fingerprint(file)
+	if err != nil {
+		return ""
+	}
+	if other, found := fingerprints[digest]; found {
+		return other
+	}
+	fingerprints[digest] = file
+	return ""
+}
+
+// Returns a fingerprint of the audio in the file at [path], built from hashes of its first and
+// last few frames and its duration to the nearest second. Tags and VBR headers are ignored, so
+// two copies of a recording with different metadata have the same fingerprint.
+func fingerprint(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := sha256.New()
+	var tail [fingerprintFrames][sha256.Size]byte
+	var frames int
+	var duration time.Duration
+
+	reader := mp3lib.NewFrameReader(file)
+	frame := &mp3lib.MP3Frame{}
+	for {
+		err := reader.ReadInto(frame)
+		if isEndOfStream(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if frames < mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+			continue
+		}
+		if frames < fingerprintFrames {
+			head.Write(frame.RawBytes)
+		}
+		tail[frames%fingerprintFrames] = sha256.Sum256(frame.RawBytes)
+		duration += frame.Duration()
+		frames++
+	}
+
+	if frames == 0 {
+		return "", mp3lib.ErrNoFrames
+	}
+
+	// Hash the last frames in order, oldest first.
+	hasher := sha256.New()
+	for i := range min(frames, fingerprintFrames) {
+		index := (frames + i) % fingerprintFrames
+		if frames < fingerprintFrames {
+			index = i
+		}
+		hasher.Write(tail[index][:])
+	}
+
+	return fmt.Sprintf("%x:%x:%d", head.Sum(nil), hasher.Sum(nil), duration.Round(time.Second)/time.Second), nil
+}
+
+// Returns the hex SHA-256 digest of the file at [path].
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
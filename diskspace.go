@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Check that the filesystems we write to have enough free space for the merge. The output size
+// is estimated from the combined size of the input files, which is an upper bound as only MP3
+// frames are copied. The '.partial' output file needs that much space in the output directory,
+// and inserting a VBR header or a late ID3 tag with no space reserved for it needs as much again
+// for the temporary copy, in the temporary directory if set. Directories on the same filesystem share its free space, so
+// their needs are added together.
+func checkFreeSpace(inpaths []string, opts *mergeOptions) error {
+	var estimate uint64
+	for _, inpath := range inpaths {
+		info, err := os.Stat(inpath)
+		if err != nil {
+			return err
+		}
+		estimate += uint64(info.Size())
+	}
+
+	tmpdir := opts.tmpdir
+	if tmpdir == "" {
+		tmpdir = filepath.Dir(opts.outpath)
+	}
+	return checkSpaceNeeds([]spaceNeed{
+		{filepath.Dir(opts.outpath), estimate},
+		{tmpdir, estimate},
+	})
+}
+
+// Space needed for files written to a directory.
+type spaceNeed struct {
+	dir   string
+	bytes uint64
+}
+
+// Check that each filesystem has enough free space for the needs of all the directories on it.
+// Needs on a filesystem whose free space or identity can't be determined are skipped.
+func checkSpaceNeeds(needs []spaceNeed) error {
+	var filesystems []string
+	totals := map[string]*spaceNeed{}
+	for _, need := range needs {
+		id, err := filesystemID(need.dir)
+		if err != nil {
+			continue
+		}
+		if total, found := totals[id]; found {
+			total.bytes += need.bytes
+			continue
+		}
+		filesystems = append(filesystems, id)
+		totals[id] = &spaceNeed{need.dir, need.bytes}
+	}
+
+	for _, id := range filesystems {
+		total := totals[id]
+		available, err := freeSpace(total.dir)
+		if err != nil {
+			// Free space can't be determined on this platform or filesystem, so skip the check.
+			continue
+		}
+		if available < total.bytes {
+			return conditionErrorf(errDiskSpace,
+				"not enough free space in '%v' (need approximately %v, %v available)",
+				total.dir, formatBytes(total.bytes), formatBytes(available))
+		}
+	}
+
+	return nil
+}
+
+// Format a byte count as a human-readable string, e.g. '12.3 MB'.
+func formatBytes(n uint64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+// Free space can't be determined on this platform.
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// Filesystems can't be identified on this platform.
+func filesystemID(dir string) (string, error) {
+	return "", errors.ErrUnsupported
+}
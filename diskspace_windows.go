@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Returns the number of bytes available to the current user on the volume containing [dir].
+func freeSpace(dir string) (uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+// Returns an identifier for the volume containing [dir], the path of its mount point, e.g.
+// 'C:\'.
+func filesystemID(dir string) (string, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	if err := windows.GetVolumePathName(path, &buf[0], uint32(len(buf))); err != nil {
+		return "", err
+	}
+	return strings.ToLower(windows.UTF16ToString(buf)), nil
+}
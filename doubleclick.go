@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns true if mp3cat has been started from Explorer rather than a shell, i.e. double-clicked
+// or given files dropped onto it: it has a console window of its own and only file arguments.
+func isDoubleClickLaunch(args []string) bool {
+	if !startedFromExplorer() {
+		return false
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+// Reads the user's answers in double-click mode. It's shared as a buffered reader can read ahead.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// Merges the files dropped onto mp3cat, or the files in a dropped directory, or without any, the
+// files in mp3cat's own directory, after asking the user to confirm. The output goes in the
+// files' directory and is named after it. Returns the process exit code. The console window
+// closes when mp3cat exits, so the caller should wait for the user before exiting.
+func runDoubleClick(ctx context.Context, args []string) int {
+	var dir string
+	var files []string
+	scan := len(args) == 0
+	if scan {
+		exe, err := os.Executable()
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		dir = filepath.Dir(exe)
+	} else if info, err := os.Stat(args[0]); len(args) == 1 && err == nil && info.IsDir() {
+		dir, scan = args[0], true
+	} else {
+		for _, arg := range args {
+			files = append(files, fixLongPath(arg))
+		}
+		sortNatural(files)
+		dir = filepath.Dir(files[0])
+	}
+
+	outpath := fixLongPath(filepath.Join(dir, defaultOutputName(dir)))
+	if scan {
+		var err error
+		files, err = findFiles(fixLongPath(dir), outpath, false)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+	} else {
+		files = skipOutputFile(files, outpath)
+	}
+	if len(files) == 0 {
+		printErrorf(errNoFiles, "no files found in '%v'", dir)
+		return 1
+	}
+
+	fmt.Printf("Found %v files in '%v':\n\n", len(files), dir)
+	for _, file := range files {
+		name := file
+		if rel, err := filepath.Rel(fixLongPath(dir), file); err == nil {
+			name = rel
+		}
+		fmt.Printf("  %v\n", name)
+	}
+	fmt.Println()
+
+	question := fmt.Sprintf("Merge them into '%v'?", filepath.Base(outpath))
+	if _, err := os.Stat(outpath); err == nil {
+		question = fmt.Sprintf("Merge them into '%v', replacing the existing file?", filepath.Base(outpath))
+	}
+	if !confirm(question) {
+		return 0
+	}
+	fmt.Println()
+
+	lock, err := acquireLock(outpath, 0)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	defer lock.release()
+
+	if err := validateFiles(files); err != nil {
+		printError(err)
+		return 1
+	}
+	err = merge(ctx, files, &mergeOptions{
+		outpath: outpath,
+		force:   true,
+	})
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+// Returns the name for the output of merging the files in [dir], e.g. 'Dune.mp3' for
+// 'C:\Books\Dune', or 'output.mp3' if the directory is the root of a drive.
+func defaultOutputName(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		name := filepath.Base(abs)
+		if name != string(filepath.Separator) && name != "." && filepath.VolumeName(abs) != abs {
+			return name + ".mp3"
+		}
+	}
+	return "output.mp3"
+}
+
+// Returns [files] without the output file, e.g. if it's dropped onto mp3cat with its inputs.
+func skipOutputFile(files []string, outpath string) []string {
+	var inputs []string
+	for _, file := range files {
+		if isSameFile(file, outpath) {
+			printWarning(warnSkippedOutput, "skipping the output file '%v' in the list of input files", file)
+			continue
+		}
+		inputs = append(inputs, file)
+	}
+	return inputs
+}
+
+// Asks the user a yes-or-no question. Returns true if they answer yes.
+func confirm(question string) bool {
+	fmt.Printf("%v [y/N] ", question)
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Waits for the user to press Enter, so the output stays on screen until they've read it.
+func waitForEnter() {
+	fmt.Print("\nPress Enter to exit.")
+	stdinReader.ReadString('\n')
+}
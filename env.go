@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Options of the main merge command which can be set with MP3CAT_* environment variables, e.g.
+// MP3CAT_OUT for --out.
+var envOptions = []string{
+	"out", "dir", "interlace", "tmpdir", "touch", "min-size", "meta", "ape", "wait",
+	"require-samplerate", "require-channels", "require-layer",
+	"log-level", "log-format", "color", "checksum", "write-manifest",
+	"verify-checksums", "pre-exec", "post-exec", "on-file", "read-buffer", "write-buffer",
+	"max-tag-read", "max-resync", "write-toc", "report", "normalize-crc", "chapter-art",
+	"chapter-urls", "max-tag-size", "genre",
+	"tag-encoding", "comment", "comment-lang", "comment-desc", "embed-tracklist",
+	"skip-shorter-than", "skip-longer-than",
+}
+
+// Flags of the main merge command which can be set with MP3CAT_* environment variables, e.g.
+// MP3CAT_QUIET=1 for --quiet.
+var envFlags = []string{
+	"force", "backup", "quiet", "silent", "debug", "preserve-times", "include-hidden", "require-cbr",
+	"strict", "strict-parse", "fix-reservoir", "verify-output", "info-header",
+	"keep-headers", "align-frames", "dedupe", "warn-duplicates", "chapters",
+	"auto-tags", "strip-art", "gapless", "reproducible", "keep-going",
+}
+
+// Returns the command line arguments, including the program name in args[0], with arguments for
+// any MP3CAT_* environment variables inserted after the program name. Options take their last
+// value, so values given on the command line take precedence over the environment.
+func argsWithEnv(args []string) []string {
+	envArgs := []string{args[0]}
+	for _, name := range envOptions {
+		if value, ok := os.LookupEnv(envName(name)); ok && value != "" {
+			envArgs = append(envArgs, "--"+name+"="+value)
+		}
+	}
+	for _, name := range envFlags {
+		if isTruthy(os.Getenv(envName(name))) {
+			envArgs = append(envArgs, "--"+name)
+		}
+	}
+	return append(envArgs, args[1:]...)
+}
+
+// Returns the environment variable for an option, e.g. 'MP3CAT_MIN_SIZE' for 'min-size'.
+func envName(option string) string {
+	return "MP3CAT_" + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// Returns true if the value of a flag's environment variable turns the flag on.
+func isTruthy(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+var extractHelptext = fmt.Sprintf(`
+Usage: %s extract-meta <file>
+
+  Writes the metadata in an MP3 file's ID3v2 tag to disk, e.g. to keep a
+  copy of a file's tags and cover art before merging it with others:
+
+    $ mp3cat extract-meta file.mp3 --out-dir meta/
+
+  Three kinds of files are written, named after the input file:
+
+    file.id3              The raw ID3v2 tag, header and all.
+    file.json             The tag's text, comment, lyrics, and link frames.
+    file.cover.jpg        The tag's images: 'cover' for the front cover,
+    file.picture-2.png    otherwise 'picture-N'.
+
+Arguments:
+  <file>                  File to extract metadata from.
+
+Options:
+  -o, --out-dir <dir>     Directory for the extracted files. Defaults to the
+                          input file's directory.
+
+Flags:
+  -f, --force             Overwrite existing files.
+  -h, --help              Display this help text and exit.
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+`, filepath.Base(os.Args[0]))
+
+// The JSON rendering of a tag written by 'extract-meta'.
+type extractedTag struct {
+	Version string           `json:"version"`
+	Frames  []extractedFrame `json:"frames"`
+}
+
+type extractedFrame struct {
+	ID          string `json:"id"`
+	Language    string `json:"language,omitempty"`
+	Description string `json:"description,omitempty"`
+	Text        string `json:"text"`
+}
+
+// A file to write for 'extract-meta'.
+type extractedFile struct {
+	name string
+	data []byte
+}
+
+// Run the 'extract-meta' command. Returns the process exit code.
+func runExtractMeta(ctx context.Context, parser *argo.ArgParser) int {
+	setQuiet(parser.Found("quiet"), false)
+
+	if len(parser.Args) != 1 {
+		printErrorf(errUsage, "you must specify a single file to extract metadata from")
+		return 1
+	}
+	inpath := fixLongPath(parser.Args[0])
+
+	tag := readTag(inpath)
+	if tag == nil {
+		printErrorf(errNoMetadata, "'%v' has no ID3v2 tag", inpath)
+		return 1
+	}
+
+	outdir := parser.StringValue("out-dir")
+	if outdir == "" {
+		outdir = filepath.Dir(inpath)
+	}
+	outdir = fixLongPath(outdir)
+	stem := strings.TrimSuffix(filepath.Base(inpath), filepath.Ext(inpath))
+
+	// Collect every file to write first, so nothing is written if any of them already exists.
+	files := []extractedFile{{stem + ".id3", tag.RawBytes}}
+
+	rendering := extractedTag{Version: fmt.Sprintf("2.%v", tag.Version()), Frames: []extractedFrame{}}
+	frames, err := tag.Frames()
+	if err != nil {
+		printWarning(warnDamagedTag, "the tag in '%v' is damaged: %v", inpath, err)
+	}
+	for _, frame := range frames {
+		if content, ok := frame.TextContent(); ok {
+			rendering.Frames = append(rendering.Frames, extractedFrame{
+				ID:          frame.ID,
+				Language:    content.Language,
+				Description: content.Description,
+				Text:        content.Text,
+			})
+		}
+	}
+	data, err := json.MarshalIndent(rendering, "", "  ")
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	files = append(files, extractedFile{stem + ".json", append(data, '\n')})
+
+	var haveCover bool
+	for i, picture := range tag.Pictures() {
+		name := fmt.Sprintf("picture-%v", i+1)
+		if picture.Type == mp3lib.PictureFrontCover && !haveCover {
+			name, haveCover = "cover", true
+		}
+		files = append(files, extractedFile{stem + "." + name + imageExtension(picture.MIMEType), picture.Data})
+	}
+
+	for _, file := range files {
+		path := filepath.Join(outdir, file.name)
+		if _, err := os.Stat(path); err == nil && !parser.Found("force") {
+			printErrorf(errOutputExists, "'%v' already exists (use --force to overwrite)", path)
+			return 1
+		}
+	}
+
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		printError(err)
+		return 1
+	}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			printError(ctx.Err())
+			return 1
+		}
+		path := filepath.Join(outdir, file.name)
+		if err := os.WriteFile(path, file.data, 0644); err != nil {
+			printError(err)
+			return 1
+		}
+		printInfo("Wrote: %v", path)
+	}
+	return 0
+}
+
+// Returns the file extension for an image's MIME type. ID3v2.2 tags and some taggers use a bare
+// format name, e.g. 'JPG', instead of a MIME type.
+func imageExtension(mimeType string) string {
+	switch strings.TrimPrefix(strings.ToLower(mimeType), "image/") {
+	case "jpeg", "jpg", "pjpeg":
+		return ".jpg"
+	case "png":
+		return ".png"
+	case "gif":
+		return ".gif"
+	case "webp":
+		return ".webp"
+	case "bmp":
+		return ".bmp"
+	default:
+		return ".bin"
+	}
+}
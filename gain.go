@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+	"github.com/hajimehoshi/go-mp3"
+)
+
+var gainHelptext = fmt.Sprintf(`
+Usage: %s gain --scan [files]
+
+  Measures the loudness of a batch of MP3 files, e.g. the parts of a book,
+  as defined by EBU R128, and computes their ReplayGain 2.0 values. Each
+  file is measured by itself as a track, and the files are measured
+  together as an album, as though they'd been merged in order. The audio
+  isn't changed.
+
+    $ mp3cat gain --scan --dir parts/ --write-tags
+
+  The gain brings the audio to the ReplayGain reference level of -18 LUFS.
+  With --write-tags, the values are set in each file's ID3v2 tag as TXXX
+  frames: REPLAYGAIN_TRACK_GAIN, REPLAYGAIN_TRACK_PEAK, and the album
+  equivalents. With --sidecar, they're written to a JSON file. Files from
+  --dir are in natural order.
+
+  Only MPEG layer III audio can be decoded.
+
+Arguments:
+  [files]                 List of files to scan.
+
+Options:
+  -d, --dir <path>        Directory of files to scan.
+  --sidecar <path>        Write the results to a JSON file.
+
+Flags:
+  -h, --help              Display this help text and exit.
+  --include-hidden        Include hidden files and directories when scanning
+                          a directory with --dir.
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+  --scan                  Measure the files. Required.
+  --write-tags            Set the ReplayGain values in the files' tags.
+`, filepath.Base(os.Args[0]))
+
+// The ReplayGain 2.0 reference loudness, in LUFS.
+const replayGainReference = -18
+
+// The loudness and gain of a file or of the album, as written with --sidecar. Loudness and gain
+// are nil if the audio is too quiet to measure.
+type gainResult struct {
+	Path     string   `json:"path,omitempty"`
+	Loudness *float64 `json:"loudness"` // In LUFS.
+	Gain     *float64 `json:"gain"`     // In dB.
+	Peak     float64  `json:"peak"`     // As a fraction of full scale.
+}
+
+// The results of a scan, as written with --sidecar.
+type gainReport struct {
+	Reference float64       `json:"reference"`
+	Album     gainResult    `json:"album"`
+	Tracks    []*gainResult `json:"tracks"`
+}
+
+// Run the 'gain' command. Returns the process exit code.
+func runGain(ctx context.Context, parser *argo.ArgParser) int {
+	setQuiet(parser.Found("quiet"), false)
+
+	if !parser.Found("scan") {
+		printErrorf(errUsage, "nothing to do: use --scan to measure the files")
+		return 1
+	}
+
+	var files []string
+	if parser.Found("dir") {
+		var err error
+		files, err = findFiles(fixLongPath(parser.StringValue("dir")), "", parser.Found("include-hidden"))
+		if err != nil {
+			printError(err)
+			return 1
+		}
+	} else {
+		for _, arg := range expandGlobs(parser.Args) {
+			files = append(files, fixLongPath(arg))
+		}
+	}
+	if len(files) == 0 {
+		printErrorf(errUsage, "you must specify files to scan")
+		return 1
+	}
+
+	report := &gainReport{Reference: replayGainReference}
+	var blocks []float64
+	for _, file := range files {
+		meter, err := measureLoudness(ctx, file)
+		if err != nil {
+			printError(fmt.Errorf("cannot scan '%v': %w", file, err))
+			return 1
+		}
+		track := newGainResult(meter.Loudness(), meter.Peak())
+		track.Path = file
+		report.Tracks = append(report.Tracks, track)
+		report.Album.Peak = max(report.Album.Peak, track.Peak)
+		blocks = append(blocks, meter.blocks...)
+		printInfo("%v: %v", file, formatGainResult(track))
+	}
+	album := newGainResult(integratedLoudness(blocks), report.Album.Peak)
+	report.Album = *album
+	printInfo("Album: %v", formatGainResult(album))
+
+	if parser.Found("sidecar") {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		if err := os.WriteFile(parser.StringValue("sidecar"), append(data, '\n'), 0644); err != nil {
+			printError(err)
+			return 1
+		}
+	}
+
+	if parser.Found("write-tags") {
+		for _, track := range report.Tracks {
+			if ctx.Err() != nil {
+				printError(ctx.Err())
+				return 1
+			}
+			frames := append(replayGainFrames("TRACK", track), replayGainFrames("ALBUM", album)...)
+			if err := retagFile(track.Path, "", frames); err != nil {
+				printError(fmt.Errorf("cannot retag '%v': %w", track.Path, err))
+				return 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// Returns the result for audio with the given loudness and peak.
+func newGainResult(loudness, peak float64) *gainResult {
+	result := &gainResult{Peak: peak}
+	if !math.IsInf(loudness, -1) {
+		gain := replayGainReference - loudness
+		result.Loudness = &loudness
+		result.Gain = &gain
+	}
+	return result
+}
+
+// Formats a result for display, e.g. '-20.31 LUFS, gain +2.31 dB, peak 0.891'.
+func formatGainResult(result *gainResult) string {
+	if result.Loudness == nil {
+		return fmt.Sprintf("too quiet to measure, peak %.3f", result.Peak)
+	}
+	return fmt.Sprintf("%.2f LUFS, gain %+.2f dB, peak %.3f", *result.Loudness, *result.Gain, result.Peak)
+}
+
+// Returns the ReplayGain TXXX frames for a result, with [scope] 'TRACK' or 'ALBUM'. A result
+// without a gain has only a peak frame.
+func replayGainFrames(scope string, result *gainResult) []*mp3lib.ID3v2Frame {
+	frames := []*mp3lib.ID3v2Frame{
+		mp3lib.NewUserTextFrame("REPLAYGAIN_"+scope+"_PEAK", fmt.Sprintf("%.6f", result.Peak)),
+	}
+	if result.Gain != nil {
+		gain := mp3lib.NewUserTextFrame("REPLAYGAIN_"+scope+"_GAIN", fmt.Sprintf("%+.2f dB", *result.Gain))
+		frames = append([]*mp3lib.ID3v2Frame{gain}, frames...)
+	}
+	return frames
+}
+
+// Decodes the MP3 file at [path] and returns a meter which has measured its audio.
+func measureLoudness(ctx context.Context, path string) (*loudnessMeter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stream := &frameStream{reader: mp3lib.NewFrameReader(mp3lib.NewContextReader(ctx, file))}
+	decoder, err := mp3.NewDecoder(stream)
+	if err != nil {
+		if stream.err != nil {
+			return nil, stream.err
+		}
+		if stream.template == nil {
+			return nil, conditionErrorf(errNotMP3, "the file contains no MP3 frames")
+		}
+		return nil, err
+	}
+
+	// The decoder always outputs stereo, duplicating a mono channel.
+	channels := 2
+	if stream.template.ChannelMode == mp3lib.Mono {
+		channels = 1
+	}
+	meter := newLoudnessMeter(decoder.SampleRate(), channels)
+
+	buf := make([]byte, 1<<16)
+	samples := make([]float64, 0, len(buf)/2)
+	for {
+		n, err := io.ReadFull(decoder, buf)
+		n -= n % 4
+		samples = samples[:0]
+		for i := 0; i < n; i += 4 {
+			for ch := range channels {
+				sample := int16(binary.LittleEndian.Uint16(buf[i+2*ch:]))
+				samples = append(samples, float64(sample)/32768)
+			}
+		}
+		meter.Add(samples)
+
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			if stream.err != nil {
+				return nil, stream.err
+			}
+			return nil, err
+		}
+	}
+	if stream.err != nil {
+		return nil, stream.err
+	}
+	return meter, nil
+}
+
+// An io.Reader over the layer III frames of a file, for the decoder. Tags, garbage data, and the
+// file's VBR header are left out, since the decoder would try to decode them as audio.
+type frameStream struct {
+	reader   *mp3lib.FrameReader
+	template *mp3lib.MP3Frame // The first audio frame.
+	read     int              // Number of frames read.
+	buf      []byte           // Unread bytes of the current frame.
+	err      error            // Error which ended the stream, other than the end of the file.
+}
+
+func (s *frameStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		frame, err := s.reader.ReadFrame()
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, io.EOF
+		} else if err != nil {
+			s.err = err
+			return 0, err
+		}
+
+		s.read++
+		if s.read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+			continue
+		}
+		if frame.MPEGLayer != mp3lib.MPEGLayerIII {
+			s.err = conditionErrorf(errNotMP3, "found MPEG layer %v audio, but only layer III can be decoded",
+				layerName(frame.MPEGLayer))
+			return 0, s.err
+		}
+		if s.template == nil {
+			s.template = frame
+		}
+		s.buf = frame.RawBytes
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
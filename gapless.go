@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// The delay, in samples, of a standard MP3 decoder. Gapless players skip this many samples as
+// well as the encoder delay recorded in a LAME header, and this many fewer at the end.
+const decoderDelay = 529
+
+// The whole frames of encoder delay and padding to drop from an input file with --gapless.
+type gaplessTrim struct {
+	start int // Number of audio frames to drop from the start of the file.
+	end   int // Index of the first audio frame to drop at the end of the file, or -1 for none.
+}
+
+// Returns the frames to drop from an input file whose VBR header is [header], using the delay
+// and padding recorded by LAME-based encoders. Only whole frames of silence are dropped, so a
+// partial frame of delay or padding is kept. Drops nothing if the header has no LAME extension.
+func newGaplessTrim(header *mp3lib.MP3Frame) gaplessTrim {
+	trim := gaplessTrim{end: -1}
+	xing, err := mp3lib.ParseXingHeader(header)
+	if err != nil || xing.Encoder == "" || header.SampleCount == 0 {
+		return trim
+	}
+
+	trim.start = (xing.Delay + decoderDelay) / header.SampleCount
+	if xing.Flags&mp3lib.XingFramesFlag != 0 && xing.Padding > decoderDelay {
+		trim.end = int(xing.Frames) - (xing.Padding-decoderDelay)/header.SampleCount
+		if trim.end <= trim.start {
+			return gaplessTrim{end: -1}
+		}
+	}
+	return trim
+}
+
+// Returns true if the audio frame at [index] in the file should be dropped.
+func (trim gaplessTrim) drops(index int) bool {
+	return index < trim.start || (trim.end >= 0 && index >= trim.end)
+}
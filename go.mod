@@ -1,13 +1,13 @@
 module github.com/dmulholl/mp3cat
 
-go 1.21
-
-toolchain go1.21.7
+go 1.23
 
 require (
 	github.com/dmulholl/argo/v4 v4.0.0
-	github.com/dmulholl/mp3lib v1.0.0
+	golang.org/x/sys v0.17.0
 	golang.org/x/term v0.17.0
 )
 
-require golang.org/x/sys v0.17.0 // indirect
+require github.com/fsnotify/fsnotify v1.9.0
+
+require github.com/hajimehoshi/go-mp3 v0.3.4
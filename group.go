@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+var groupHelptext = fmt.Sprintf(`
+Usage: %s group <dir>
+
+  Merges the files in each subdirectory of a directory into an output file
+  of its own, named after the subdirectory, e.g. to merge a book whose
+  discs are folders of tracks into one file per disc.
+
+    $ mp3cat group book/ --out-dir merged/
+
+  Subdirectories, and the files in each, are merged in natural order.
+  Files at the top level of the directory aren't merged.
+
+  Each output keeps the album, artist, year, genre, and cover art from the
+  ID3 tag of the first file which has one, and gets its own title: its
+  subdirectory's name.
+
+  With --number-outputs, every output's name starts with its number, e.g.
+  '01 Disc One.mp3', padded with zeros so the outputs sort in order, and
+  each output's track number is set to 'n/total'.
+
+Arguments:
+  <dir>                   Directory of subdirectories to merge.
+
+Options:
+  --out-dir <path>        Directory for merged files. Defaults to <dir>.
+
+Flags:
+  -f, --force             Overwrite existing output files.
+  -h, --help              Display this help text and exit.
+  --include-hidden        Include hidden files and directories.
+  --number-outputs        Number the outputs' filenames and track numbers.
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+`, filepath.Base(os.Args[0]))
+
+// A subdirectory whose files are merged into one output by the 'group' command.
+type fileGroup struct {
+	name  string   // Name of the subdirectory.
+	files []string // The subdirectory's files, in natural order.
+}
+
+// IDs of the frames copied to each output of a run with several outputs, such as 'group' or
+// 'split': those which describe the recording as a whole, such as its album, artists, and cover
+// art, rather than one track.
+var albumFrameIDs = map[string]bool{
+	"TALB": true, "TPE1": true, "TPE2": true, "TCOM": true, "TCON": true, "TYER": true,
+	"TDRC": true, "TPOS": true, "TPUB": true, "TCOP": true, "APIC": true,
+}
+
+// Run the 'group' command. Returns the process exit code.
+func runGroup(ctx context.Context, parser *argo.ArgParser) int {
+	setQuiet(parser.Found("quiet"), false)
+
+	if len(parser.Args) != 1 {
+		printErrorf(errUsage, "you must specify a single directory to group")
+		return 1
+	}
+
+	dir := fixLongPath(parser.Args[0])
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		printErrorf(errFileNotFound, "the directory '%v' does not exist", dir)
+		return 1
+	}
+
+	outdir := fixLongPath(parser.StringValue("out-dir"))
+	if outdir == "" {
+		outdir = dir
+	}
+
+	groups, err := findGroups(dir, parser.Found("include-hidden"))
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	if len(groups) == 0 {
+		printErrorf(errNoFiles, "no subdirectories of '%v' contain MP3 files", dir)
+		return 1
+	}
+
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		printError(err)
+		return 1
+	}
+
+	number := parser.Found("number-outputs")
+	names := groupOutputNames(groups, number)
+	album := albumFrames(firstTag(groups))
+	for i, group := range groups {
+		frames := append(slices.Clone(album), mp3lib.NewTextFrame("TIT2", group.name))
+		if number {
+			frames = append(frames, mp3lib.NewTextFrame("TRCK", fmt.Sprintf("%v/%v", i+1, len(groups))))
+		}
+
+		outpath := filepath.Join(outdir, names[i])
+		if err := mergeGroup(ctx, group, outpath, newTag(frames), parser.Found("force")); err != nil {
+			printError(err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// Returns the subdirectories of [dir] which contain MP3 files, in natural order.
+func findGroups(dir string, includeHidden bool) ([]*fileGroup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var subdirs []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if !includeHidden && isHidden(path, info) {
+			continue
+		}
+		subdirs = append(subdirs, path)
+	}
+	sortNatural(subdirs)
+
+	var groups []*fileGroup
+	for _, subdir := range subdirs {
+		files, err := findFiles(subdir, "", includeHidden)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			groups = append(groups, &fileGroup{name: filepath.Base(subdir), files: files})
+		}
+	}
+	return groups, nil
+}
+
+// Returns the ID3v2 tag of the first file in [groups] which has one, or nil if none do.
+func firstTag(groups []*fileGroup) *mp3lib.ID3v2Tag {
+	for _, group := range groups {
+		for _, file := range group.files {
+			if tag := readTag(file); tag != nil {
+				return tag
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the frames of [tag] which are copied to each output of a run with several outputs.
+// Returns nil if [tag] is nil.
+func albumFrames(tag *mp3lib.ID3v2Tag) []*mp3lib.ID3v2Frame {
+	if tag == nil {
+		return nil
+	}
+	var frames []*mp3lib.ID3v2Frame
+	for _, frame := range copyableFrames(tag) {
+		if albumFrameIDs[frame.ID] {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// Returns the output filenames for [groups]. Outputs are named after their subdirectories. With
+// [number], every name starts with the output's number, padded with zeros to the width of the
+// last output's number, and at least two digits, so the names sort in order.
+func groupOutputNames(groups []*fileGroup, number bool) []string {
+	width := max(2, len(strconv.Itoa(len(groups))))
+
+	var names []string
+	for i, group := range groups {
+		name := group.name
+		if number {
+			name = fmt.Sprintf("%0*d %v", width, i+1, name)
+		}
+		names = append(names, name+".mp3")
+	}
+	return names
+}
+
+// Merge a group's files into the file at [outpath] with the given ID3v2 tag.
+func mergeGroup(ctx context.Context, group *fileGroup, outpath string, tag *mp3lib.ID3v2Tag, force bool) error {
+	if err := validateFiles(group.files); err != nil {
+		return err
+	}
+
+	lock, err := acquireLock(outpath, 0)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	return merge(ctx, group.files, &mergeOptions{
+		outpath: outpath,
+		tag:     tag,
+		force:   force,
+	})
+}
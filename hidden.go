@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Returns true if the file is hidden, i.e. its name begins with a dot. This covers macOS
+// metadata like '.DS_Store', '.AppleDouble' directories, and '._*' resource forks.
+func isHidden(path string, info os.FileInfo) bool {
+	return strings.HasPrefix(info.Name(), ".")
+}
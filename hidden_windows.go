@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Returns true if the file is hidden, i.e. its name begins with a dot or it has the hidden or
+// system attribute set. Dot-files turn up on Windows when copying from macOS, e.g. '._*' resource
+// forks.
+func isHidden(path string, info os.FileInfo) bool {
+	if strings.HasPrefix(info.Name(), ".") {
+		return true
+	}
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		mask := uint32(syscall.FILE_ATTRIBUTE_HIDDEN | syscall.FILE_ATTRIBUTE_SYSTEM)
+		return data.FileAttributes&mask != 0
+	}
+	return false
+}
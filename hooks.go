@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Run a user-supplied hook command through the system shell, with [vars] added to its
+// environment. The command's output goes to our stdout and stderr. Returns an error if the
+// command fails to run or exits with a non-zero status.
+func runHook(ctx context.Context, option, command string, vars map[string]string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for key, value := range vars {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	printDebug("running %v hook: %v", option, command)
+	if err := cmd.Run(); err != nil {
+		return conditionErrorf(errHook, "the %v command failed: %w", option, err)
+	}
+	return nil
+}
+
+// Environment variables describing a merge, passed to every hook.
+func hookVars(outpath string, inpaths []string) map[string]string {
+	return map[string]string{
+		"MP3CAT_OUTPUT":      outpath,
+		"MP3CAT_INPUT_COUNT": strconv.Itoa(len(inpaths)),
+		"MP3CAT_INPUTS":      strings.Join(inpaths, "\n"),
+	}
+}
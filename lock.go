@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often we check a busy lock file when waiting for another process to finish.
+const lockPollInterval = 250 * time.Millisecond
+
+// An advisory lock on an output file, held by creating a '.mp3cat.lock' file alongside it.
+type outputLock struct {
+	path string
+}
+
+// Acquire an advisory lock on the output file at [outpath]. If another process holds the lock,
+// keep trying for up to [wait] before giving up. A zero [wait] fails immediately. A lock left
+// behind by a process which is no longer running, e.g. after a crash, is taken over.
+func acquireLock(outpath string, wait time.Duration) (*outputLock, error) {
+	lockpath := outpath + ".mp3cat.lock"
+	deadline := time.Now().Add(wait)
+
+	for {
+		file, err := os.OpenFile(lockpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return &outputLock{path: lockpath}, nil
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		if isStaleLock(lockpath) {
+			removed, err := removeStaleLock(lockpath)
+			if err != nil {
+				return nil, err
+			}
+			if removed {
+				continue
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, conditionErrorf(errLocked,
+				"the file '%v' is locked by another mp3cat process (delete '%v' if the lock is stale)",
+				outpath, lockpath)
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Remove the lock file at [lockpath], which was found to be stale, so a new one can be created
+// in its place. Processes which find the same stale lock at once mustn't remove each other's new
+// locks, so the lock is only removed by the process which exclusively creates a '.takeover' file
+// alongside it, and only if it's still stale once that file is held. Returns false if another
+// process holds the '.takeover' file, so the caller should wait and try again.
+func removeStaleLock(lockpath string) (bool, error) {
+	guardpath := lockpath + ".takeover"
+	guard, err := os.OpenFile(guardpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	guard.Close()
+	defer os.Remove(guardpath)
+
+	// Another process may have taken over the lock since we checked it.
+	if !isStaleLock(lockpath) {
+		return true, nil
+	}
+
+	printDebug("removing the stale lock file '%v'", lockpath)
+	if err := os.Remove(lockpath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release the lock by deleting the lock file.
+func (lock *outputLock) release() {
+	os.Remove(lock.path)
+}
+
+// Returns true if the lock file at [lockpath] was left by a process which is no longer running.
+// A lock file without a PID may be in the middle of being written, so it isn't stale.
+func isStaleLock(lockpath string) bool {
+	data, err := os.ReadFile(lockpath)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	return !processExists(pid)
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package main
+
+// Processes can't be checked on this platform, so a lock is never treated as stale.
+func processExists(pid int) bool {
+	return true
+}
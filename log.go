@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// All output other than command results goes through this logger. The default text format
+// reproduces mp3cat's traditional console output; the JSON format writes one object per line
+// to stderr.
+var logger = slog.New(newTextHandler(logLevel))
+
+// The minimum level of message to output.
+var logLevel = new(slog.LevelVar)
+
+// A level above all others, used to silence the logger completely.
+const levelSilent = slog.LevelError + 4
+
+// Whether log records are being written as JSON.
+var jsonLogs bool
+
+// Whether to use ANSI colors on stdout and stderr.
+var colorStdout, colorStderr = colorEnabled("auto", os.Stdout), colorEnabled("auto", os.Stderr)
+
+// ANSI escape codes.
+const (
+	ansiGray   = "\u001B[90m"
+	ansiRed    = "\u001B[31m"
+	ansiYellow = "\u001B[33m"
+	ansiReset  = "\u001B[0m"
+)
+
+// Configure colored output from the --color option: 'auto', 'always', or 'never'.
+func setupColor(mode string) error {
+	switch strings.ToLower(mode) {
+	case "auto", "always", "never", "":
+	default:
+		return conditionErrorf(errUsage, "invalid color mode '%v', expected auto, always, or never", mode)
+	}
+	colorStdout = colorEnabled(mode, os.Stdout)
+	colorStderr = colorEnabled(mode, os.Stderr)
+	return nil
+}
+
+// Returns true if output to [file] should be colored. In 'auto' mode we only use color for a
+// terminal, and not at all if the NO_COLOR environment variable is set (https://no-color.org),
+// if TERM is 'dumb', or on Windows, where older consoles don't support ANSI codes.
+func colorEnabled(mode string, file *os.File) bool {
+	switch strings.ToLower(mode) {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || runtime.GOOS == "windows" {
+		return false
+	}
+	return term.IsTerminal(int(file.Fd()))
+}
+
+// Wraps [text] in an ANSI color code if [enabled] is true.
+func colorize(text, code string, enabled bool) string {
+	if !enabled {
+		return text
+	}
+	return code + text + ansiReset
+}
+
+// Configure the logger from the --log-level and --log-format options. Quiet mode raises the
+// level to 'warn'; silent mode turns off all output.
+func setupLogging(level, format string, quiet, silent bool) error {
+	var minLevel slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		minLevel = slog.LevelDebug
+	case "info", "":
+		minLevel = slog.LevelInfo
+	case "warn", "warning":
+		minLevel = slog.LevelWarn
+	case "error":
+		minLevel = slog.LevelError
+	default:
+		return conditionErrorf(errUsage, "invalid log level '%v', expected debug, info, warn, or error", level)
+	}
+	logLevel.Set(minLevel)
+	setQuiet(quiet, silent)
+
+	switch strings.ToLower(format) {
+	case "text", "":
+		logger = slog.New(newTextHandler(logLevel))
+		jsonLogs = false
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+		jsonLogs = true
+	default:
+		return conditionErrorf(errUsage, "invalid log format '%v', expected text or json", format)
+	}
+	return nil
+}
+
+// Raise the log level for quiet or silent mode. Commands with their own --quiet and --silent
+// flags call this after parsing them.
+func setQuiet(quiet, silent bool) {
+	if silent {
+		logLevel.Set(levelSilent)
+	} else if quiet {
+		logLevel.Set(max(logLevel.Level(), slog.LevelWarn))
+	}
+}
+
+// Log an error message with the code of the condition it reports, if any.
+func printError(err error) {
+	cond := errorCondition(err)
+	if errors.Is(err, context.Canceled) {
+		err = errors.New("interrupted")
+	}
+	logger.Error(err.Error(), "code", cond.code, "condition", cond.name)
+}
+
+// Log a formatted error message reporting [cond].
+func printErrorf(cond condition, format string, args ...any) {
+	logger.Error(fmt.Sprintf(format, args...), "code", cond.code, "condition", cond.name)
+}
+
+// Log a warning message reporting [cond].
+func printWarning(cond condition, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	logger.Warn(message, "code", cond.code, "condition", cond.name)
+
+	warnings.mutex.Lock()
+	defer warnings.mutex.Unlock()
+	if warnings.recording {
+		warnings.messages = append(warnings.messages, cond.code+": "+message)
+	}
+}
+
+// Warnings logged since recording was turned on, kept for --report.
+var warnings struct {
+	mutex     sync.Mutex
+	recording bool
+	messages  []string
+}
+
+// Start keeping a copy of each warning logged.
+func recordWarnings() {
+	warnings.mutex.Lock()
+	defer warnings.mutex.Unlock()
+	warnings.recording = true
+}
+
+// Returns the warnings logged since recording was turned on.
+func recordedWarnings() []string {
+	warnings.mutex.Lock()
+	defer warnings.mutex.Unlock()
+	return append([]string(nil), warnings.messages...)
+}
+
+// Log an informational message.
+func printInfo(format string, args ...any) {
+	logger.Info(fmt.Sprintf(format, args...))
+}
+
+// Log the start of work on an input file.
+func printFile(path string) {
+	logger.Info("processing file", "file", path)
+}
+
+// Log a debugging message.
+func printDebug(format string, args ...any) {
+	logger.Debug(fmt.Sprintf(format, args...))
+}
+
+// Log the byte offset and timestamp at which an input file starts in the output. This is a
+// debugging message in text mode, but an info record in JSON mode so scripts building chapter
+// marks or cue sheets can pick it up without the rest of the debugging output.
+func printInputStart(path string, offset int64, timestamp time.Duration) {
+	if jsonLogs {
+		logger.Info("input start", "file", path, "offset", offset, "timestamp", timestamp.Seconds())
+		return
+	}
+	printDebug("'%v' starts at %v, byte offset %v", path, formatTimestamp(timestamp), offset)
+}
+
+// Print a line to stdout if we're running in a terminal and writing text logs.
+func printLine() {
+	if jsonLogs || !logger.Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return
+	}
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return
+	}
+	char := "─"
+	if runtime.GOOS == "windows" && !colorStdout {
+		char = "-"
+	}
+	fmt.Println(colorize(strings.Repeat(char, width), ansiGray, colorStdout))
+}
+
+// A slog handler which writes records in mp3cat's console style: info messages to stdout as
+// '• message' (or '+ file' for input files), warnings and errors to stderr as 'Warning W001: ...'
+// and 'Error E001: ...'.
+type textHandler struct {
+	level  slog.Leveler
+	mutex  *sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func newTextHandler(level slog.Leveler) *textHandler {
+	return &textHandler{level: level, mutex: &sync.Mutex{}, stdout: os.Stdout, stderr: os.Stderr}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	var file, code string
+	record.Attrs(func(attr slog.Attr) bool {
+		switch attr.Key {
+		case "file":
+			file = attr.Value.String()
+		case "code":
+			code = " " + attr.Value.String()
+		}
+		return true
+	})
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var err error
+	switch {
+	case record.Level >= slog.LevelError:
+		_, err = fmt.Fprintf(h.stderr, "%s %s.\n", colorize("Error"+code+":", ansiRed, colorStderr), record.Message)
+	case record.Level >= slog.LevelWarn:
+		_, err = fmt.Fprintf(h.stderr, "%s %s.\n", colorize("Warning"+code+":", ansiYellow, colorStderr), record.Message)
+	case record.Level >= slog.LevelInfo && file != "":
+		_, err = fmt.Fprintf(h.stdout, "+ %s\n", file)
+	case record.Level >= slog.LevelInfo:
+		_, err = fmt.Fprintf(h.stdout, "• %s\n", record.Message)
+	default:
+		_, err = fmt.Fprintf(h.stderr, "DEBUG: %s\n", record.Message)
+	}
+	return err
+}
+
+func (h *textHandler) WithAttrs(_ []slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// Long paths only need special handling on Windows.
+func fixLongPath(path string) string {
+	return path
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Paths of MAX_PATH (260) characters or more need the extended-length '\\?\' prefix on
+// Windows. The os package adds the prefix itself to long absolute paths with a drive letter,
+// but leaves relative paths and '\\server\share' UNC paths alone, so we make long relative paths
+// absolute and give long UNC paths the '\\?\UNC\' prefix. Directory names are limited to 248
+// characters to leave room for an 8.3 filename.
+func fixLongPath(path string) string {
+	if path == "" || strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path
+	}
+
+	abspath, err := filepath.Abs(path)
+	if err != nil || len(abspath) < 248 {
+		return path
+	}
+
+	// Extended-length paths are passed to the filesystem unparsed, so they can't contain
+	// forward slashes or '.' and '..' elements. Abs() cleans the path for us.
+	if strings.HasPrefix(abspath, `\\`) {
+		return `\\?\UNC\` + abspath[2:]
+	}
+	return abspath
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns interleaved samples of a 1 kHz sine wave with the given peak level in dBFS, the same in
+// each channel.
+func sineSamples(sampleRate, channels int, seconds, level float64) []float64 {
+	amplitude := math.Pow(10, level/20)
+	count := int(seconds * float64(sampleRate))
+	samples := make([]float64, 0, count*channels)
+	for i := range count {
+		sample := amplitude * math.Sin(2*math.Pi*1000*float64(i)/float64(sampleRate))
+		for range channels {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// EBU Tech 3341's first test case: a stereo 1 kHz sine at -23 dBFS measures -23 LUFS.
+func TestLoudnessReferenceTone(t *testing.T) {
+	for _, sampleRate := range []int{32000, 44100, 48000} {
+		meter := newLoudnessMeter(sampleRate, 2)
+		meter.Add(sineSamples(sampleRate, 2, 20, -23))
+		if loudness := meter.Loudness(); math.Abs(loudness-(-23)) > 0.1 {
+			t.Errorf("%v Hz: loudness = %.2f LUFS, want -23", sampleRate, loudness)
+		}
+		if peak := meter.Peak(); math.Abs(peak-math.Pow(10, -23.0/20)) > 0.001 {
+			t.Errorf("%v Hz: peak = %.4f", sampleRate, peak)
+		}
+	}
+}
+
+// A mono channel counts once, so it measures 3 LU quieter than the same audio in stereo.
+func TestLoudnessMono(t *testing.T) {
+	meter := newLoudnessMeter(44100, 1)
+	meter.Add(sineSamples(44100, 1, 10, -23))
+	if loudness := meter.Loudness(); math.Abs(loudness-(-26.01)) > 0.1 {
+		t.Errorf("loudness = %.2f LUFS, want -26.01", loudness)
+	}
+}
+
+// Silence and audio far below the rest are gated out, so they don't change the loudness.
+func TestLoudnessGating(t *testing.T) {
+	meter := newLoudnessMeter(48000, 2)
+	meter.Add(make([]float64, 48000*2*10))
+	if loudness := meter.Loudness(); !math.IsInf(loudness, -1) {
+		t.Errorf("silence: loudness = %.2f LUFS, want -Inf", loudness)
+	}
+
+	meter.Add(sineSamples(48000, 2, 10, -36))
+	meter.Add(sineSamples(48000, 2, 10, -23))
+	if loudness := meter.Loudness(); math.Abs(loudness-(-23)) > 0.1 {
+		t.Errorf("loudness = %.2f LUFS, want -23", loudness)
+	}
+}
+
+// Samples added in pieces are measured the same as when added at once.
+func TestLoudnessChunked(t *testing.T) {
+	samples := sineSamples(44100, 2, 5, -20)
+	whole := newLoudnessMeter(44100, 2)
+	whole.Add(samples)
+
+	chunked := newLoudnessMeter(44100, 2)
+	for i := 0; i < len(samples); i += 1154 {
+		chunked.Add(samples[i:min(i+1154, len(samples))])
+	}
+
+	if len(whole.blocks) != len(chunked.blocks) || whole.Loudness() != chunked.Loudness() {
+		t.Errorf("chunked: %v blocks, %v LUFS; whole: %v blocks, %v LUFS",
+			len(chunked.blocks), chunked.Loudness(), len(whole.blocks), whole.Loudness())
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"os"
+	"time"
+)
+
+// A record of a merge, written with --write-manifest so the output can later be traced back to
+// the exact input files which produced it.
+type manifest struct {
+	Version   string           `json:"version"`
+	Created   *time.Time       `json:"created,omitempty"` // Left out with --reproducible.
+	Algorithm string           `json:"algorithm"`
+	Output    manifestFile     `json:"output"`
+	Inputs    []*manifestInput `json:"inputs"`
+}
+
+// A file in a manifest.
+type manifestFile struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Frames   uint64 `json:"frames"`
+	Checksum string `json:"checksum"`
+
+	hasher hash.Hash
+}
+
+// An input file in a manifest, with the position and length of its audio in the output. Times
+// are in seconds; the offset is in bytes from the start of the output file.
+type manifestInput struct {
+	manifestFile
+	Offset   int64   `json:"offset"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+	Bytes    uint64  `json:"bytes"`
+	Bitrate  int     `json:"bitrate"`
+	Garbage  bool    `json:"garbage"`
+}
+
+// Finalises the file's checksum from its hasher.
+func (file *manifestFile) finish() {
+	if file.hasher != nil {
+		file.Checksum = hex.EncodeToString(file.hasher.Sum(nil))
+	}
+}
+
+// Writes the manifest to [path] as indented JSON.
+func writeManifest(path string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/dmulholl/argo/v4"
-	"github.com/dmulholl/mp3lib"
-	"golang.org/x/term"
+	"github.com/dmulholl/mp3cat/mp3lib"
 )
 
 const version = "4.3.0"
@@ -27,19 +36,198 @@ Usage: %s [files]
 
     $ mp3cat --dir /path/to/directory
 
+  Files found with --dir, or by glob patterns which reach mp3cat unexpanded
+  (e.g. on Windows), are merged in case-insensitive natural order, so
+  'Track 2.mp3' comes before 'track 10.mp3' on every platform.
+
+  MPEG layer I and II files (.mp1, .mp2) can be concatenated in the same way.
+
+  On Windows, double-clicking mp3cat.exe merges the files in its folder, and
+  dropping files onto it merges those files. The output is named after the
+  folder, e.g. 'Dune.mp3', and mp3cat asks before merging.
+
 Arguments:
   [files]                 List of files to merge.
 
 Options:
+  --ape <n>               Copy the APEv2 tag from the n-th input file. APEv2
+                          tags are dropped by default.
+  --color <when>          Use colored output: 'auto', 'always', or 'never'.
+                          Defaults to 'auto', which respects NO_COLOR.
+  --chapter-art <dir>     Directory of images for --chapters, named after the
+                          input files or numbered by chapter, e.g. '03.jpg'.
+                          Implies --chapters.
+  --chapter-urls <path>   File of links for --chapters, one per line: a
+                          chapter number or input filename, then a URL, e.g.
+                          '3 https://example.com/notes#3'. Implies --chapters.
+  --checksum <alg>        Print a checksum of the output file, computed while
+                          it's written. Supports 'sha256', 'sha1', and 'md5'.
+  --comment <text>        Set a comment (COMM) on the output, replacing any
+                          comment with the same language and description.
+  --comment-desc <text>   Description of the --comment. Defaults to none.
+  --comment-lang <code>   Language of the --comment, as a 3-letter ISO 639-2
+                          code. Defaults to 'eng'.
   -d, --dir <path>        Directory of files to merge.
+  --embed-tracklist <f>   Add a list of the input files' titles and start
+                          times to the output's tag, for players without
+                          chapter support: 'uslt' as lyrics, 'comment' as a
+                          comment, or 'off'. Defaults to 'off'.
+  --genre <genre>         Set the output's genre (TCON), as a name or an ID3v1
+                          genre code, e.g. 'Audiobook' or '183'.
+  --log-format <f>        Output format for messages, 'text' or 'json'. JSON
+                          messages are written to stderr, one per line, and
+                          include the offset and timestamp at which each
+                          input starts in the output. Warnings and errors
+                          have a 'code' field (see 'mp3cat help codes').
+  --log-level <level>     Minimum level of message to output: 'debug', 'info',
+                          'warn', or 'error'. Defaults to 'info'. The 'debug'
+                          level reports where each input starts.
   -m, --meta <n>          Copy ID3 metadata from the n-th input file.
+  --max-resync <size>     Give up on an input file after skipping this much
+                          unrecognised data in a row, e.g. '1M'. By default
+                          there's no limit.
+  --max-tag-read <size>   Largest ID3v2 or APEv2 tag to read from an input file.
+                          Guards against corrupt tag headers. Defaults to 64M.
+                          Use 0 for no limit.
+  --max-tag-size <size>   Drop the largest frames from the tag copied with
+                          --meta until it fits in this size, e.g. '64k'.
+  --min-size <size>       Skip input files smaller than this size, e.g. '64k'.
+  --normalize-crc <mode>  How to handle a mix of CRC-protected and unprotected
+                          inputs: 'warn', 'error', or 'strip' to remove the
+                          CRCs from protected frames. Defaults to 'warn'.
+  --on-file <cmd>         Run a shell command after each input file is added.
+                          The file's path is in $MP3CAT_FILE, its position in
+                          $MP3CAT_FILE_INDEX, and its frame count in
+                          $MP3CAT_FILE_FRAMES.
   -o, --out <path>        Output filepath. Defaults to 'output.mp3'.
+  --post-exec <cmd>       Run a shell command after the merge. $MP3CAT_STATUS
+                          is 'ok' or 'error', with the error in $MP3CAT_ERROR.
+  --pre-exec <cmd>        Run a shell command before the merge. If it fails, the
+                          merge is aborted.
+  --read-buffer <size>    Size of the buffer for reading each input file.
+                          Defaults to 4M.
+  --report <path>         Write a summary of the merge, with the input files,
+                          output, chapters, and warnings, as HTML if the path
+                          ends in '.html', or as Markdown otherwise.
+  --require-channels <c>  Abort unless all input files are 'mono' or 'stereo'.
+  --require-layer <n>     Abort unless all input files are MPEG layer n audio,
+                          e.g. 2 for MP2 files.
+  --require-samplerate <n>
+                          Abort unless all input files have a sample rate of
+                          n Hz, e.g. 44100.
+  --skip-longer-than <time>
+                          Skip input files longer than this, e.g. '2h'.
+  --skip-shorter-than <time>
+                          Skip input files shorter than this, e.g. '3s'.
+  --tag-encoding <enc>    Text encoding of the tags mp3cat writes: 'utf8',
+                          'utf16', or 'latin1'. UTF-8 tags are written as
+                          ID3v2.4, others as ID3v2.3. By default, text is
+                          Latin-1 where possible and UTF-16 otherwise.
+  -t, --tmpdir <path>     Directory for temporary files. Defaults to the
+                          output file's directory.
+  --touch <timestamp>     Set the output file's modification time. Accepts
+                          RFC 3339 timestamps, 'YYYY-MM-DD HH:MM:SS' local
+                          times, or Unix timestamps in seconds.
+  --write-buffer <size>   Size of the buffer for writing the output file.
+                          Defaults to 4M.
+  --write-manifest <path> Write a JSON manifest recording the size, frame count,
+                          and checksum of each input file and the output, and
+                          where each input's audio starts in the output.
+  --write-toc <path>      Write a table of contents listing the timestamp,
+                          filename, and title at which each input starts.
+  --verify-checksums <path>
+                          Abort unless every input file matches its digest in
+                          a sha256sum, sha1sum, or md5sum checksum file.
+  -w, --wait <n>          Wait up to n seconds for another mp3cat process
+                          writing to the same output file to finish. A lock
+                          left by a process which has exited is removed.
 
 Flags:
+  --align-frames          Insert a silent frame before any input file which
+                          starts by using audio data from the end of the
+                          previous file (the bit reservoir), so the data it
+                          borrows is silence. Ignored with --fix-reservoir.
+  --auto-tags             Without --meta, tag the output with the name of the
+                          input files' directory as its album and the output's
+                          filename as its title.
+  -b, --backup            When overwriting an existing output file, keep the
+                          previous version as '<out>.bak'.
+  --chapters              Add an ID3v2 chapter for each input file, titled with
+                          its title or filename, with its cover art if it has
+                          any. Keeps the tag copied with --meta.
+  --dedupe                Skip input files which duplicate an earlier file,
+                          by path or by content. By default duplicates are
+                          merged with a warning.
   -f, --force             Overwrite an existing output file.
+  --fix-reservoir         Stop the first frame of each input file from using
+                          audio data from the end of the previous file (the
+                          bit reservoir). Trades a glitch for a brief dropout.
+  --gapless               Drop the whole frames of silence which encoders add
+                          at the start and end of each file, using the delay
+                          and padding in LAME headers, to shorten the gaps
+                          between files.
   -h, --help              Display this help text and exit.
-  -q, --quiet             Quiet mode. Only output error messages.
+  --include-hidden        Include hidden files and directories when scanning
+                          a directory with --dir.
+  --info-header           Add an 'Info' header recording the frame and byte
+                          counts to constant bitrate output, as LAME does. VBR
+                          output always gets a VBR header.
+  --keep-going            Skip input files which don't exist or can't be read,
+                          and keep the audio read from a file before a read
+                          error, instead of stopping at the first problem.
+  --keep-headers          Copy every frame of the input files, including their
+                          VBR headers, and don't add a VBR header to the
+                          output. For byte-faithful copies of the audio.
+  -p, --preserve-times    Set the output file's modification time to the
+                          latest modification time of the input files.
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+  -s, --silent            Silent mode. Output nothing, not even errors; check
+                          the exit status instead.
+  --reproducible          Leave the creation time out of the manifest and
+                          report, so the same input files and options always
+                          give byte-identical output files.
+  --require-cbr           Abort unless all input files share a single constant
+                          bitrate.
+  --strict                Abort if the input files have different sample
+                          rates, channel layouts, MPEG versions, or MPEG
+                          layers. (By default, this only triggers a warning.)
+  --strict-parse          Abort if an input file contains garbage data between
+                          frames, a truncated final frame, or a frame which
+                          fails its CRC check.
+  --strip-art             Drop images from the tag copied with --meta.
+  --verify-output         After writing the output file, read it back and check
+                          its frames and VBR header match what was written.
   -v, --version           Display the version number and exit.
+  --warn-duplicates       Warn about input files which appear to contain the
+                          same recording as an earlier file, e.g. a second
+                          download of an episode with a different filename.
+
+Hook commands run with $MP3CAT_OUTPUT set to the output path, $MP3CAT_INPUTS
+to the newline-separated input paths, and $MP3CAT_INPUT_COUNT to their number.
+
+Environment:
+  The main command's options and flags can also be set with environment
+  variables, e.g. MP3CAT_OUT=merged.mp3, MP3CAT_MIN_SIZE=64k, or
+  MP3CAT_QUIET=1. Arguments on the command line take precedence.
+
+Commands:
+  clean                   Strip tags and garbage data from a tree of files.
+  codes                   List the codes of warnings and errors.
+  extract-meta            Write a file's images, tag, and text frames to disk.
+  gain                    Measure the loudness of a batch of files for ReplayGain.
+  group                   Merge each subdirectory of a folder into its own file.
+  probe                   Describe the structure of MP3 files.
+  repair                  Remove garbage data and damaged frames from a file.
+  retag                   Set the same tags on a batch of files.
+  serve                   Run an HTTP server which merges files on request.
+  split                   Split a file into parts at its silent gaps or chapters.
+  tui                     Pick, reorder, and merge files interactively.
+  version                 Print the version number and build metadata.
+  verify                  Check files for corrupt or truncated frames.
+  watch                   Merge batches of files dropped into a folder.
+
+Command Help:
+  help <command>          Print the specified command's help text and exit.
 `, filepath.Base(os.Args[0]))
 
 func main() {
@@ -48,44 +236,347 @@ func main() {
 	parser.Helptext = helptext
 	parser.Version = version
 	parser.NewFlag("force f")
+	parser.NewFlag("backup b")
 	parser.NewFlag("quiet q")
+	parser.NewFlag("silent s")
 	parser.NewFlag("debug")
+	parser.NewFlag("preserve-times p")
+	parser.NewFlag("include-hidden")
 	parser.NewStringOption("out o", "output.mp3")
 	parser.NewStringOption("dir d", "")
 	parser.NewStringOption("interlace i", "")
+	parser.NewStringOption("tmpdir t", "")
+	parser.NewStringOption("touch", "")
+	parser.NewStringOption("min-size", "")
 	parser.NewIntOption("meta m", 0)
+	parser.NewIntOption("ape", 0)
+	parser.NewIntOption("wait w", 0)
+	parser.NewIntOption("require-samplerate", 0)
+	parser.NewStringOption("require-channels", "")
+	parser.NewStringOption("require-layer", "")
+	parser.NewStringOption("log-level", "info")
+	parser.NewStringOption("color", "auto")
+	parser.NewStringOption("checksum", "")
+	parser.NewStringOption("write-manifest", "")
+	parser.NewStringOption("write-toc", "")
+	parser.NewStringOption("report", "")
+	parser.NewStringOption("verify-checksums", "")
+	parser.NewStringOption("log-format", "text")
+	parser.NewStringOption("normalize-crc", "warn")
+	parser.NewStringOption("chapter-art", "")
+	parser.NewStringOption("chapter-urls", "")
+	parser.NewStringOption("max-tag-size", "")
+	parser.NewStringOption("genre", "")
+	parser.NewStringOption("tag-encoding", "")
+	parser.NewStringOption("comment", "")
+	parser.NewStringOption("comment-lang", "eng")
+	parser.NewStringOption("comment-desc", "")
+	parser.NewStringOption("embed-tracklist", "off")
+	parser.NewStringOption("skip-shorter-than", "")
+	parser.NewStringOption("skip-longer-than", "")
+	parser.NewFlag("require-cbr")
+	parser.NewFlag("strict")
+	parser.NewFlag("strict-parse")
+	parser.NewFlag("fix-reservoir")
+	parser.NewFlag("align-frames")
+	parser.NewFlag("dedupe")
+	parser.NewFlag("warn-duplicates")
+	parser.NewFlag("verify-output")
+	parser.NewFlag("info-header")
+	parser.NewFlag("keep-headers")
+	parser.NewFlag("chapters")
+	parser.NewFlag("auto-tags")
+	parser.NewFlag("strip-art")
+	parser.NewFlag("gapless")
+	parser.NewFlag("reproducible")
+	parser.NewFlag("keep-going")
+	parser.NewStringOption("pre-exec", "")
+	parser.NewStringOption("post-exec", "")
+	parser.NewStringOption("on-file", "")
+	parser.NewStringOption("read-buffer", "4M")
+	parser.NewStringOption("write-buffer", "4M")
+	parser.NewStringOption("max-tag-read", "64M")
+	parser.NewStringOption("max-resync", "")
+
+	verifyParser := parser.NewCommand("verify")
+	verifyParser.Helptext = verifyHelptext
+	verifyParser.NewFlag("quiet q")
+	verifyParser.NewFlag("fix")
+	verifyParser.NewStringOption("tmpdir t", "")
+	verifyParser.NewIntOption("workers", 1)
+
+	cleanParser := parser.NewCommand("clean")
+	cleanParser.Helptext = cleanHelptext
+	cleanParser.NewStringOption("dir d", "")
+	cleanParser.NewStringOption("out-dir o", "")
+	cleanParser.NewIntOption("workers", 1)
+	cleanParser.NewFlag("keep-tags")
+	cleanParser.NewFlag("include-hidden")
+	cleanParser.NewFlag("force f")
+	cleanParser.NewFlag("quiet q")
+
+	codesParser := parser.NewCommand("codes")
+	codesParser.Helptext = codesHelptext
+
+	extractParser := parser.NewCommand("extract-meta")
+	extractParser.Helptext = extractHelptext
+	extractParser.NewStringOption("out-dir o", "")
+	extractParser.NewFlag("force f")
+	extractParser.NewFlag("quiet q")
+
+	gainParser := parser.NewCommand("gain")
+	gainParser.Helptext = gainHelptext
+	gainParser.NewStringOption("dir d", "")
+	gainParser.NewStringOption("sidecar", "")
+	gainParser.NewFlag("scan")
+	gainParser.NewFlag("write-tags")
+	gainParser.NewFlag("include-hidden")
+	gainParser.NewFlag("quiet q")
+
+	groupParser := parser.NewCommand("group")
+	groupParser.Helptext = groupHelptext
+	groupParser.NewStringOption("out-dir", "")
+	groupParser.NewFlag("force f")
+	groupParser.NewFlag("include-hidden")
+	groupParser.NewFlag("number-outputs")
+	groupParser.NewFlag("quiet q")
+
+	probeParser := parser.NewCommand("probe")
+	probeParser.Helptext = probeHelptext
+	probeParser.NewIntOption("workers", 1)
+	probeParser.NewFlag("json")
+
+	repairParser := parser.NewCommand("repair")
+	repairParser.Helptext = repairHelptext
+	repairParser.NewFlag("force f")
+	repairParser.NewFlag("quiet q")
+	repairParser.NewFlag("silent s")
+	repairParser.NewStringOption("out o", "")
+	repairParser.NewStringOption("tmpdir t", "")
+
+	retagParser := parser.NewCommand("retag")
+	retagParser.Helptext = retagHelptext
+	retagParser.NewStringOption("dir d", "")
+	retagParser.NewStringOption("album", "")
+	retagParser.NewStringOption("artist", "")
+	retagParser.NewStringOption("album-artist", "")
+	retagParser.NewStringOption("genre", "")
+	retagParser.NewStringOption("tag-encoding", "")
+	retagParser.NewStringOption("comment", "")
+	retagParser.NewStringOption("comment-lang", "eng")
+	retagParser.NewStringOption("comment-desc", "")
+	retagParser.NewStringOption("tmpdir t", "")
+	retagParser.NewFlag("number")
+	retagParser.NewFlag("include-hidden")
+	retagParser.NewFlag("quiet q")
 
-	if err := parser.ParseOsArgs(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %s.\n", err)
+	serveParser := parser.NewCommand("serve")
+	serveParser.Helptext = serveHelptext
+	serveParser.NewStringOption("listen l", "127.0.0.1:8080")
+	serveParser.NewStringOption("root r", ".")
+	serveParser.NewStringOption("max-upload", "1G")
+
+	splitParser := parser.NewCommand("split")
+	splitParser.Helptext = splitHelptext
+	splitParser.NewStringOption("out-dir o", "")
+	splitParser.NewStringOption("on-silence", "")
+	splitParser.NewStringOption("threshold", "-50dB")
+	splitParser.NewFlag("by-chapters")
+	splitParser.NewFlag("force f")
+	splitParser.NewFlag("number-outputs")
+	splitParser.NewFlag("quiet q")
+
+	tuiParser := parser.NewCommand("tui")
+	tuiParser.Helptext = tuiHelptext
+	tuiParser.NewStringOption("dir d", "")
+	tuiParser.NewStringOption("out o", "output.mp3")
+	tuiParser.NewFlag("force f")
+	tuiParser.NewFlag("include-hidden")
+
+	watchParser := parser.NewCommand("watch")
+	watchParser.Helptext = watchHelptext
+	watchParser.NewStringOption("dir d", "")
+	watchParser.NewStringOption("out-dir", "")
+	watchParser.NewStringOption("name n", "{batch}.mp3")
+	watchParser.NewIntOption("settle", 10)
+	watchParser.NewFlag("include-hidden")
+
+	versionParser := parser.NewCommand("version")
+	versionParser.Helptext = versionHelptext
+	versionParser.NewFlag("verbose v")
+
+	if err := parser.Parse(argsWithEnv(os.Args)); err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+
+	// Keep the warnings for the --report summary.
+	if parser.Found("report") {
+		recordWarnings()
+	}
+
+	if err := setupColor(parser.StringValue("color")); err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+
+	err := setupLogging(
+		parser.StringValue("log-level"),
+		parser.StringValue("log-format"),
+		parser.Found("quiet"),
+		parser.Found("silent"))
+	if err != nil {
+		printError(err)
 		os.Exit(1)
 	}
 
+	// Cancel the context on an interrupt signal so we can clean up before exiting. A second
+	// signal kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Run the command if one was found.
+	switch parser.FoundCommandName {
+	case "verify":
+		os.Exit(runVerify(ctx, parser.FoundCommandParser))
+	case "clean":
+		os.Exit(runClean(ctx, parser.FoundCommandParser))
+	case "codes":
+		os.Exit(runCodes(parser.FoundCommandParser))
+	case "extract-meta":
+		os.Exit(runExtractMeta(ctx, parser.FoundCommandParser))
+	case "gain":
+		os.Exit(runGain(ctx, parser.FoundCommandParser))
+	case "group":
+		os.Exit(runGroup(ctx, parser.FoundCommandParser))
+	case "probe":
+		os.Exit(runProbe(ctx, parser.FoundCommandParser))
+	case "repair":
+		os.Exit(runRepair(ctx, parser.FoundCommandParser))
+	case "retag":
+		os.Exit(runRetag(ctx, parser.FoundCommandParser))
+	case "serve":
+		os.Exit(runServe(ctx, parser.FoundCommandParser))
+	case "split":
+		os.Exit(runSplit(ctx, parser.FoundCommandParser))
+	case "tui":
+		os.Exit(runTUI(ctx, parser.FoundCommandParser))
+	case "watch":
+		os.Exit(runWatch(ctx, parser.FoundCommandParser))
+	case "version":
+		os.Exit(runVersion(parser.FoundCommandParser))
+	}
+
+	// Started from Explorer, there's no command line to read the usage from and the console
+	// window closes as soon as mp3cat exits.
+	if isDoubleClickLaunch(os.Args[1:]) {
+		exitCode := runDoubleClick(ctx, parser.Args)
+		waitForEnter()
+		os.Exit(exitCode)
+	}
+
+	outpath := fixLongPath(parser.StringValue("out"))
+
 	// Make sure we have a list of files to merge.
 	var files []string
 	if parser.Found("dir") {
-		err := filepath.Walk(
-			parser.StringValue("dir"),
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if strings.ToLower(filepath.Ext(info.Name())) == ".mp3" {
-					files = append(files, path)
-				}
-				return nil
-			})
+		var err error
+		files, err = findFiles(
+			fixLongPath(parser.StringValue("dir")),
+			outpath,
+			parser.Found("include-hidden"))
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
+			printError(err)
 			os.Exit(1)
 		}
 		if len(files) == 0 {
-			fmt.Fprintln(os.Stderr, "Error: no files found.")
+			printErrorf(errNoFiles, "no files found")
 			os.Exit(1)
 		}
 	} else if len(parser.Args) > 0 {
-		files = parser.Args
+		// The output file can slip into the input list via globbing, e.g. on a second run of
+		// 'mp3cat *.mp3 --force'.
+		for _, arg := range expandGlobs(parser.Args) {
+			arg = fixLongPath(arg)
+			if isSameFile(arg, outpath) {
+				printWarning(warnSkippedOutput, "skipping the output file '%v' in the list of input files", arg)
+				continue
+			}
+			files = append(files, arg)
+		}
+		if len(files) == 0 {
+			printErrorf(errNoFiles, "no input files other than the output file")
+			os.Exit(1)
+		}
 	} else {
-		fmt.Fprintln(os.Stderr, "Error: you must specify files to merge.")
+		printErrorf(errUsage, "you must specify files to merge")
+		os.Exit(1)
+	}
+
+	// Are we skipping files below a minimum size?
+	if parser.Found("min-size") {
+		minSize, err := parseSize(parser.StringValue("min-size"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		files = filterBySize(files, minSize)
+		if len(files) == 0 {
+			printErrorf(errNoFiles, "no files found")
+			os.Exit(1)
+		}
+	}
+
+	// Are we skipping files outside a range of durations?
+	if parser.Found("skip-shorter-than") || parser.Found("skip-longer-than") {
+		var minDuration, maxDuration time.Duration
+		for _, option := range []struct {
+			name  string
+			value *time.Duration
+		}{
+			{"skip-shorter-than", &minDuration}, {"skip-longer-than", &maxDuration},
+		} {
+			if !parser.Found(option.name) {
+				continue
+			}
+			*option.value, err = time.ParseDuration(parser.StringValue(option.name))
+			if err != nil || *option.value <= 0 {
+				printErrorf(errUsage, "invalid --%v duration '%v'", option.name, parser.StringValue(option.name))
+				os.Exit(1)
+			}
+		}
+		files = filterByDuration(ctx, files, minDuration, maxDuration)
+		if len(files) == 0 {
+			printErrorf(errNoFiles, "no files found")
+			os.Exit(1)
+		}
+	}
+
+	// Check for files listed twice, e.g. by overlapping globs and arguments.
+	files = checkDuplicates(files, parser.Found("dedupe"), parser.Found("warn-duplicates"))
+
+	// Are we limiting the amount of unrecognised data to skip in an input file?
+	var maxResync int64
+	if parser.Found("max-resync") {
+		maxResync, err = parseSize(parser.StringValue("max-resync"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	}
+
+	// Parse the I/O buffer sizes.
+	readBuffer, err := parseSize(parser.StringValue("read-buffer"))
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	writeBuffer, err := parseSize(parser.StringValue("write-buffer"))
+	if err != nil {
+		printError(err)
 		os.Exit(1)
 	}
 
@@ -94,42 +585,509 @@ func main() {
 	if parser.Found("meta") {
 		tagindex := parser.IntValue("meta") - 1
 		if tagindex < 0 || tagindex > len(files)-1 {
-			fmt.Fprintln(os.Stderr, "Error: --meta argument is out of range.")
+			printErrorf(errUsage, "--meta argument is out of range")
 			os.Exit(1)
 		}
 		tagpath = files[tagindex]
 	}
 
+	// Set the encoding of the tags we write before any are built.
+	if parser.Found("tag-encoding") {
+		encoding, err := parseTagEncoding(parser.StringValue("tag-encoding"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		tagEncoding = encoding
+	}
+
+	// Without --meta, --auto-tags infers the album and title.
+	var tag *mp3lib.ID3v2Tag
+	if parser.Found("auto-tags") && tagpath == "" {
+		tag = autoTag(files[0], outpath)
+	}
+
+	// With --strip-art or --max-tag-size, the copied tag is rebuilt without the dropped frames.
+	if tagpath != "" && (parser.Found("strip-art") || parser.Found("max-tag-size")) {
+		var maxTagSize int64
+		if parser.Found("max-tag-size") {
+			maxTagSize, err = parseSize(parser.StringValue("max-tag-size"))
+			if err != nil {
+				printError(err)
+				os.Exit(1)
+			}
+		}
+		tag, err = trimTag(tagpath, parser.Found("strip-art"), maxTagSize)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	}
+
+	// With --genre or --comment, the frames are set in the copied or inferred tag, or a new tag.
+	var setFrames []*mp3lib.ID3v2Frame
+	if parser.Found("genre") {
+		genre, err := parseGenre(parser.StringValue("genre"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		setFrames = append(setFrames, mp3lib.NewTextFrame("TCON", genre))
+	}
+	if parser.Found("comment") {
+		comment, err := commentFrame(parser)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		setFrames = append(setFrames, comment)
+	}
+	if len(setFrames) > 0 {
+		if tag == nil && tagpath != "" {
+			if tag, err = trimTag(tagpath, false, 0); err != nil {
+				printError(err)
+				os.Exit(1)
+			}
+		}
+		tag = withFrames(tag, setFrames...)
+	}
+
+	// With --tag-encoding, a copied tag is rebuilt so its text is transcoded.
+	if parser.Found("tag-encoding") && tag == nil && tagpath != "" {
+		if tag, err = trimTag(tagpath, false, 0); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	}
+
+	// Are we copying the APEv2 tag from the n-th input file?
+	var apepath string
+	if parser.Found("ape") {
+		apeindex := parser.IntValue("ape") - 1
+		if apeindex < 0 || apeindex > len(files)-1 {
+			printErrorf(errUsage, "--ape argument is out of range")
+			os.Exit(1)
+		}
+		apepath = files[apeindex]
+	}
+
 	// Are we interlacing a spacer file?
 	if parser.Found("interlace") {
-		files = interlace(files, parser.StringValue("interlace"))
+		files = interlace(files, fixLongPath(parser.StringValue("interlace")))
 	}
 
-	// Make sure all the files in the list actually exist.
-	validateFiles(files)
+	// Make sure all the files in the list actually exist. With --keep-going, any which don't are
+	// skipped.
+	var skippedFiles int
+	if parser.Found("keep-going") {
+		valid := skipInvalidFiles(files)
+		skippedFiles = len(files) - len(valid)
+		files = valid
+		if len(files) == 0 {
+			printErrorf(errNoFiles, "no files found")
+			os.Exit(1)
+		}
+	} else if err := validateFiles(files); err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+
+	// Make sure the input files satisfy any --require-* constraints.
+	reqs := &requirements{
+		sampleRate: parser.IntValue("require-samplerate"),
+		cbr:        parser.Found("require-cbr"),
+	}
+	if parser.Found("require-channels") {
+		channels, err := parseChannels(parser.StringValue("require-channels"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		reqs.channels = channels
+	}
+	if parser.Found("require-layer") {
+		layer, err := parseLayer(parser.StringValue("require-layer"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		reqs.layer = layer
+	}
+	if reqs.sampleRate != 0 || reqs.channels != "" || reqs.cbr || reqs.layer != 0 {
+		problems, err := checkRequirements(files, reqs)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				printErrorf(errRequirement, "%s", problem)
+			}
+			os.Exit(1)
+		}
+	}
+
+	// Check the checksum algorithm before we start.
+	if parser.Found("checksum") {
+		if _, err := newHasher(parser.StringValue("checksum")); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	}
+
+	// Check the --normalize-crc mode before we start.
+	switch parser.StringValue("normalize-crc") {
+	case "warn", "error", "strip":
+	default:
+		printErrorf(errUsage, "invalid --normalize-crc mode '%v', expected 'warn', 'error', or 'strip'",
+			parser.StringValue("normalize-crc"))
+		os.Exit(1)
+	}
+
+	// Check the --embed-tracklist format before we start.
+	tracklist := parser.StringValue("embed-tracklist")
+	switch tracklist {
+	case "uslt", "comment":
+	case "off":
+		tracklist = ""
+	default:
+		printErrorf(errUsage, "invalid --embed-tracklist format '%v', expected 'uslt', 'comment', or 'off'", tracklist)
+		os.Exit(1)
+	}
+
+	// Load the chapter links before we start so a bad file doesn't waste a merge.
+	var chapterURLs *chapterURLs
+	if parser.Found("chapter-urls") {
+		var err error
+		chapterURLs, err = loadChapterURLs(fixLongPath(parser.StringValue("chapter-urls")))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	}
+
+	// Are we verifying the input files against a checksum file? Make sure every file is listed
+	// before we start; the checksums themselves are checked as the files are read.
+	var checksums *checksumList
+	if parser.Found("verify-checksums") {
+		var err error
+		checksums, err = loadChecksums(fixLongPath(parser.StringValue("verify-checksums")))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		for _, file := range files {
+			if _, found := checksums.lookup(file); !found {
+				printErrorf(errChecksum, "no checksum for '%v' in '%v'", file, checksums.path)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Are we setting the output file's modification time?
+	var mtime time.Time
+	if parser.Found("touch") {
+		t, err := parseTimestamp(parser.StringValue("touch"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		mtime = t
+	} else if parser.Found("preserve-times") {
+		mtime = latestModTime(files)
+	}
 
 	// Set debug mode if the user supplied a --debug flag.
 	if parser.Found("debug") {
 		mp3lib.DebugMode = true
 	}
 
+	// Limit the size of the tags we'll read into memory.
+	maxTagRead, err := parseSize(parser.StringValue("max-tag-read"))
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	mp3lib.MaxTagSize = int(maxTagRead)
+
+	// Lock the output file so concurrent runs targeting the same path can't corrupt it.
+	lock, err := acquireLock(outpath, time.Duration(parser.IntValue("wait"))*time.Second)
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+
+	// Run the --pre-exec hook. A failure aborts the merge.
+	if parser.Found("pre-exec") {
+		if err := runHook(ctx, "--pre-exec", parser.StringValue("pre-exec"), hookVars(outpath, files)); err != nil {
+			lock.release()
+			printError(err)
+			os.Exit(1)
+		}
+	}
+
 	// Merge the input files.
-	merge(
-		parser.StringValue("out"),
-		tagpath,
-		files,
-		parser.Found("force"),
-		parser.Found("quiet"))
+	err = merge(ctx, files, &mergeOptions{
+		outpath:      outpath,
+		tagpath:      tagpath,
+		tag:          tag,
+		apepath:      apepath,
+		tmpdir:       fixLongPath(parser.StringValue("tmpdir")),
+		force:        parser.Found("force"),
+		strict:       parser.Found("strict"),
+		strictParse:  parser.Found("strict-parse"),
+		fixReservoir: parser.Found("fix-reservoir"),
+		alignFrames:  parser.Found("align-frames"),
+		normalizeCRC: parser.StringValue("normalize-crc"),
+		backup:       parser.Found("backup"),
+		manifestPath: fixLongPath(parser.StringValue("write-manifest")),
+		tocPath:      fixLongPath(parser.StringValue("write-toc")),
+		reportPath:   fixLongPath(parser.StringValue("report")),
+		checksums:    checksums,
+		verifyOutput: parser.Found("verify-output"),
+		checksum:     strings.ReplaceAll(strings.ToLower(parser.StringValue("checksum")), "-", ""),
+		mtime:        mtime,
+		onFile:       parser.StringValue("on-file"),
+		readBuffer:   int(readBuffer),
+		writeBuffer:  int(writeBuffer),
+		maxResync:    maxResync,
+		infoHeader:   parser.Found("info-header"),
+		keepHeaders:  parser.Found("keep-headers"),
+		chapters:     parser.Found("chapters") || parser.Found("chapter-art") || parser.Found("chapter-urls"),
+		chapterURLs:  chapterURLs,
+		tracklist:    tracklist,
+		gapless:      parser.Found("gapless"),
+		reproducible: parser.Found("reproducible"),
+		keepGoing:    parser.Found("keep-going"),
+		skippedFiles: skippedFiles,
+		chapterArt:   fixLongPath(parser.StringValue("chapter-art")),
+		progress: func(p mergeProgress) {
+			printDebug("progress: file %v of %v, %v frames, %v",
+				p.fileIndex+1, len(files), p.framesDone, formatBytes(p.bytesDone))
+		},
+	})
+
+	lock.release()
+
+	// Run the --post-exec hook, whether or not the merge succeeded.
+	if parser.Found("post-exec") {
+		vars := hookVars(outpath, files)
+		vars["MP3CAT_STATUS"] = "ok"
+		if err != nil {
+			vars["MP3CAT_STATUS"] = "error"
+			vars["MP3CAT_ERROR"] = err.Error()
+		}
+		if hookErr := runHook(ctx, "--post-exec", parser.StringValue("post-exec"), vars); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+}
+
+// Returns a list of the MPEG audio files (.mp3, .mp2, .mp1) in the directory tree rooted at [dir], excluding the output
+// file, in natural order. Hidden files and directories are skipped unless [includeHidden] is true.
+func findFiles(dir, outpath string, includeHidden bool) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !includeHidden && path != dir && isHidden(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !isAudioExt(filepath.Ext(info.Name())) {
+			return nil
+		}
+		if !isSameFile(path, outpath) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sortNatural(files)
+	return files, err
+}
+
+// Returns true if [ext] is the file extension of an MPEG audio file.
+func isAudioExt(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".mp3", ".mp2", ".mp1":
+		return true
+	}
+	return false
 }
 
-// Check that all the files in the list exist.
-func validateFiles(files []string) {
+// Check that all the files in the list exist and aren't obviously in some other format.
+func validateFiles(files []string) error {
 	for _, file := range files {
-		if _, err := os.Stat(file); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: the file '%v' does not exist.\n", file)
-			os.Exit(1)
+		if err := validateFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the files in the list which pass validateFiles. The others are reported and skipped.
+func skipInvalidFiles(files []string) []string {
+	var valid []string
+	for _, file := range files {
+		if err := validateFile(file); err != nil {
+			printWarning(warnSkippedInput, "%v; skipping it", err)
+			continue
 		}
+		valid = append(valid, file)
+	}
+	return valid
+}
+
+// Check that the file exists and isn't obviously in some other format.
+func validateFile(file string) error {
+	if _, err := os.Stat(file); err != nil {
+		return conditionErrorf(errFileNotFound, "the file '%v' does not exist", file)
+	}
+	if format := detectFormat(file); format != "" {
+		return conditionErrorf(errNotMP3, "'%v' is %v, not MP3", file, format)
 	}
+	return nil
+}
+
+// Returns the name of the file's format if it's a non-MP3 format commonly mistaken for MP3, e.g.
+// an AAC file renamed to '.mp3'. Returns an empty string otherwise. Read errors are left for the
+// merge to report.
+func detectFormat(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	format, err := mp3lib.DetectFormat(file)
+	if err != nil {
+		return ""
+	}
+	return format
+}
+
+// Returns true if the two paths refer to the same file. Compares file identity if both files
+// exist, otherwise compares absolute paths (case-insensitively on Windows).
+func isSameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA == nil && errB == nil {
+		return os.SameFile(infoA, infoB)
+	}
+
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(absA, absB)
+	}
+	return absA == absB
+}
+
+// Returns the files in the list which are at least [minSize] bytes in size. Files which can't
+// be checked are passed through unchanged.
+func filterBySize(files []string, minSize int64) []string {
+	var filtered []string
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.Size() < minSize {
+			printWarning(warnSkippedSmall, "skipping '%v' (%v bytes) as it's below the minimum size", file, info.Size())
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+	return filtered
+}
+
+// Returns the files in the list which play for at least [minDuration] and at most [maxDuration],
+// if greater than zero. Files which can't be checked are passed through unchanged.
+func filterByDuration(ctx context.Context, files []string, minDuration, maxDuration time.Duration) []string {
+	var filtered []string
+	for _, file := range files {
+		duration, err := fileDuration(ctx, file)
+		if err == nil && duration < minDuration {
+			printWarning(warnSkippedShort, "skipping '%v' (%v) as it's shorter than %v", file, duration.Round(time.Millisecond), minDuration)
+			continue
+		}
+		if err == nil && maxDuration > 0 && duration > maxDuration {
+			printWarning(warnSkippedLong, "skipping '%v' (%v) as it's longer than %v", file, duration.Round(time.Millisecond), maxDuration)
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+	return filtered
+}
+
+// Returns the playing time of the file at [path], from its Xing header if it has one.
+func fileDuration(ctx context.Context, path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return mp3lib.Duration(mp3lib.NewContextReader(ctx, file))
+}
+
+// Parse a size argument, e.g. '500', '64k', '2M'. Suffixes are binary multiples.
+func parseSize(arg string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.TrimSpace(arg)
+	if number != "" {
+		switch strings.ToLower(number[len(number)-1:]) {
+		case "k":
+			multiplier = 1 << 10
+		case "m":
+			multiplier = 1 << 20
+		case "g":
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			number = number[:len(number)-1]
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, conditionErrorf(errUsage, "invalid size '%v'", arg)
+	}
+	return n * multiplier, nil
+}
+
+// Returns the latest modification time of the files in the list.
+func latestModTime(files []string) time.Time {
+	var latest time.Time
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Parse a timestamp argument. Accepts RFC 3339 timestamps, 'YYYY-MM-DD HH:MM:SS' or 'YYYY-MM-DD'
+// local times, or Unix timestamps in seconds.
+func parseTimestamp(arg string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, arg); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, arg, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if secs, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Time{}, conditionErrorf(errUsage, "invalid timestamp '%v'", arg)
 }
 
 // Interlace a spacer file between each file in the list.
@@ -142,238 +1100,953 @@ func interlace(files []string, spacer string) []string {
 	return interlaced[:len(interlaced)-1]
 }
 
-// Create a new file at [outpath] containing the merged contents of the list of input files.
-func merge(outpath, tagpath string, inpaths []string, force, quiet bool) {
-	var totalFrames uint32
-	var totalBytes uint32
+// Options controlling a merge.
+type mergeOptions struct {
+	outpath      string              // Output filepath.
+	tagpath      string              // Copy the ID3v2 tag from this file if not empty.
+	tag          *mp3lib.ID3v2Tag    // Write this ID3v2 tag, in place of tagpath's, if not nil.
+	apepath      string              // Copy the APEv2 tag from this file if not empty.
+	tmpdir       string              // Directory for temporary files. Defaults to the output file's directory.
+	force        bool                // Overwrite an existing output file.
+	strict       bool                // Treat mismatched audio parameters as an error.
+	strictParse  bool                // Treat garbage data and damaged frames as an error.
+	repair       bool                // Drop garbage data and damaged frames.
+	fixReservoir bool                // Clear bit reservoir references at the start of each input file.
+	alignFrames  bool                // Insert a silent frame before input files which use the bit reservoir.
+	normalizeCRC string              // Handling of mixed CRC protection: 'warn', 'error', or 'strip'.
+	backup       bool                // Keep a backup copy of an overwritten output file.
+	checksum     string              // Name of the algorithm for the output checksum, if not empty.
+	manifestPath string              // Write a JSON manifest of the merge to this file if not empty.
+	tocPath      string              // Write a table of contents to this file if not empty.
+	reportPath   string              // Write a Markdown or HTML report to this file if not empty.
+	checksums    *checksumList       // Verify the input files against these checksums if not nil.
+	verifyOutput bool                // Re-read the output and check it after writing.
+	onFile       string              // Shell command to run after each input file is added.
+	readBuffer   int                 // Size of the input buffer. Defaults to defaultBufferSize.
+	writeBuffer  int                 // Size of the output buffer. Defaults to defaultBufferSize.
+	maxResync    int64               // Stop reading a file after this much unrecognised data if not zero.
+	progress     func(mergeProgress) // Called periodically with the merge's progress if not nil.
+	infoHeader   bool                // Add an Info header with the frame and byte counts to CBR output.
+	keepHeaders  bool                // Copy the inputs' VBR header frames and don't add a new one.
+	chapters     bool                // Add an ID3v2 chapter for each input.
+	chapterArt   string              // Directory of per-chapter images if not empty.
+	chapterURLs  *chapterURLs        // Links to add to the chapters if not nil.
+	tracklist    string              // Add a list of the inputs in a 'uslt' or 'comment' frame if not empty.
+	gapless      bool                // Drop whole frames of encoder delay and padding from the inputs.
+	reproducible bool                // Leave creation times out of the manifest and report.
+	keepGoing    bool                // Skip input files which can't be read instead of failing.
+	skippedFiles int                 // Input files already skipped, for the merge's summary.
+	mtime        time.Time           // Set the output file's modification time if not zero.
+}
+
+// Progress of a merge, passed to the mergeOptions.progress callback after every
+// progressInterval bytes of frames and at the end of each input file.
+type mergeProgress struct {
+	fileIndex  int    // Index of the input file being read.
+	bytesDone  uint64 // Bytes of audio frames written so far.
+	framesDone uint64 // Number of frames written so far.
+}
+
+// Position in the output at which an input file's frames start, and a summary of the frames.
+type inputStart struct {
+	path      string        // Path of the input file.
+	offset    uint64        // Bytes of audio frames preceding the file's frames.
+	timestamp time.Duration // Duration of the audio preceding the file's frames.
+	stats     mp3lib.Stats  // Bytes and duration of the file's frames.
+	frames    int           // Number of frames copied from the file.
+	garbage   bool          // Whether unrecognised data was skipped or removed.
+}
+
+// Interval in bytes of audio frames between progress reports.
+const progressInterval = 1 << 20
+
+// Create a new file at [opts.outpath] containing the merged contents of the list of input files.
+// Stops and cleans up if the context is cancelled.
+func merge(ctx context.Context, inpaths []string, opts *mergeOptions) error {
+	outpath := opts.outpath
+	tagpath := opts.tagpath
+
+	var stats mp3lib.Stats
+	var toc mp3lib.XingTOC // Positions of the frames for the VBR header.
+	var hasVBRHeader bool
+	var hasInfoHeader bool
 	var totalFiles int
-	var firstBitRate int
-	var isVBR bool
+	skippedFiles := opts.skippedFiles // Files with no frames, or which can't be read with --keep-going.
+	var firstFrame *mp3lib.MP3Frame
+	var lastProgress uint64
+	var starts []inputStart
+
+	// Report progress to the callback, if there is one.
+	reportProgress := func(index int) {
+		if opts.progress != nil {
+			opts.progress(mergeProgress{fileIndex: index, bytesDone: stats.Bytes, framesDone: stats.Frames})
+			lastProgress = stats.Bytes
+		}
+	}
 
 	// Only overwrite an existing file if the --force flag has been used.
 	if _, err := os.Stat(outpath); err == nil {
-		if !force {
-			fmt.Fprintf(os.Stderr, "Error: the file '%v' already exists.\n", outpath)
-			os.Exit(1)
+		if !opts.force {
+			return conditionErrorf(errOutputExists, "the file '%v' already exists", outpath)
 		}
 	}
 
+	// With '--normalize-crc strip', CRCs are only removed if the inputs mix CRC-protected and
+	// unprotected frames.
+	stripCRC := opts.normalizeCRC == "strip" && hasMixedCRC(inpaths)
+	if stripCRC {
+		printDebug("removing CRCs as the input files mix CRC-protected and unprotected frames")
+	}
+
 	// If the list of input files includes the output file we'll end up in an infinite loop.
-	for _, filepath := range inpaths {
-		if filepath == outpath {
-			fmt.Fprintln(os.Stderr, "Error: the list of input files includes the output file.")
-			os.Exit(1)
+	for _, inpath := range inpaths {
+		if isSameFile(inpath, outpath) || isSameFile(inpath, outpath+".partial") {
+			return conditionErrorf(errUsage, "the list of input files includes the output file")
+		}
+	}
+
+	// Make sure there's room for the output before we start writing.
+	if err := checkFreeSpace(inpaths, opts); err != nil {
+		return err
+	}
+
+	// Write the output to a '.partial' file and only move it into place once the merge has
+	// succeeded, so a failed run never leaves a truncated file at the output path.
+	partpath := outpath + ".partial"
+
+	// If a checksum or manifest was requested, hash the output as we write it. The manifest
+	// uses SHA-256 unless another algorithm was chosen with --checksum.
+	algorithm := opts.checksum
+	if algorithm == "" && opts.manifestPath != "" {
+		algorithm = "sha256"
+	}
+
+	var hasher hash.Hash
+	if algorithm != "" {
+		var err error
+		hasher, err = newHasher(algorithm)
+		if err != nil {
+			return err
 		}
 	}
 
-	// Create the output file.
-	outfile, err := os.Create(outpath)
+	// Space for a VBR header is reserved before the first frame, so it can be written without
+	// rewriting the file, if the output needs one: with --info-header, or if the start of the
+	// inputs shows more than one bitrate.
+	reserveVBR := !opts.keepHeaders && (opts.infoHeader || predictVBR(inpaths))
+
+	output, err := newOutputWriter(partpath, opts.tmpdir, opts.writeBuffer, hasher)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+		return err
+	}
+
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			os.Remove(partpath)
+		}
+	}()
+	defer output.Close()
+
+	// Copy the ID3v2 tag from the n-th input file if requested. The ID3 tag must be the first
+	// item in the file - in particular, it must come *before* any VBR header. With --chapters or
+	// a tracklist, the tag can only be built once the merge is finished, so space is reserved for
+	// it instead. The space is sized for a tag listing every input, as some may turn out to have
+	// no frames, with times as long as they can be written. If the output is being hashed, the
+	// tag is inserted at the end instead.
+	lateTag := opts.chapters || opts.tracklist != ""
+	if lateTag {
+		var latest []inputStart
+		for _, inpath := range inpaths {
+			latest = append(latest, inputStart{path: inpath, timestamp: math.MaxInt64})
+		}
+		frames, err := lateTagFrames(opts, tagpath, latest, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		if err := output.ReserveTag(len(newTag(frames).RawBytes)); err != nil {
+			return err
+		}
+	} else if opts.tag != nil {
+		if err := output.WriteTag(opts.tag); err != nil {
+			return err
+		}
+	} else if tagpath != "" {
+		if err := output.WriteID3v2Tag(tagpath); err != nil {
+			return err
+		}
 	}
 
-	if !quiet {
-		printLine()
+	var record *manifest
+	if opts.manifestPath != "" {
+		record = &manifest{Version: version, Algorithm: algorithm}
+		if !opts.reproducible {
+			created := time.Now().UTC()
+			record.Created = &created
+		}
 	}
 
+	printLine()
+
 	// Loop over the input files and append their MP3 frames to the output file.
-	for _, inpath := range inpaths {
-		if !quiet {
-			fmt.Println("+", inpath)
-		}
+	for index, inpath := range inpaths {
+		printFile(inpath)
+		start := inputStart{path: inpath, offset: stats.Bytes, timestamp: stats.Duration}
 
 		infile, err := os.Open(inpath)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+		if err != nil && opts.keepGoing {
+			printWarning(warnSkippedInput, "%v; skipping it", err)
+			skippedFiles++
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		var framesRead int
+		var readFailed bool // With --keep-going, the file couldn't be read to the end.
+		var hasGarbage bool
+		isMismatchReported := false
+		var fileFrames int
+		var audioFrames int // Audio frames read, including any dropped with --gapless.
+		var droppedFrames int
+		var hasHeader, isVBR bool // Whether the file has a VBR header, and more than one bitrate.
+		var firstFrameBitRate int
+		gapless := gaplessTrim{end: -1}
+
+		// For the manifest, hash each input file as it's read.
+		var source io.Reader = infile
+		var entry *manifestInput
+		if record != nil {
+			entry = &manifestInput{manifestFile: manifestFile{Path: inpath}}
+			entry.hasher, _ = newHasher(algorithm)
+			if info, err := infile.Stat(); err == nil {
+				entry.Size = info.Size()
+			}
+			source = io.TeeReader(infile, entry.hasher)
+			record.Inputs = append(record.Inputs, entry)
+		}
+
+		// With --verify-checksums, hash each input file as it's read and check it at the end.
+		var verifier hash.Hash
+		if opts.checksums != nil {
+			verifier, _ = newHasher(opts.checksums.algorithm)
+			source = io.TeeReader(source, verifier)
 		}
 
-		isFirstFrame := true
+		readBuffer := opts.readBuffer
+		if readBuffer <= 0 {
+			readBuffer = defaultBufferSize
+		}
+
+		// In strict parsing mode, garbage data or a damaged frame aborts the merge. In repair
+		// mode, damaged frames are dropped and reported.
+		// Frames are read into a single reusable frame to avoid allocating per frame. The
+		// validator allocates its frames from a pool instead, so they're released once written.
+		reader := mp3lib.NewFrameReaderSize(mp3lib.NewContextReader(ctx, source), readBuffer)
+		reader.SetMaxResync(opts.maxResync)
+		reusableFrame := &mp3lib.MP3Frame{}
+		isPooled := opts.strictParse || opts.repair
+		nextFrame := func() (*mp3lib.MP3Frame, error) {
+			err := reader.ReadInto(reusableFrame)
+			if isEndOfStream(err) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			return reusableFrame, nil
+		}
+		if opts.strictParse {
+			validator := mp3lib.NewValidator(bufio.NewReaderSize(mp3lib.NewContextReader(ctx, source), readBuffer))
+			validator.SetMaxResync(opts.maxResync)
+			nextFrame = func() (*mp3lib.MP3Frame, error) {
+				frame, issue, err := validator.Next()
+				if err != nil {
+					return nil, err
+				}
+				if issue != nil && issue.Severity != mp3lib.SeverityInfo {
+					cond := issueCondition(*issue)
+					if cond == warnGarbageData {
+						cond = errGarbageData
+					}
+					return nil, conditionErrorf(cond,
+						"'%v' contains %v at offset %v", inpath, issue.Description, issue.Offset)
+				}
+				return frame, nil
+			}
+		} else if opts.repair {
+			validator := mp3lib.NewValidator(bufio.NewReaderSize(mp3lib.NewContextReader(ctx, source), readBuffer))
+			validator.SetMaxResync(opts.maxResync)
+			nextFrame = func() (*mp3lib.MP3Frame, error) {
+				for {
+					frame, issue, err := validator.Next()
+					if err != nil || issue == nil || issue.Severity == mp3lib.SeverityInfo {
+						return frame, err
+					}
+					printInfo("Removed %v at offset %v.", issue.Description, issue.Offset)
+					hasGarbage = true
+					mp3lib.ReleaseFrame(frame)
+				}
+			}
+		}
+
+		// Unmodified frames from a clean run of the input are copied directly between the files
+		// rather than through our buffers. This isn't possible in strict or repair mode where the
+		// frames come from a validator.
+		span := &frameSpan{}
+		if !opts.strictParse && !opts.repair {
+			span.src, _ = os.Open(inpath)
+		}
+		closeInput := func() {
+			infile.Close()
+			span.close(output)
+		}
+
+		// Removing a frame's CRC can move its audio data into later frames, so the frames of a
+		// CRC-protected input pass through a stripper and are written once they're complete.
+		var stripper *mp3lib.CRCStripper
+		writeStripped := func(frames []*mp3lib.MP3Frame, err error) error {
+			if errors.Is(err, mp3lib.ErrReservoirOverflow) {
+				return conditionErrorf(errCRCStrip, "cannot remove the CRCs from '%v': %w", inpath, err)
+			} else if err != nil {
+				return err
+			}
+			if err := span.flush(output); err != nil {
+				return err
+			}
+			for _, frame := range frames {
+				if _, err := output.Write(frame.RawBytes); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
 
 		for {
-			// Read the next frame from the input file.
-			frame := mp3lib.NextFrame(infile)
+			// Read the next frame from the input file. With --max-resync, we give up on a file
+			// containing too much unrecognised data, e.g. a large file which isn't MP3 at all.
+			frame, err := nextFrame()
+			if errors.Is(err, mp3lib.ErrResyncLimit) {
+				if opts.strictParse {
+					closeInput()
+					return conditionErrorf(errGarbageData, "'%v' contains more than %v of unrecognised data",
+						inpath, formatBytes(uint64(opts.maxResync)))
+				}
+				printWarning(warnResyncLimit, "giving up on '%v' after %v of unrecognised data",
+					inpath, formatBytes(uint64(opts.maxResync)))
+				break
+			}
+			// With --keep-going, a file which can't be read to the end keeps the frames read so
+			// far. Errors from strict parsing and interruptions still abort the merge.
+			if err != nil && opts.keepGoing && ctx.Err() == nil && errorCondition(err) == errOther {
+				if fileFrames == 0 {
+					printWarning(warnSkippedInput, "%v; skipping it", err)
+				} else {
+					printWarning(warnReadFailed, "giving up on '%v' after %v frames: %v", inpath, fileFrames, err)
+				}
+				readFailed = true
+				break
+			}
+			if err != nil {
+				closeInput()
+				return err
+			}
 			if frame == nil {
 				break
 			}
 
-			// Skip the first frame if it's a VBR header.
-			if isFirstFrame {
-				isFirstFrame = false
-				if mp3lib.IsXingHeader(frame) || mp3lib.IsVbriHeader(frame) {
-					continue
+			// Skip any VBR header. It's normally the first frame, but some encoders write a junk
+			// frame before it. With --keep-headers, every frame is copied.
+			framesRead++
+			isHeader := framesRead <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame)
+			hasHeader = hasHeader || isHeader
+			if !opts.keepHeaders && isHeader {
+				printDebug("skipping the VBR header in '%v'", inpath)
+				if opts.gapless && audioFrames == 0 {
+					gapless = newGaplessTrim(frame)
+				}
+				if isPooled {
+					mp3lib.ReleaseFrame(frame)
 				}
+				continue
 			}
 
-			// If we detect more than one bitrate we'll need to add a VBR header to the output file.
-			if firstBitRate == 0 {
-				firstBitRate = frame.BitRate
-			} else if frame.BitRate != firstBitRate {
-				isVBR = true
+			// With --gapless, whole frames of the encoder's delay and padding are dropped. The
+			// first frame kept is treated as the start of the file below.
+			audioFrames++
+			if gapless.drops(audioFrames - 1) {
+				droppedFrames++
+				if isPooled {
+					mp3lib.ReleaseFrame(frame)
+				}
+				continue
 			}
 
-			// Write the frame to the output file.
-			_, err := outfile.Write(frame.RawBytes)
+			// Layer III frames can borrow space for their audio data from preceding frames. At
+			// the start of an input file, those preceding frames belong to the previous input,
+			// so the first few frames will glitch on playback.
+			isModified := false
+			var silentFrame *mp3lib.MP3Frame
+			if fileFrames == 0 && totalFiles > 0 && mp3lib.MainDataBegin(frame) != 0 {
+				if opts.fixReservoir {
+					mp3lib.ClearMainDataBegin(frame)
+					isModified = true
+				} else if opts.alignFrames {
+					silentFrame = mp3lib.NewSilentFrame(frame, mp3lib.MainDataBegin(frame))
+					if silentFrame == nil {
+						printWarning(warnAlignFailed, "cannot create a silent frame large enough to align '%v'", inpath)
+					}
+				} else {
+					printWarning(warnReservoirJoin,
+						"'%v' begins with a frame which depends on audio data from the previous file; "+
+							"there may be a glitch at the join (see --fix-reservoir and --align-frames)", inpath)
+				}
+			}
+
+			// Mixing sample rates, channel layouts or MPEG versions produces files that many
+			// decoders play incorrectly, e.g. at the wrong speed.
+			if firstFrame == nil {
+				// Copy the frame's header fields as the frame itself may be reused.
+				firstFrame = &mp3lib.MP3Frame{}
+				*firstFrame = *frame
+				firstFrame.RawBytes = nil
+
+				// The VBR header has a CRC if the first frame has one, unless the CRCs are
+				// being removed from every frame.
+				if stripCRC {
+					firstFrame.CrcProtection = false
+				}
+
+				// The header's contents are only known at the end, so its space is reserved
+				// before the first frame.
+				if reserveVBR && firstFrame.MPEGLayer == mp3lib.MPEGLayerIII {
+					if err := output.ReserveVBRHeader(firstFrame); err != nil {
+						closeInput()
+						return err
+					}
+				}
+			} else if !isMismatchReported {
+				if mismatch, warning := describeMismatch(firstFrame, frame); mismatch != "" {
+					if opts.strict {
+						closeInput()
+						return conditionErrorf(errMixedFormat, "'%v' has %v", inpath, mismatch)
+					}
+					printWarning(warning, "'%v' has %v", inpath, mismatch)
+					isMismatchReported = true
+				}
+			}
+
+			// The emphasis, copyright, original, and private bits don't affect playback, but
+			// archives may rely on them, so a change between inputs is worth reporting.
+			if fileFrames == 0 {
+				if mismatch := describeFlagMismatch(firstFrame, frame); mismatch != "" {
+					printWarning(warnMixedHeaderBits, "'%v' has %v", inpath, mismatch)
+				}
+			}
+
+			// Some decoders dislike a stream which mixes CRC-protected and unprotected frames.
+			if fileFrames == 0 && stripCRC && frame.CrcProtection {
+				stripper = mp3lib.NewCRCStripper()
+			} else if fileFrames == 0 && !stripCRC && frame.CrcProtection != firstFrame.CrcProtection {
+				mismatch := "isn't CRC-protected but the output is"
+				if frame.CrcProtection {
+					mismatch = "is CRC-protected but the output isn't"
+				}
+				if opts.normalizeCRC == "error" {
+					closeInput()
+					return conditionErrorf(errMixedCRC, "'%v' %v", inpath, mismatch)
+				}
+				printWarning(warnMixedCRC, "'%v' %v (see --normalize-crc)", inpath, mismatch)
+			}
+
+			// With --align-frames, a silent frame goes before the first frame so the audio data
+			// it borrows is silence rather than the end of the previous file.
+			if silentFrame != nil {
+				if stripper != nil {
+					err = writeStripped(stripper.Add(silentFrame))
+				} else if err = span.flush(output); err == nil {
+					_, err = output.Write(silentFrame.RawBytes)
+				}
+				if err != nil {
+					closeInput()
+					return err
+				}
+				stats.Add(silentFrame)
+				toc.Add(silentFrame)
+				printDebug("inserted a silent frame before '%v'", inpath)
+			}
+
+			// Write the frame to the output file. Removing the CRC doesn't change the frame's
+			// length, so the input frame's stats are the same as the output frame's.
+			if stripper != nil {
+				err = writeStripped(stripper.Add(frame))
+			} else if span.src != nil && !isModified {
+				err = span.add(output, reader.Offset(), len(frame.RawBytes))
+			} else if err = span.flush(output); err == nil {
+				_, err = output.Write(frame.RawBytes)
+			}
 			if err != nil {
-				fmt.Fprintln(os.Stderr, "Error:", err)
-				os.Exit(1)
+				closeInput()
+				return err
 			}
 
-			totalFrames += 1
-			totalBytes += uint32(len(frame.RawBytes))
+			if fileFrames > 0 && frame.BitRate != firstFrameBitRate {
+				isVBR = true
+			} else if fileFrames == 0 {
+				firstFrameBitRate = frame.BitRate
+			}
+			fileFrames += 1
+			stats.Add(frame)
+			toc.Add(frame)
+			if isPooled {
+				mp3lib.ReleaseFrame(frame)
+			}
+			if stats.Bytes-lastProgress >= progressInterval {
+				reportProgress(index)
+			}
+		}
+
+		if stripper != nil {
+			err = writeStripped(stripper.Flush(), nil)
+		} else {
+			err = span.flush(output)
+		}
+		closeInput()
+		if err != nil {
+			return err
+		}
+		reportProgress(index)
+		if droppedFrames > 0 {
+			printDebug("dropped %v frames of encoder delay and padding from '%v'", droppedFrames, inpath)
+		}
+
+		// The output gets its own VBR header, but an input without one will have shown the wrong
+		// duration in players.
+		if isVBR && !hasHeader && firstFrame.MPEGLayer == mp3lib.MPEGLayerIII {
+			printWarning(warnMissingVBRHeader, "'%v' has a variable bitrate but no VBR header, so players may show the wrong "+
+				"duration for it (see 'mp3cat verify --fix')", inpath)
+		}
+
+		start.stats = mp3lib.Stats{Bytes: stats.Bytes - start.offset, Duration: stats.Duration - start.timestamp}
+		start.frames = fileFrames
+		start.garbage = hasGarbage || reader.Skipped() > 0
+
+		if entry != nil {
+			entry.Frames = uint64(fileFrames)
+			entry.Offset = int64(start.offset)
+			entry.Start = start.timestamp.Seconds()
+			entry.Duration = start.stats.Duration.Seconds()
+			entry.Bytes = start.stats.Bytes
+			entry.Bitrate = start.stats.AverageBitRate() / 1000
+			entry.Garbage = start.garbage
+			entry.finish()
+		}
+
+		if verifier != nil {
+			expected, _ := opts.checksums.lookup(inpath)
+			if hex.EncodeToString(verifier.Sum(nil)) != expected {
+				return conditionErrorf(errChecksum, "'%v' does not match its checksum in '%v'", inpath, opts.checksums.path)
+			}
+		}
+
+		if opts.onFile != "" {
+			vars := hookVars(outpath, inpaths)
+			vars["MP3CAT_FILE"] = inpath
+			vars["MP3CAT_FILE_INDEX"] = strconv.Itoa(index + 1)
+			vars["MP3CAT_FILE_FRAMES"] = strconv.Itoa(fileFrames)
+			if err := runHook(ctx, "--on-file", opts.onFile, vars); err != nil {
+				return err
+			}
+		}
+
+		// A file with no frames is probably not an MP3 file at all, e.g. a renamed image.
+		if fileFrames == 0 {
+			if !readFailed {
+				printWarning(warnNoFrames, "no MP3 frames found in '%v'", inpath)
+			}
+			skippedFiles++
+			continue
 		}
 
-		infile.Close()
 		totalFiles += 1
+		starts = append(starts, start)
 	}
 
-	outfile.Close()
-	if !quiet {
-		printLine()
+	// APEv2 tags belong at the end of the file, after the last frame.
+	if opts.apepath != "" {
+		if err := appendAPEv2Tag(output, opts.apepath); err != nil {
+			return err
+		}
 	}
 
-	// If we detected multiple bitrates, prepend a VBR header to the file.
-	if isVBR {
-		if !quiet {
-			fmt.Println("• Multiple bitrates detected. Adding VBR header.")
-		}
-		addXingHeader(outpath, totalFrames, totalBytes)
+	if err := output.Close(); err != nil {
+		return err
 	}
 
-	// Copy the ID3v2 tag from the n-th input file if requested. Order of operations is important
-	// here. The ID3 tag must be the first item in the file - in particular, it must come *before*
-	// any VBR header.
+	printLine()
 	if tagpath != "" {
-		if !quiet {
-			fmt.Printf("• Copying ID3 tag from: %s\n", tagpath)
-		}
-		addID3v2Tag(outpath, tagpath)
+		printInfo("Copying ID3 tag from: %s", tagpath)
+	}
+	if opts.apepath != "" {
+		printInfo("Copying APEv2 tag from: %s", opts.apepath)
 	}
 
-	// Print a count of the number of files merged.
-	if !quiet {
-		fmt.Printf("• %v files merged.\n", totalFiles)
-		printLine()
+	// If we detected multiple bitrates, add a VBR header to the file. With --info-header, or if
+	// space for a header was reserved for output predicted to have multiple bitrates, constant
+	// bitrate output gets an Info header recording the frame and byte counts, as LAME does. Xing
+	// headers are only defined for layer III, so layer I and II output is left as is. With
+	// --keep-headers, the output contains exactly the input frames, so no header is added.
+	if opts.keepHeaders {
+		if stats.IsVBR() {
+			printInfo("Multiple bitrates detected. Not adding a VBR header as --keep-headers is set.")
+		}
+	} else if stats.IsVBR() && firstFrame.MPEGLayer != mp3lib.MPEGLayerIII {
+		printWarning(warnVBRUnsupported, "multiple bitrates detected in layer %v audio; no VBR header can be added",
+			layerName(firstFrame.MPEGLayer))
+	} else if firstFrame != nil && firstFrame.MPEGLayer == mp3lib.MPEGLayerIII && (stats.IsVBR() || output.VBRHeaderReserved()) {
+		if stats.IsVBR() {
+			printInfo("Multiple bitrates detected. Adding VBR header.")
+		} else {
+			printInfo("Adding Info header.")
+		}
+		if stats.Frames > math.MaxUint32 {
+			printWarning(warnVBRFrameCount, "too many frames to record in the VBR header; players may not report the correct duration")
+		} else if stats.Bytes > math.MaxUint32 {
+			printWarning(warnVBRByteCount, "output exceeds 4 GiB; omitting the byte count from the VBR header")
+		}
+		if err := output.FillVBRHeader(firstFrame, stats.Frames, stats.Bytes, &toc, !stats.IsVBR()); err != nil {
+			return err
+		}
+		hasVBRHeader = true
+		hasInfoHeader = !stats.IsVBR()
 	}
-}
 
-// Prepend an Xing VBR header to the specified MP3 file.
-func addXingHeader(filepath string, totalFrames, totalBytes uint32) {
+	// Chapter and tracklist times are only known once every input has been merged, so the ID3 tag
+	// is written last, into the space reserved for it if any. Any text which can't be written in the
+	// tag's encoding was reported when the space was reserved.
+	if lateTag {
+		frames, err := lateTagFrames(opts, tagpath, starts, stats.Duration)
+		if err != nil {
+			return err
+		}
+		tag, _ := mp3lib.NewID3v2TagEncoded(frames, tagEncoding)
+		if err := output.FillTag(tag); err != nil {
+			return err
+		}
+		if opts.chapters && len(starts) > 255 {
+			printWarning(warnChapterLimit, "the chapter table of contents can only list 255 of the %v chapters", len(starts))
+		}
+		if opts.chapters {
+			printInfo("Added %v chapters.", len(starts))
+		}
+	}
 
-	outputFile, err := os.Create(filepath + ".mp3cat.tmp")
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	// Insert the tag and header if they had no space reserved for them. This rewrites the file,
+	// hashing it again if a checksum was requested.
+	if err := output.Finish(); err != nil {
+		return err
 	}
 
-	inputFile, err := os.Open(filepath)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	// Set the output file's modification time if requested.
+	if !opts.mtime.IsZero() {
+		if err := os.Chtimes(partpath, opts.mtime, opts.mtime); err != nil {
+			return err
+		}
 	}
 
-	xingHeader := mp3lib.NewXingHeader(totalFrames, totalBytes)
+	// Re-read the output to make sure it contains what we wrote before replacing anything.
+	if opts.verifyOutput {
+		if err := verifyOutput(ctx, partpath, &stats, hasVBRHeader, opts.keepHeaders); err != nil {
+			return err
+		}
+		printInfo("Output verified.")
+	}
 
-	_, err = outputFile.Write(xingHeader.RawBytes)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	// Keep the previous output file if requested.
+	if opts.backup {
+		if _, err := os.Stat(outpath); err == nil {
+			backpath := nextBackupPath(outpath)
+			if err := os.Rename(outpath, backpath); err != nil {
+				return err
+			}
+			printInfo("Previous output saved as: %s", backpath)
+		}
 	}
 
-	_, err = io.Copy(outputFile, inputFile)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	// Move the completed file into place.
+	if err := os.Rename(partpath, outpath); err != nil {
+		return err
 	}
+	succeeded = true
 
-	outputFile.Close()
-	inputFile.Close()
+	// Write the manifest now the output is in place. The inputs' offsets so far are relative to
+	// the first frame, so they're shifted past the ID3 tag and VBR header.
+	if record != nil {
+		for _, entry := range record.Inputs {
+			entry.Offset += output.FramesOffset()
+		}
+		record.Output = manifestFile{Path: outpath, Frames: stats.Frames, hasher: hasher}
+		if info, err := os.Stat(outpath); err == nil {
+			record.Output.Size = info.Size()
+		}
+		record.Output.finish()
+		if err := writeManifest(opts.manifestPath, record); err != nil {
+			return err
+		}
+		printInfo("Manifest written to: %s", opts.manifestPath)
+	}
 
-	err = os.Remove(filepath)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	// Write the table of contents.
+	if opts.tocPath != "" {
+		if err := writeTOC(opts.tocPath, starts); err != nil {
+			return err
+		}
+		printInfo("Table of contents written to: %s", opts.tocPath)
 	}
 
-	err = os.Rename(filepath+".mp3cat.tmp", filepath)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	// Report where each input starts in the output. Offsets so far are relative to the first
+	// frame, so they're shifted past the ID3 tag and VBR header.
+	for _, start := range starts {
+		printInputStart(start.path, output.FramesOffset()+int64(start.offset), start.timestamp)
 	}
-}
 
-// Prepend an ID3v2 tag to the MP3 file at mp3Path, copying from tagPath.
-func addID3v2Tag(mp3Path, tagPath string) {
+	// Print a count of the number of files merged and a summary of the output.
+	if skippedFiles > 0 {
+		printInfo("%v files merged, %v skipped.", totalFiles, skippedFiles)
+	} else {
+		printInfo("%v files merged.", totalFiles)
+	}
+	if info, err := os.Stat(outpath); err == nil {
+		var note string
+		if hasInfoHeader {
+			note = ", with an Info header"
+		} else if hasVBRHeader {
+			note = ", with a VBR header"
+		}
+		printInfo("Output: %v, %v, %v kbps average%v.",
+			formatDuration(stats.Duration), formatBytes(uint64(info.Size())),
+			stats.AverageBitRate()/1000, note)
+	}
+	if opts.checksum != "" {
+		digest := hex.EncodeToString(hasher.Sum(nil))
+		name := strings.ToUpper(opts.checksum)
+		logger.Info(fmt.Sprintf("%v: %v", name, digest), opts.checksum, digest)
+	}
 
-	tagFile, err := os.Open(tagPath)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	// Write the summary report last so it includes any warnings logged during the merge.
+	if opts.reportPath != "" {
+		header := "None"
+		if hasInfoHeader {
+			header = "Info"
+		} else if hasVBRHeader {
+			header = "Xing"
+		}
+		var size int64
+		if info, err := os.Stat(outpath); err == nil {
+			size = info.Size()
+		}
+		var checksum string
+		if opts.checksum != "" {
+			checksum = strings.ToUpper(opts.checksum) + ": " + hex.EncodeToString(hasher.Sum(nil))
+		}
+		summary := newReport(outpath, starts, &stats, size, header, checksum)
+		if opts.reproducible {
+			summary.Created = ""
+		}
+		if err := writeReport(opts.reportPath, summary); err != nil {
+			return err
+		}
+		printInfo("Report written to: %s", opts.reportPath)
 	}
+	printLine()
 
-	id3tag := mp3lib.NextID3v2Tag(tagFile)
-	tagFile.Close()
+	return nil
+}
 
-	if id3tag != nil {
-		outputFile, err := os.Create(mp3Path + ".mp3cat.tmp")
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
+// Returns the frames of the ID3v2 tag written at the end of a merge with --chapters or a
+// tracklist: the copyable frames of the tag being copied, if any, then the tracklist and the
+// chapters for the inputs starting at [starts] in output [total] long.
+func lateTagFrames(opts *mergeOptions, tagpath string, starts []inputStart, total time.Duration) ([]*mp3lib.ID3v2Frame, error) {
+	tag := opts.tag
+	if tag == nil && tagpath != "" {
+		tag = readTag(tagpath)
+	}
 
-		inputFile, err := os.Open(mp3Path)
+	var frames []*mp3lib.ID3v2Frame
+	if tag != nil {
+		frames = copyableFrames(tag)
+	}
+	if opts.tracklist != "" {
+		frames = setFrame(frames, tracklistFrame(opts.tracklist, starts))
+	}
+	if opts.chapters {
+		chapters, err := chapterFrames(starts, total, opts.chapterArt, opts.chapterURLs)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+			return nil, err
 		}
+		frames = append(frames, chapters...)
+	}
+	return frames, nil
+}
 
-		_, err = outputFile.Write(id3tag.RawBytes)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
+// Number of audio frames read from the start of each input file by predictVBR.
+const predictFrames = 32
 
-		_, err = io.Copy(outputFile, inputFile)
+// Returns true if the merged output of [inpaths] is likely to have more than one bitrate. Only
+// the start of each file is read: a file with an Xing or VBRI header, or with a bitrate different
+// from the first file's, means variable bitrate output. A wrong guess costs a rewrite to insert
+// the VBR header, or an Info header in the space reserved for one.
+func predictVBR(inpaths []string) bool {
+	var bitRate int
+	for _, inpath := range inpaths {
+		file, err := os.Open(inpath)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+			continue
+		}
+		reader := mp3lib.NewFrameReader(bufio.NewReader(file))
+		for read := 1; read <= predictFrames; read++ {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				break
+			}
+			if read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+				if info, err := mp3lib.ParseXingHeader(frame); err != nil || info.ID == "Xing" {
+					file.Close()
+					return true
+				}
+				continue
+			}
+			if bitRate == 0 {
+				bitRate = frame.BitRate
+			} else if frame.BitRate != bitRate {
+				file.Close()
+				return true
+			}
 		}
+		file.Close()
+	}
+	return false
+}
 
-		outputFile.Close()
-		inputFile.Close()
+// Formats a timestamp as 'hh:mm:ss.mmm', truncated to the millisecond.
+func formatTimestamp(d time.Duration) string {
+	ms := int64(d / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms%3600000/60000, ms%60000/1000, ms%1000)
+}
 
-		err = os.Remove(mp3Path)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
+// Formats a duration as 'm:ss' or 'h:mm:ss', rounded to the nearest second.
+func formatDuration(d time.Duration) string {
+	secs := int64(d.Round(time.Second) / time.Second)
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs%3600/60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
 
-		err = os.Rename(mp3Path+".mp3cat.tmp", mp3Path)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+// Returns the first unused backup path for [path], i.e. '<path>.bak', then '<path>.bak.1',
+// '<path>.bak.2', etc.
+func nextBackupPath(path string) string {
+	backpath := path + ".bak"
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backpath); os.IsNotExist(err) {
+			return backpath
 		}
+		backpath = fmt.Sprintf("%s.bak.%d", path, i)
 	}
 }
 
-// Print a line to stdout if we're running in a terminal.
-func printLine() {
-	if term.IsTerminal(int(os.Stdout.Fd())) {
-		width, _, err := term.GetSize(int(os.Stdout.Fd()))
-		if err == nil {
-			if runtime.GOOS == "windows" {
-				for i := 0; i < width; i++ {
-					fmt.Print("-")
-				}
-				fmt.Println()
-			} else {
-				fmt.Print("\u001B[90m")
-				for i := 0; i < width; i++ {
-					fmt.Print("─")
-				}
-				fmt.Println("\u001B[0m")
-			}
-		}
+// Append the APEv2 tag from the file at [inpath] to [outfile], if it has one.
+func appendAPEv2Tag(outfile io.Writer, inpath string) error {
+	infile, err := os.Open(inpath)
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+
+	info, err := infile.Stat()
+	if err != nil {
+		return err
+	}
+
+	tag, err := mp3lib.ReadAPEv2Tag(infile, info.Size())
+	if err != nil {
+		return err
+	}
+	if tag == nil {
+		return nil
 	}
+
+	_, err = outfile.Write(tag.RawBytes)
+	return err
+}
+
+// Rewrite the file at [path] with the content [write] writes to [w]. The new content goes to a
+// temporary file in [tmpdir], or the same directory if [tmpdir] is empty, with the original's
+// permissions, which replaces the original on success. [write] can read the original, but must
+// close it before returning as an open file can't be replaced on Windows.
+func rewriteFile(path, tmpdir string, write func(w io.Writer) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if tmpdir == "" {
+		tmpdir = filepath.Dir(path)
+	}
+	tmpfile, err := os.CreateTemp(tmpdir, filepath.Base(path)+".*.mp3cat.tmp")
+	if err != nil {
+		return err
+	}
+	tmppath := tmpfile.Name()
+
+	// CreateTemp uses restrictive permissions; match the original file instead.
+	err = tmpfile.Chmod(info.Mode().Perm())
+	if err == nil {
+		err = write(tmpfile)
+	}
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmppath)
+		return err
+	}
+	return moveFile(tmppath, path)
+}
+
+// Move the file at [src] to [dst], replacing any existing file. If the two paths are on
+// different devices, we fall back to copying the file's content and deleting the original.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+
+	srcFile.Close()
+	return os.Remove(src)
+}
+
+// Returns true if an error returned by an mp3lib read function marks the end of the stream,
+// including a stream which ends partway through an object.
+func isEndOfStream(err error) bool {
+	return err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF)
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Writes [count] files of [frames] frames each, cycling through the MPEG-1 layer III bitrate
+// indexes in [bitRates], to a temporary directory and returns their paths and combined size.
+func writeTestInputs(t testing.TB, count, frames int, bitRates ...byte) ([]string, int64) {
+	t.Helper()
+	var data [][]byte
+	for _, index := range bitRates {
+		frame := mp3lib.NewFrame(mp3lib.MPEGVersion1, mp3lib.MPEGLayerIII, index, 0, mp3lib.JointStereo)
+		if frame == nil {
+			t.Fatalf("invalid bitrate index %v", index)
+		}
+		data = append(data, frame.RawBytes)
+	}
+
+	var paths []string
+	var total int64
+	dir := t.TempDir()
+	for i := range count {
+		var file []byte
+		for j := range frames {
+			file = append(file, data[(i+j)%len(data)]...)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%02d.mp3", i+1))
+		if err := os.WriteFile(path, file, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+		total += int64(len(file))
+	}
+	return paths, total
+}
+
+func TestMerge(t *testing.T) {
+	setQuiet(false, true)
+
+	tests := []struct {
+		name     string
+		bitRates []byte
+		opts     mergeOptions
+		header   string // ID of the output's VBR header, if any.
+	}{
+		{"cbr", []byte{9}, mergeOptions{}, ""},
+		{"cbr with --info-header", []byte{9}, mergeOptions{infoHeader: true}, "Info"},
+		{"vbr", []byte{5, 9}, mergeOptions{}, "Xing"},
+		{"vbr with --keep-headers", []byte{5, 9}, mergeOptions{keepHeaders: true}, ""},
+		{"cbr with --chapters", []byte{9}, mergeOptions{chapters: true}, ""},
+		{"vbr with --chapters", []byte{5, 9}, mergeOptions{chapters: true}, "Xing"},
+	}
+
+	for _, test := range tests {
+		for _, checksum := range []string{"", "sha256"} {
+			name := test.name
+			if checksum != "" {
+				name += " and --checksum"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				inputs, _ := writeTestInputs(t, 3, 100, test.bitRates...)
+				dir := t.TempDir()
+				opts := test.opts
+				opts.outpath = filepath.Join(dir, "out.mp3")
+				opts.tmpdir = t.TempDir()
+				opts.checksum = checksum
+				opts.manifestPath = filepath.Join(dir, "manifest.json")
+				if err := merge(context.Background(), inputs, &opts); err != nil {
+					t.Fatal(err)
+				}
+				checkMergeOutput(t, opts.outpath, opts.manifestPath, 300, test.header, opts.chapters)
+
+				entries, err := os.ReadDir(opts.tmpdir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(opts.outpath + ".partial"); err == nil || len(entries) > 0 {
+					t.Errorf("temporary files left behind")
+				}
+			})
+		}
+	}
+}
+
+// Checks the merged output at [path]: it has [frames] audio frames following a VBR header with
+// the ID [header], or no header if [header] is empty, it starts with a tag with chapters if
+// [chapters] is true, and its checksum in the manifest at [manifestPath] is correct.
+func checkMergeOutput(t *testing.T, path, manifestPath string, frames uint64, header string, chapters bool) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := mp3lib.ReadID3v2Tag(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasChapters := tag != nil && len(tag.Chapters()) > 0; hasChapters != chapters {
+		t.Errorf("output has chapters: %v, want %v", hasChapters, chapters)
+	}
+
+	reader := bytes.NewReader(data)
+	first := mp3lib.NextFrame(reader)
+	var count uint64
+	if info, err := mp3lib.ParseXingHeader(first); err == nil {
+		if info.ID != header {
+			t.Errorf("output has an %v header, want %q", info.ID, header)
+		}
+		if uint64(info.Frames) != frames {
+			t.Errorf("header records %v frames, want %v", info.Frames, frames)
+		}
+	} else if header != "" {
+		t.Errorf("output has no header, want %v", header)
+		count++
+	} else {
+		count++
+	}
+	for mp3lib.NextFrame(reader) != nil {
+		count++
+	}
+	if count != frames {
+		t.Errorf("output has %v audio frames, want %v", count, frames)
+	}
+
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record manifest
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(data)
+	if record.Output.Checksum != hex.EncodeToString(digest[:]) {
+		t.Errorf("manifest checksum %v, want %x", record.Output.Checksum, digest)
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	inputs, total := writeTestInputs(b, 10, 5000, 5, 6, 7, 8, 9, 10, 11)
+	outpath := filepath.Join(b.TempDir(), "out.mp3")
+	setQuiet(false, true)
+	b.SetBytes(total)
+	b.ReportAllocs()
+
+	for range b.N {
+		opts := &mergeOptions{outpath: outpath, force: true}
+		if err := merge(context.Background(), inputs, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package mp3lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// APEv2 tags begin and/or end with a 32-byte block starting with this
+// preamble. The header and footer have the same layout.
+var apePreamble = []byte("APETAGEX")
+
+// Flag bits in the APEv2 header/footer.
+const (
+	apeHasHeader = 1 << 31
+	apeIsHeader  = 1 << 29
+)
+
+// Guard against absurd sizes from corrupt headers.
+const apeMaxSize = 16 << 20
+
+// APEv2Tag represents an APEv2 metadata tag, as written by tools like
+// mp3gain. Tags found by NextObject contain the full tag. A tag with only a
+// footer, the usual form, is found once its footer is reached, and claims
+// the data preceding the footer as its items; if there's less of it than the
+// footer's size, the tag contains only the 32-byte footer. Use ReadAPEv2Tag
+// to read a tag from the end of a file.
+type APEv2Tag struct {
+	Version   uint32
+	ItemCount uint32
+	RawBytes  []byte
+}
+
+// apeBlock holds the fields of an APEv2 header or footer.
+type apeBlock struct {
+	version   uint32
+	size      uint32 // Size of the items plus the footer, excluding any header.
+	itemCount uint32
+	flags     uint32
+}
+
+// parseAPEBlock parses a 32-byte APEv2 header or footer. Returns false if
+// the data isn't a valid block.
+func parseAPEBlock(data []byte) (apeBlock, bool) {
+	if len(data) < 32 || !bytes.Equal(data[:8], apePreamble) {
+		return apeBlock{}, false
+	}
+	block := apeBlock{
+		version:   binary.LittleEndian.Uint32(data[8:12]),
+		size:      binary.LittleEndian.Uint32(data[12:16]),
+		itemCount: binary.LittleEndian.Uint32(data[16:20]),
+		flags:     binary.LittleEndian.Uint32(data[20:24]),
+	}
+	if block.size < 32 || block.size > apeMaxSize {
+		return apeBlock{}, false
+	}
+	return block, true
+}
+
+// tagLength returns the length of the tag described by the block in bytes,
+// including the header if the tag has one.
+func (b apeBlock) tagLength() int {
+	if b.flags&apeHasHeader != 0 {
+		return int(b.size) + 32
+	}
+	return int(b.size)
+}
+
+// ReadAPEv2Tag reads the APEv2 tag from the end of a file of the given size.
+// The tag may be followed by an ID3v1 tag. Returns nil and no error if the
+// file has no APEv2 tag.
+func ReadAPEv2Tag(r io.ReaderAt, size int64) (*APEv2Tag, error) {
+	for _, end := range []int64{size, size - 128} {
+		if end < 32 {
+			continue
+		}
+
+		footer := make([]byte, 32)
+		if _, err := r.ReadAt(footer, end-32); err != nil {
+			return nil, err
+		}
+		block, ok := parseAPEBlock(footer)
+		if !ok || block.flags&apeIsHeader != 0 {
+			continue
+		}
+
+		length := int64(block.tagLength())
+		if length > end {
+			return nil, errors.New("mp3lib: APEv2 tag is larger than the file")
+		}
+		if err := checkTagSize(length); err != nil {
+			return nil, err
+		}
+		tag := &APEv2Tag{
+			Version:   block.version,
+			ItemCount: block.itemCount,
+			RawBytes:  make([]byte, length),
+		}
+		if _, err := r.ReadAt(tag.RawBytes, end-length); err != nil {
+			return nil, err
+		}
+		return tag, nil
+	}
+	return nil, nil
+}
+
+// readAPEv2Tag reads the remainder of an APEv2 tag from the stream, given
+// its 32-byte header or footer. If the block is a header, the tag items and
+// footer follow it. If it's a footer, the items precede it, at the end of
+// [preceding], the unrecognised data read before the footer.
+func readAPEv2Tag(stream io.Reader, data []byte, preceding []byte) (*APEv2Tag, error) {
+	block, ok := parseAPEBlock(data)
+	if !ok {
+		// A corrupt tag. Treat it as empty so the data is skipped.
+		return &APEv2Tag{RawBytes: data}, nil
+	}
+
+	tag := &APEv2Tag{Version: block.version, ItemCount: block.itemCount}
+	if block.flags&apeIsHeader == 0 {
+		tag.RawBytes = data
+		if items := footerItems(block, len(preceding)); items > 0 {
+			tag.RawBytes = append(append([]byte(nil), preceding[len(preceding)-items:]...), data...)
+		}
+		return tag, nil
+	}
+
+	if err := checkTagSize(int64(block.size) + 32); err != nil {
+		return nil, err
+	}
+
+	tag.RawBytes = make([]byte, int(block.size)+32)
+	copy(tag.RawBytes, data)
+	if err := fillBuffer(stream, tag.RawBytes[32:]); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// footerItems returns the length of the items preceding an APEv2 footer, if
+// [skipped], the length of the unrecognised data directly before it, can
+// hold them, otherwise zero. The footer's size includes the footer itself
+// but not any header, which would have been found as a tag of its own.
+func footerItems(footer apeBlock, skipped int) int {
+	items := int(footer.size) - 32
+	if footer.flags&apeHasHeader != 0 || items > skipped {
+		return 0
+	}
+	return items
+}
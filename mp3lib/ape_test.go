@@ -0,0 +1,114 @@
+package mp3lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// Returns an APEv2 tag with a single item, with a header if [withHeader] is true and always
+// with a footer.
+func testAPETag(withHeader bool) []byte {
+	var items []byte
+	value := []byte("-6.00 dB")
+	items = binary.LittleEndian.AppendUint32(items, uint32(len(value)))
+	items = binary.LittleEndian.AppendUint32(items, 0)
+	items = append(items, "REPLAYGAIN_TRACK_GAIN\x00"...)
+	items = append(items, value...)
+
+	block := func(flags uint32) []byte {
+		data := append([]byte(nil), apePreamble...)
+		data = binary.LittleEndian.AppendUint32(data, 2000)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(items)+32))
+		data = binary.LittleEndian.AppendUint32(data, 1)
+		data = binary.LittleEndian.AppendUint32(data, flags)
+		return append(data, make([]byte, 8)...)
+	}
+
+	var tag []byte
+	if withHeader {
+		tag = append(tag, block(apeHasHeader|apeIsHeader)...)
+		tag = append(tag, items...)
+		return append(tag, block(apeHasHeader)...)
+	}
+	return append(append(tag, items...), block(0)...)
+}
+
+func TestAPETagIsNotGarbage(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		withHeader bool
+	}{
+		{"footer only", false},
+		{"header and footer", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			frame := testFrame(t)
+			tag := testAPETag(test.withHeader)
+			stream := bytes.Join([][]byte{frame, frame, tag}, nil)
+
+			issues, err := Validate(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != 0 {
+				t.Errorf("Validate found %v", issues)
+			}
+
+			reader := NewFrameReader(bytes.NewReader(stream))
+			var frames int
+			for {
+				if err := reader.ReadInto(&MP3Frame{}); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				frames++
+			}
+			if frames != 2 || reader.Skipped() != 0 {
+				t.Errorf("FrameReader read %v frames and skipped %v bytes, want 2 and 0", frames, reader.Skipped())
+			}
+
+			objects := bytes.NewReader(stream)
+			var found *APEv2Tag
+			for {
+				obj, err := NextObjectErr(objects)
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if ape, ok := obj.(*APEv2Tag); ok {
+					found = ape
+				}
+			}
+			if found == nil || !bytes.Equal(found.RawBytes, tag) {
+				t.Errorf("NextObject didn't return the whole tag")
+			}
+		})
+	}
+}
+
+// A footer which claims more items than precede it leaves the data before it as garbage.
+func TestAPEFooterLargerThanItems(t *testing.T) {
+	frame := testFrame(t)
+	tag := testAPETag(false)
+	footer := tag[len(tag)-32:]
+	stream := bytes.Join([][]byte{frame, []byte("junk"), footer}, nil)
+
+	issues, err := Validate(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Kind != IssueGarbage || issues[0].Offset != int64(len(frame)) {
+		t.Errorf("Validate found %v, want 4 bytes of garbage at offset %v", issues, len(frame))
+	}
+
+	reader := NewFrameReader(bytes.NewReader(stream))
+	for reader.ReadInto(&MP3Frame{}) == nil {
+	}
+	if reader.Skipped() != 4 {
+		t.Errorf("FrameReader skipped %v bytes, want 4", reader.Skipped())
+	}
+}
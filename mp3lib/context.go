@@ -0,0 +1,40 @@
+package mp3lib
+
+import (
+	"context"
+	"io"
+)
+
+// NextFrameContext is like NextFrameErr but stops reading and returns the
+// context's error if the context is cancelled. A read which is already in
+// progress is not interrupted.
+func NextFrameContext(ctx context.Context, stream io.Reader) (*MP3Frame, error) {
+	return NextFrameErr(NewContextReader(ctx, stream))
+}
+
+// NextObjectContext is like NextObjectErr but stops reading and returns the
+// context's error if the context is cancelled. A read which is already in
+// progress is not interrupted.
+func NextObjectContext(ctx context.Context, stream io.Reader) (interface{}, error) {
+	return NextObjectErr(NewContextReader(ctx, stream))
+}
+
+// NewContextReader wraps a stream so that reads fail with the context's error
+// once the context is cancelled. Use it to make a FrameReader cancellable.
+func NewContextReader(ctx context.Context, stream io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, stream: stream}
+}
+
+// contextReader checks for cancellation before each read from the wrapped
+// stream, so long scans through garbage data can be cancelled too.
+type contextReader struct {
+	ctx    context.Context
+	stream io.Reader
+}
+
+func (r *contextReader) Read(buf []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.stream.Read(buf)
+}
@@ -0,0 +1,89 @@
+package mp3lib
+
+import "encoding/binary"
+
+// VerifyCRC returns true if the frame's stored 16-bit CRC matches the CRC
+// computed from its contents. Frames without CRC protection always pass.
+// Layer II frames also always pass as checking them requires decoding the
+// bit allocation tables.
+func VerifyCRC(frame *MP3Frame) bool {
+	if !frame.CrcProtection {
+		return true
+	}
+	crc, ok := FrameCRC(frame)
+	if !ok {
+		return frame.MPEGLayer == MPEGLayerII
+	}
+	return crc == binary.BigEndian.Uint16(frame.RawBytes[4:6])
+}
+
+// FrameCRC computes the 16-bit CRC of a frame from its contents. The CRC
+// covers the last two bytes of the header and the protected bits following
+// the 2-byte CRC field itself - the side information for layer III, the bit
+// allocation for layer I. Returns false if the frame is too short or the
+// layer isn't supported. The result doesn't depend on whether the frame's
+// protection bit is set.
+func FrameCRC(frame *MP3Frame) (uint16, bool) {
+	var nbits int
+	switch frame.MPEGLayer {
+	case MPEGLayerIII:
+		nbits = getSideInfoSize(frame) * 8
+	case MPEGLayerI:
+		nbits = getLayerIAllocationBits(frame)
+	default:
+		return 0, false
+	}
+
+	if len(frame.RawBytes) < 6+(nbits+7)/8 {
+		return 0, false
+	}
+
+	crc := crc16(0xFFFF, frame.RawBytes[2:4], 16)
+	crc = crc16(crc, frame.RawBytes[6:], nbits)
+	return crc, true
+}
+
+// UpdateCRC recomputes the stored CRC of a CRC-protected frame. It should be
+// called after modifying a frame's header or protected bits. Returns false if
+// the frame is CRC-protected but its CRC can't be computed. Frames without CRC
+// protection are left unchanged.
+func UpdateCRC(frame *MP3Frame) bool {
+	if !frame.CrcProtection {
+		return true
+	}
+	crc, ok := FrameCRC(frame)
+	if !ok {
+		return false
+	}
+	binary.BigEndian.PutUint16(frame.RawBytes[4:6], crc)
+	return true
+}
+
+// getLayerIAllocationBits returns the size in bits of a layer I frame's bit
+// allocation section. Each of the 32 subbands has a 4-bit allocation per
+// channel, except that in joint stereo mode subbands above the bound
+// specified by the mode extension share a single allocation.
+func getLayerIAllocationBits(frame *MP3Frame) int {
+	if frame.ChannelMode == Mono {
+		return 32 * 4
+	}
+	bound := 32
+	if frame.ChannelMode == JointStereo {
+		bound = (int(frame.ModeExtension) + 1) * 4
+	}
+	return bound*2*4 + (32-bound)*4
+}
+
+// crc16 updates the CRC with the first nbits bits of data, using the
+// CRC-16 polynomial 0x8005 specified for MPEG audio.
+func crc16(crc uint16, data []byte, nbits int) uint16 {
+	for i := 0; i < nbits; i++ {
+		bit := uint16(data[i/8]>>(7-i%8)) & 1
+		msb := crc >> 15
+		crc <<= 1
+		if msb^bit == 1 {
+			crc ^= 0x8005
+		}
+	}
+	return crc
+}
@@ -0,0 +1,189 @@
+package mp3lib
+
+import "errors"
+
+// ErrReservoirOverflow is returned by a CRCStripper when a frame's audio data
+// can't be fitted into the rebuilt stream's bit reservoir.
+var ErrReservoirOverflow = errors.New("mp3lib: frame data doesn't fit in the bit reservoir")
+
+// CRCStripper removes the CRC from the frames of a stream, so they can be
+// merged with frames which aren't CRC-protected.
+//
+// Removing the 2-byte CRC from a frame leaves 2 extra bytes in it, as the
+// frame's length is fixed by its bit rate. For layer I and II frames these
+// are added to the ancillary data at the end of the frame. Layer III frames
+// store their audio data in a continuous stream which runs through the frames
+// (the bit reservoir), so the stream is rebuilt: each frame's audio data is
+// placed as early as the reservoir allows, and the frame's main_data_begin
+// field is updated to point to it.
+//
+// Frames are returned once their content is final, which for layer III can
+// be a few frames after they're added. Call Flush at the end of the stream to
+// get the rest.
+type CRCStripper struct {
+	pending []*strippedFrame // Layer III frames waiting for their main data.
+
+	// The rebuilt main data stream. The first byte of data is at position
+	// base; end is the position after the last byte of audio data placed.
+	data []byte
+	base int
+	end  int
+
+	// Position of the end of the most recent frame's main data section.
+	sectionEnd int
+
+	// The original main data stream, starting at position oldBase.
+	old        []byte
+	oldBase    int
+	oldSection int // Position of the end of the most recent original section.
+
+	started bool
+}
+
+// A layer III frame being rebuilt, with the position of its main data
+// section in the rebuilt stream.
+type strippedFrame struct {
+	frame        *MP3Frame
+	sectionStart int
+	sectionEnd   int
+}
+
+// NewCRCStripper returns a CRCStripper for a new stream.
+func NewCRCStripper() *CRCStripper {
+	return &CRCStripper{}
+}
+
+// Add strips the CRC from a frame and returns any frames which are now
+// complete, in order. The frame itself is copied, so it can be reused once
+// Add returns. Frames without a CRC are passed through the same process so
+// the order of the stream is kept.
+func (s *CRCStripper) Add(frame *MP3Frame) ([]*MP3Frame, error) {
+	if frame.MPEGLayer != MPEGLayerIII {
+		ready := s.Flush()
+		return append(ready, stripCRC(frame)), nil
+	}
+
+	offset := getSideInfoOffset(frame)
+	sideInfoSize := getSideInfoSize(frame)
+	if len(frame.RawBytes) < offset+sideInfoSize {
+		return nil, errors.New("mp3lib: frame is too short to contain side information")
+	}
+
+	info, err := ParseSideInfo(frame)
+	if err != nil {
+		return nil, err
+	}
+	var bits int
+	for gr := 0; gr < info.NumGranules; gr++ {
+		for ch := 0; ch < info.NumChannels; ch++ {
+			bits += info.Granules[gr][ch].Part2_3Length
+		}
+	}
+	length := (bits + 7) / 8
+
+	// Add the frame's section to the original stream and find its audio data.
+	section := frame.RawBytes[offset+sideInfoSize:]
+	oldStart := s.oldSection
+	s.old = append(s.old, section...)
+	s.oldSection += len(section)
+	audio := s.readOld(oldStart-info.MainDataBegin, length)
+
+	// The first frame keeps its original reference into the preceding data,
+	// which belongs to whatever came before the stream.
+	if !s.started {
+		s.end = -info.MainDataBegin
+		s.base = 0
+		s.started = true
+	}
+
+	// The rebuilt frame has no CRC, so its section is 2 bytes longer.
+	stripped := stripCRC(frame)
+	newSection := len(stripped.RawBytes) - 4 - sideInfoSize
+	pending := &strippedFrame{frame: stripped, sectionStart: s.sectionEnd, sectionEnd: s.sectionEnd + newSection}
+	s.sectionEnd = pending.sectionEnd
+	s.data = append(s.data, make([]byte, newSection)...)
+
+	// Place the audio data as early as possible.
+	start := max(s.end, pending.sectionStart-maxMainDataBegin(frame))
+	if start+length > pending.sectionEnd {
+		return nil, ErrReservoirOverflow
+	}
+	for i, b := range audio {
+		if pos := start + i; pos >= s.base {
+			s.data[pos-s.base] = b
+		}
+	}
+	s.end = start + length
+	SetMainDataBegin(stripped, pending.sectionStart-start)
+	s.pending = append(s.pending, pending)
+
+	// Drop original data which can no longer be referenced.
+	if drop := s.oldSection - 4096 - s.oldBase; drop > 0 {
+		s.old = s.old[drop:]
+		s.oldBase += drop
+	}
+
+	return s.release(s.end), nil
+}
+
+// Flush returns the frames which are still waiting for their content.
+func (s *CRCStripper) Flush() []*MP3Frame {
+	ready := s.release(s.sectionEnd)
+	s.end = max(s.end, s.base)
+	return ready
+}
+
+// Return the pending frames whose sections end at or before [pos], with
+// their sections filled in from the rebuilt stream.
+func (s *CRCStripper) release(pos int) []*MP3Frame {
+	var ready []*MP3Frame
+	for len(s.pending) > 0 && s.pending[0].sectionEnd <= pos {
+		pending := s.pending[0]
+		s.pending = s.pending[1:]
+
+		sectionOffset := len(pending.frame.RawBytes) - (pending.sectionEnd - pending.sectionStart)
+		copy(pending.frame.RawBytes[sectionOffset:], s.data[pending.sectionStart-s.base:pending.sectionEnd-s.base])
+		ready = append(ready, pending.frame)
+	}
+
+	// Drop rebuilt data which has been released.
+	if len(s.pending) == 0 {
+		s.data = s.data[:0]
+		s.base = s.sectionEnd
+	} else if drop := s.pending[0].sectionStart - s.base; drop > 0 {
+		s.data = s.data[drop:]
+		s.base += drop
+	}
+
+	return ready
+}
+
+// Returns [length] bytes of the original main data stream starting at
+// [start]. Bytes before the start of the stream, or no longer held, read as
+// zero.
+func (s *CRCStripper) readOld(start, length int) []byte {
+	out := make([]byte, length)
+	for i := range out {
+		if pos := start + i - s.oldBase; pos >= 0 && pos < len(s.old) {
+			out[i] = s.old[pos]
+		}
+	}
+	return out
+}
+
+// Returns a copy of a frame without its CRC. The frame's content is moved
+// up by 2 bytes, leaving 2 zero bytes at the end.
+func stripCRC(frame *MP3Frame) *MP3Frame {
+	stripped := &MP3Frame{}
+	*stripped = *frame
+	stripped.RawBytes = make([]byte, len(frame.RawBytes))
+	copy(stripped.RawBytes, frame.RawBytes[:4])
+	if frame.CrcProtection && len(frame.RawBytes) >= 6 {
+		copy(stripped.RawBytes[4:], frame.RawBytes[6:])
+	} else {
+		copy(stripped.RawBytes[4:], frame.RawBytes[4:])
+	}
+	stripped.RawBytes[1] |= 0x01
+	stripped.CrcProtection = false
+	return stripped
+}
@@ -0,0 +1,71 @@
+package mp3lib
+
+import (
+	"bytes"
+	"io"
+)
+
+// Names of non-MP3 formats recognised by DetectFormat.
+const (
+	FormatAAC = "AAC"
+	FormatMP4 = "MP4"
+)
+
+// Number of bytes DetectFormat needs from the start of a file.
+const detectSize = 4096
+
+// DetectFormat reads the start of the stream and returns the name of the
+// audio format if it's a format commonly mistaken for MP3 - AAC in an ADTS
+// or ADIF stream, or an MP4/M4A container. Returns an empty string if the
+// stream isn't one of these formats. A leading ID3v2 tag is skipped.
+func DetectFormat(r io.Reader) (string, error) {
+	data := make([]byte, detectSize)
+	n, err := io.ReadFull(r, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	data = data[:n]
+
+	// MP4 containers begin with an 'ftyp' box: a 4-byte size, then 'ftyp'.
+	if len(data) >= 8 && bytes.Equal(data[4:8], []byte("ftyp")) {
+		return FormatMP4, nil
+	}
+
+	// Skip an ID3v2 tag, which can precede raw AAC streams.
+	if len(data) >= 10 && bytes.Equal(data[:3], []byte("ID3")) {
+		length := 10 + ((int(data[6]) << (7 * 3)) |
+			(int(data[7]) << (7 * 2)) |
+			(int(data[8]) << (7 * 1)) |
+			(int(data[9]) << (7 * 0)))
+		if length >= len(data) {
+			return "", nil
+		}
+		data = data[length:]
+	}
+
+	if bytes.HasPrefix(data, []byte("ADIF")) {
+		return FormatAAC, nil
+	}
+
+	// ADTS frames share MPEG audio's 12-bit sync word but have a layer of
+	// zero, which is reserved in MPEG audio. Require two consecutive frames
+	// to avoid false positives.
+	if length := adtsFrameLength(data); length > 0 && adtsFrameLength(data[length:]) > 0 {
+		return FormatAAC, nil
+	}
+
+	return "", nil
+}
+
+// adtsFrameLength returns the length of the ADTS frame at the start of data,
+// or 0 if data doesn't begin with an ADTS frame header.
+func adtsFrameLength(data []byte) int {
+	if len(data) < 7 || data[0] != 0xFF || data[1]&0xF6 != 0xF0 {
+		return 0
+	}
+	length := int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5])>>5
+	if length < 7 || length > len(data) {
+		return 0
+	}
+	return length
+}
@@ -0,0 +1,58 @@
+package mp3lib
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Duration returns the playing time of the MP3 stream read from r. If the
+// stream begins with an Xing header recording the number of frames, the
+// duration is calculated from the header without reading the rest of the
+// stream; otherwise every frame is read and its duration summed.
+func Duration(r io.Reader) (time.Duration, error) {
+	reader := NewFrameReader(r)
+	frame := &MP3Frame{}
+
+	var total time.Duration
+	var frames, read int
+
+	for {
+		err := reader.ReadInto(frame)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
+		// The Xing frame count is only used if the header is the first frame.
+		// A header found after a junk frame is skipped.
+		read++
+		if read <= VBRHeaderSearchFrames && IsVBRHeaderFrame(frame) {
+			if frames == 0 && IsXingHeader(frame) {
+				xing, err := ParseXingHeader(frame)
+				if err == nil && xing.Flags&XingFramesFlag != 0 {
+					return time.Duration(xing.Frames) * frame.Duration(), nil
+				}
+			}
+			continue
+		}
+
+		total += frame.Duration()
+		frames++
+	}
+
+	if frames == 0 {
+		return 0, ErrNoFrames
+	}
+	return total, nil
+}
+
+// Duration returns the playing time of the frame, calculated from its sample
+// count and sampling rate.
+func (frame *MP3Frame) Duration() time.Duration {
+	if frame.SamplingRate == 0 {
+		return 0
+	}
+	return time.Duration(frame.SampleCount) * time.Second / time.Duration(frame.SamplingRate)
+}
@@ -0,0 +1,33 @@
+package mp3lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoFrames is returned when a stream contains no MP3 frames.
+var ErrNoFrames = errors.New("mp3lib: no frames found")
+
+// ErrTruncatedFrame is returned when a stream ends partway through an MP3
+// frame. It wraps io.ErrUnexpectedEOF so errors.Is(err, io.ErrUnexpectedEOF)
+// also reports true.
+var ErrTruncatedFrame = fmt.Errorf("mp3lib: truncated frame: %w", io.ErrUnexpectedEOF)
+
+// ErrTagTooLarge is returned when an ID3v2 or APEv2 tag's header claims a
+// size larger than MaxTagSize.
+var ErrTagTooLarge = errors.New("mp3lib: tag exceeds the maximum size")
+
+// ErrResyncLimit is returned when a reader with a resync limit skips more
+// unrecognised data in a row than the limit allows.
+var ErrResyncLimit = errors.New("mp3lib: too much unrecognised data")
+
+// ErrBadHeader is returned when the data at Offset was expected to begin
+// with a valid MP3 frame header but didn't.
+type ErrBadHeader struct {
+	Offset int64
+}
+
+func (e *ErrBadHeader) Error() string {
+	return fmt.Sprintf("mp3lib: invalid frame header at offset %d", e.Offset)
+}
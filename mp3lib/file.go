@@ -0,0 +1,124 @@
+package mp3lib
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// File provides random access to the frames of an MP3 file via an index of
+// frame offsets and timestamps.
+type File struct {
+	reader io.ReaderAt
+	size   int64
+	index  []IndexEntry
+}
+
+// IndexEntry records the location and start time of a single frame.
+type IndexEntry struct {
+	Offset    int64
+	Length    int
+	Timestamp time.Duration
+}
+
+// NewFile returns a File reading from the first size bytes of reader. The
+// frame index is built by calling BuildIndex.
+func NewFile(reader io.ReaderAt, size int64) *File {
+	return &File{reader: reader, size: size}
+}
+
+// BuildIndex scans the file and records the offset and timestamp of each
+// audio frame. A VBR header frame at the start of the file is not indexed.
+func (f *File) BuildIndex() error {
+	f.index = f.index[:0]
+
+	reader := NewFrameReader(io.NewSectionReader(f.reader, 0, f.size))
+	frame := &MP3Frame{}
+	var timestamp time.Duration
+	var read int
+
+	for {
+		err := reader.ReadInto(frame)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		read++
+		if read <= VBRHeaderSearchFrames && IsVBRHeaderFrame(frame) {
+			continue
+		}
+
+		f.index = append(f.index, IndexEntry{
+			Offset:    reader.Offset(),
+			Length:    len(frame.RawBytes),
+			Timestamp: timestamp,
+		})
+		timestamp += frame.Duration()
+	}
+}
+
+// NumFrames returns the number of frames in the index.
+func (f *File) NumFrames() int {
+	return len(f.index)
+}
+
+// Index returns the frame index. The slice must not be modified.
+func (f *File) Index() []IndexEntry {
+	return f.index
+}
+
+// FrameAt reads and returns the i-th indexed frame.
+func (f *File) FrameAt(i int) (*MP3Frame, error) {
+	if i < 0 || i >= len(f.index) {
+		return nil, errors.New("mp3lib: frame index out of range")
+	}
+
+	entry := f.index[i]
+	data := make([]byte, entry.Length)
+	if _, err := f.reader.ReadAt(data, entry.Offset); err != nil {
+		return nil, err
+	}
+
+	frame := ParseHeader(data)
+	if frame == nil {
+		return nil, &ErrBadHeader{Offset: entry.Offset}
+	}
+	frame.RawBytes = data
+	return frame, nil
+}
+
+// FrameIndexForTime returns the index of the frame playing at time t. Times
+// past the end of the file return the index of the last frame.
+func (f *File) FrameIndexForTime(t time.Duration) (int, error) {
+	if len(f.index) == 0 {
+		return 0, ErrNoFrames
+	}
+	i := sort.Search(len(f.index), func(i int) bool {
+		return f.index[i].Timestamp > t
+	})
+	return max(i-1, 0), nil
+}
+
+// OffsetForTime returns the byte offset of the frame playing at time t.
+func (f *File) OffsetForTime(t time.Duration) (int64, error) {
+	i, err := f.FrameIndexForTime(t)
+	if err != nil {
+		return 0, err
+	}
+	return f.index[i].Offset, nil
+}
+
+// Duration returns the total playing time of the indexed frames.
+func (f *File) Duration() time.Duration {
+	if len(f.index) == 0 {
+		return 0
+	}
+	last, err := f.FrameAt(len(f.index) - 1)
+	if err != nil {
+		return f.index[len(f.index)-1].Timestamp
+	}
+	return f.index[len(f.index)-1].Timestamp + last.Duration()
+}
@@ -0,0 +1,274 @@
+package mp3lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Returns a small tag size limit for the duration of a fuzz test, so inputs
+// claiming huge tags fail fast instead of allocating the default limit.
+func limitTagSize(f *testing.F) {
+	saved := MaxTagSize
+	MaxTagSize = 1 << 20
+	f.Cleanup(func() { MaxTagSize = saved })
+}
+
+// Returns seed inputs common to the stream fuzz tests: frames, tags, and
+// headers of each kind the parsers recognise.
+func streamSeeds(f *testing.F) [][]byte {
+	frame := testFrame(f)
+	crc := bytes.Clone(frame)
+	crc[1] &^= 0x01
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	id3v2 := NewID3v2Tag([]*ID3v2Frame{NewTextFrame("TIT2", "Title")}).RawBytes
+	riff := []byte("RIFF\x00\x00\x00\x00WAVEfmt \x02\x00\x00\x00\x55\x00data\x00\x00\x00\x00")
+	xing := NewVBRHeaderFrame(ParseHeader(frame), 2, uint64(2*len(frame)), &XingTOC{}, true, 0).RawBytes
+
+	return [][]byte{
+		{},
+		{0xFF, 0xFB},
+		frame,
+		frame[:len(frame)/2],
+		append(append(bytes.Clone(frame), "garbage"...), frame...),
+		crc,
+		testLayerIIIFrame(f, MPEGVersion2, 1, 0, Mono),
+		testLayerIIIFrame(f, MPEGVersion2_5, 14, 2, DualChannel),
+		append(append(bytes.Clone(id3v2), frame...), id3v1...),
+		append(bytes.Clone(frame), testAPETag(true)...),
+		append(bytes.Clone(frame), testAPETag(false)...),
+		append(riff, frame...),
+		append(xing, frame...),
+		[]byte("ID3\x04\x00\x00\x7F\x7F\x7F\x7F"),
+	}
+}
+
+// NextObject never panics, and the objects it returns are no longer than
+// the data read to find them.
+func FuzzNextObject(f *testing.F) {
+	limitTagSize(f)
+	for _, seed := range streamSeeds(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		var total int
+		for range len(data) + 1 {
+			obj, err := NextObjectErr(reader)
+			if err != nil {
+				return
+			}
+
+			var raw []byte
+			switch obj := obj.(type) {
+			case *MP3Frame:
+				raw = obj.RawBytes
+				if len(raw) != obj.FrameLength || len(raw) < 4 {
+					t.Fatalf("frame of %v bytes has length %v", len(raw), obj.FrameLength)
+				}
+				if parsed := ParseHeader(raw); parsed == nil || parsed.FrameLength != obj.FrameLength {
+					t.Fatalf("frame header % x doesn't reparse to length %v", raw[:4], obj.FrameLength)
+				}
+			case *ID3v1Tag:
+				raw = obj.RawBytes
+				if len(raw) != 128 {
+					t.Fatalf("ID3v1 tag of %v bytes", len(raw))
+				}
+			case *ID3v2Tag:
+				raw = obj.RawBytes
+				if len(raw) < 10 || len(raw) > MaxTagSize {
+					t.Fatalf("ID3v2 tag of %v bytes", len(raw))
+				}
+			case *APEv2Tag:
+				raw = obj.RawBytes
+			case *RIFFHeader:
+				raw = obj.RawBytes
+			default:
+				t.Fatalf("unexpected object %T", obj)
+			}
+
+			total += len(raw)
+			if read := len(data) - reader.Len(); total > read {
+				t.Fatalf("objects total %v bytes after reading %v", total, read)
+			}
+		}
+		t.Fatalf("more objects than bytes of input")
+	})
+}
+
+// ParseXingHeader never panics, and the fields it reads are written back
+// unchanged by NewXingFrame.
+func FuzzParseXingHeader(f *testing.F) {
+	template := ParseHeader(testFrame(f))
+	toc := bytes.Repeat([]byte{128}, 100)
+	all := uint32(XingFramesFlag | XingBytesFlag | XingTOCFlag | XingQualityFlag)
+	f.Add(NewXingFrame(template, &XingInfo{ID: "Xing", Flags: all, Frames: 1, Bytes: 2, TOC: toc, Quality: 3}).RawBytes)
+	f.Add(NewXingFrame(template, &XingInfo{ID: "Info", Flags: XingFramesFlag}).RawBytes)
+	f.Add(NewXingHeader(100, 100000).RawBytes)
+	f.Add(NewVBRHeaderFrame(ParseHeader(testLayerIIIFrame(f, MPEGVersion2, 1, 2, Mono)), 10, 1000, &XingTOC{}, false, 0).RawBytes)
+
+	// A header with a LAME extension.
+	lame := NewXingFrame(template, &XingInfo{ID: "Info", Flags: XingFramesFlag, Frames: 10}).RawBytes
+	copy(lame[xingOffset(ParseHeader(lame))+12:], "LAME3.100\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x24\x01\x80")
+	f.Add(lame)
+
+	// Truncated headers.
+	f.Add(lame[:40])
+	f.Add(testFrame(f)[:50])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame := ParseHeader(data)
+		if frame == nil {
+			return
+		}
+		frame.RawBytes = data[:min(len(data), frame.FrameLength)]
+
+		info, err := ParseXingHeader(frame)
+		if err != nil {
+			return
+		}
+		if !IsXingHeader(frame) || !IsVBRHeaderFrame(frame) {
+			t.Fatalf("parsed an Xing header from a frame which isn't one")
+		}
+		if info.ID != "Xing" && info.ID != "Info" {
+			t.Fatalf("ID %q", info.ID)
+		}
+		if (info.Flags&XingTOCFlag != 0) != (len(info.TOC) == 100) || (info.TOC != nil && len(info.TOC) != 100) {
+			t.Fatalf("flags %#x with %v TOC entries", info.Flags, len(info.TOC))
+		}
+		if info.Delay > 0xFFF || info.Padding > 0xFFF || (info.Encoder == "" && info.Delay+info.Padding > 0) {
+			t.Fatalf("encoder %q, delay %v, padding %v", info.Encoder, info.Delay, info.Padding)
+		}
+
+		rebuilt, err := ParseXingHeader(NewXingFrame(frame, info))
+		if err != nil {
+			t.Fatalf("rebuilt header doesn't parse: %v", err)
+		}
+		if rebuilt.ID != info.ID || rebuilt.Flags != info.Flags || rebuilt.Frames != info.Frames ||
+			rebuilt.Bytes != info.Bytes || rebuilt.Quality != info.Quality || !bytes.Equal(rebuilt.TOC, info.TOC) {
+			t.Fatalf("rebuilt header %+v, want %+v", rebuilt, info)
+		}
+	})
+}
+
+// ParseSideInfo never panics, parses every layer III frame, and returns
+// fields within the ranges of their bit widths.
+func FuzzParseSideInfo(f *testing.F) {
+	for _, seed := range streamSeeds(f) {
+		f.Add(seed)
+	}
+	noisy := testFrame(f)
+	for i := 4; i < len(noisy); i++ {
+		noisy[i] = byte(i * 37)
+	}
+	f.Add(noisy)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame := NextFrame(bytes.NewReader(data))
+		if frame == nil {
+			return
+		}
+
+		info, err := ParseSideInfo(frame)
+		if frame.MPEGLayer != MPEGLayerIII {
+			if err == nil {
+				t.Fatalf("parsed side information from a layer %v frame", frame.MPEGLayer)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("layer III frame of %v bytes: %v", len(frame.RawBytes), err)
+		}
+
+		wantGranules, wantChannels := 2, 2
+		if frame.MPEGVersion != MPEGVersion1 {
+			wantGranules = 1
+		}
+		if frame.ChannelMode == Mono {
+			wantChannels = 1
+		}
+		if info.NumGranules != wantGranules || info.NumChannels != wantChannels {
+			t.Fatalf("%v granules and %v channels, want %v and %v",
+				info.NumGranules, info.NumChannels, wantGranules, wantChannels)
+		}
+		if info.MainDataBegin != MainDataBegin(frame) || info.MainDataBegin > maxMainDataBegin(frame) {
+			t.Fatalf("main_data_begin %v, want %v", info.MainDataBegin, MainDataBegin(frame))
+		}
+
+		for gr := range 2 {
+			for ch := range 2 {
+				g := info.Granules[gr][ch]
+				if gr >= info.NumGranules || ch >= info.NumChannels {
+					if g != (Granule{}) {
+						t.Fatalf("granule %v, channel %v isn't in the frame but is set: %+v", gr, ch, g)
+					}
+					continue
+				}
+				if g.Part2_3Length >= 1<<12 || g.BigValues >= 1<<9 || g.GlobalGain >= 1<<8 ||
+					g.ScalefacCompress >= 1<<9 || g.BlockType >= 1<<2 || g.Region0Count >= 1<<4 ||
+					g.Region1Count >= 1<<3 || g.Count1TableSelect >= 1<<1 {
+					t.Fatalf("granule %v, channel %v has a field out of range: %+v", gr, ch, g)
+				}
+				for _, table := range g.TableSelect {
+					if table >= 1<<5 {
+						t.Fatalf("granule %v, channel %v has table %v", gr, ch, table)
+					}
+				}
+			}
+		}
+	})
+}
+
+// DetectFormat never panics or fails on readable data, reads no more than
+// the start of the stream, and only names formats whose signatures it finds.
+func FuzzDetectFormat(f *testing.F) {
+	adts := func(length int) []byte {
+		frame := make([]byte, length)
+		copy(frame, []byte{0xFF, 0xF1, 0x50, 0x80})
+		frame[3] |= byte(length >> 11)
+		frame[4] = byte(length >> 3)
+		frame[5] = byte(length<<5) | 0x1F
+		return frame
+	}
+	id3v2 := NewID3v2Tag([]*ID3v2Frame{NewTextFrame("TIT2", "Title")}).RawBytes
+
+	f.Add([]byte{})
+	f.Add([]byte("ADIF"))
+	f.Add(append(adts(100), adts(120)...))
+	f.Add(adts(100))
+	f.Add(append(bytes.Clone(id3v2), append(adts(50), adts(50)...)...))
+	f.Add(append(binary.BigEndian.AppendUint32(nil, 24), "ftypM4A "...))
+	f.Add(append(testFrame(f), testFrame(f)...))
+	f.Add([]byte("ID3\x04\x00\x00\x7F\x7F\x7F\x7F"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := &countingReader{stream: bytes.NewReader(data)}
+		format, err := DetectFormat(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reader.count > detectSize {
+			t.Fatalf("read %v bytes, want at most %v", reader.count, detectSize)
+		}
+
+		switch format {
+		case "":
+		case FormatMP4:
+			if len(data) < 8 || string(data[4:8]) != "ftyp" {
+				t.Fatalf("detected MP4 without an 'ftyp' box")
+			}
+		case FormatAAC:
+			if !bytes.Contains(data, []byte("ADIF")) && !bytes.Contains(data, []byte{0xFF}) {
+				t.Fatalf("detected AAC without a signature")
+			}
+		default:
+			t.Fatalf("unknown format %q", format)
+		}
+
+		// Only the start of the stream decides the format.
+		if more, _ := DetectFormat(bytes.NewReader(append(bytes.Clone(data), 0, 0, 0, 0))); len(data) >= detectSize && more != format {
+			t.Fatalf("format %q changed to %q by data past the first %v bytes", format, more, detectSize)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package mp3lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The ID3v1 genres, indexed by code: the original list of 80 and the
+// Winamp extensions which most software recognises.
+var id3v1Genres = [...]string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock",
+
+	// Winamp extensions.
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop",
+	"Latin", "Revival", "Celtic", "Bluegrass", "Avantgarde", "Gothic Rock",
+	"Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech",
+	"Chanson", "Opera", "Chamber Music", "Sonata", "Symphony", "Booty Bass",
+	"Primus", "Porn Groove", "Satire", "Slow Jam", "Club", "Tango", "Samba",
+	"Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House",
+	"Dance Hall", "Goa", "Drum & Bass", "Club-House", "Hardcore Techno",
+	"Terror", "Indie", "BritPop", "Afro-Punk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover",
+	"Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "Jpop", "Synthpop", "Abstract", "Art Rock",
+	"Baroque", "Bhangra", "Big Beat", "Breakbeat", "Chillout", "Downtempo",
+	"Dub", "EBM", "Eclectic", "Electro", "Electroclash", "Emo",
+	"Experimental", "Garage", "Global", "IDM", "Illbient", "Industro-Goth",
+	"Jam Band", "Krautrock", "Leftfield", "Lounge", "Math Rock",
+	"New Romantic", "Nu-Breakz", "Post-Punk", "Post-Rock", "Psytrance",
+	"Shoegaze", "Space Rock", "Trop Rock", "World Music", "Neoclassical",
+	"Audiobook", "Audio Theatre", "Neue Deutsche Welle", "Podcast",
+	"Indie Rock", "G-Funk", "Dubstep", "Garage Rock", "Psybient",
+}
+
+// GenreName returns the name of an ID3v1 genre code, e.g. "Audiobook" for
+// 183, or an empty string if the code isn't defined.
+func GenreName(code int) string {
+	if code < 0 || code >= len(id3v1Genres) {
+		return ""
+	}
+	return id3v1Genres[code]
+}
+
+// GenreCode returns the ID3v1 code of a genre name, ignoring case, or -1 if
+// the name isn't in the ID3v1 list. ID3v2 tags can use any name, but an
+// ID3v1 tag can only store a code.
+func GenreCode(name string) int {
+	for code, genre := range id3v1Genres {
+		if strings.EqualFold(genre, strings.TrimSpace(name)) {
+			return code
+		}
+	}
+	return -1
+}
+
+// ParseGenre converts a genre given as an ID3v1 code or as the content of
+// an ID3v2 TCON frame to a name. TCON frames can refer to ID3v1 codes as
+// "(17)" in version 2.3, optionally followed by a name, or as "17" in
+// version 2.4, and use "(RX)" for remix and "(CR)" for cover. Names are
+// returned as they are; unknown codes are returned as an empty string.
+func ParseGenre(text string) string {
+	text = strings.TrimSpace(text)
+
+	for strings.HasPrefix(text, "(") && !strings.HasPrefix(text, "((") {
+		end := strings.Index(text, ")")
+		if end < 0 {
+			break
+		}
+		ref, rest := text[1:end], strings.TrimSpace(text[end+1:])
+		if rest != "" {
+			text = rest
+			continue
+		}
+		switch ref {
+		case "RX":
+			return "Remix"
+		case "CR":
+			return "Cover"
+		}
+		text = ref
+		break
+	}
+
+	// A name starting with a literal '(' is escaped as '(('.
+	text = strings.TrimPrefix(text, "(")
+
+	if code, err := strconv.Atoi(text); err == nil {
+		return GenreName(code)
+	}
+	return text
+}
+
+// Genre returns the genre of an ID3v1 tag, or an empty string if it's
+// unset.
+func (tag *ID3v1Tag) Genre() string {
+	if len(tag.RawBytes) < 128 {
+		return ""
+	}
+	return GenreName(int(tag.RawBytes[127]))
+}
+
+// Genre returns the genre of an ID3v2 tag from its TCON frame, with any
+// ID3v1 code references converted to names.
+func (tag *ID3v2Tag) Genre() string {
+	return ParseGenre(tag.Text("TCON"))
+}
@@ -0,0 +1,231 @@
+package mp3lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3v2Frame is a single frame from an ID3v2 tag, e.g. a TIT2 title frame.
+// Frame IDs from ID3v2.2 tags are mapped to their ID3v2.3 equivalents where
+// one exists.
+type ID3v2Frame struct {
+	ID    string
+	Flags uint16
+	Data  []byte
+
+	// CHAP and CTOC frames built by this package keep the frames embedded
+	// in them, which follow the first headerLen bytes of Data, so they can
+	// be re-encoded for the tag they're written to.
+	headerLen int
+	subframes []*ID3v2Frame
+}
+
+// Equivalent ID3v2.3 IDs for the ID3v2.2 frames we're likely to need.
+var id3v22FrameIDs = map[string]string{
+	"TT2": "TIT2", "TP1": "TPE1", "TP2": "TPE2", "TAL": "TALB", "TRK": "TRCK",
+	"TPA": "TPOS", "TYE": "TYER", "TCO": "TCON", "TCM": "TCOM", "COM": "COMM",
+	"PIC": "APIC", "ULT": "USLT", "TXX": "TXXX",
+}
+
+// Version returns the tag's major version: 2, 3, or 4.
+func (tag *ID3v2Tag) Version() int {
+	if len(tag.RawBytes) < 10 {
+		return 0
+	}
+	return int(tag.RawBytes[3])
+}
+
+// Frames parses the tag's frames. Compressed and encrypted frames are
+// returned with their data as is.
+func (tag *ID3v2Tag) Frames() ([]*ID3v2Frame, error) {
+	if len(tag.RawBytes) < 10 {
+		return nil, errors.New("mp3lib: ID3v2 tag is too short")
+	}
+
+	version := tag.Version()
+	flags := tag.RawBytes[5]
+	body := tag.RawBytes[10:]
+
+	// Before version 2.4, unsynchronisation applies to the tag as a whole.
+	if flags&0x80 != 0 && version < 4 {
+		body = removeUnsync(body)
+	}
+
+	// Skip the extended header. Its size includes itself in version 2.4 but
+	// not in version 2.3.
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		if version == 4 {
+			body = body[min(syncsafe(body[:4]), len(body)):]
+		} else {
+			body = body[min(int(binary.BigEndian.Uint32(body[:4]))+4, len(body)):]
+		}
+	}
+
+	return parseFrames(body, version)
+}
+
+// parseFrames parses the frames in the body of a tag of the given version,
+// or the frames embedded in a CHAP or CTOC frame.
+func parseFrames(body []byte, version int) ([]*ID3v2Frame, error) {
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	var frames []*ID3v2Frame
+	for len(body) >= headerLen && body[0] != 0 {
+		frame := &ID3v2Frame{ID: string(body[:idLen])}
+
+		var size int
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+			if id, ok := id3v22FrameIDs[frame.ID]; ok {
+				frame.ID = id
+			}
+		case 4:
+			size = syncsafe(body[4:8])
+			frame.Flags = binary.BigEndian.Uint16(body[8:10])
+		default:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+			frame.Flags = binary.BigEndian.Uint16(body[8:10])
+		}
+
+		if size < 0 || headerLen+size > len(body) {
+			return frames, errors.New("mp3lib: ID3v2 frame extends past the end of the tag")
+		}
+		frame.Data = body[headerLen : headerLen+size]
+
+		// Version 2.2 PIC frames have a 3-character image format where APIC
+		// frames have a MIME type.
+		if version == 2 && frame.ID == "APIC" && len(frame.Data) >= 4 {
+			mimeType := "image/" + strings.ToLower(string(frame.Data[1:4]))
+			if mimeType == "image/jpg" {
+				mimeType = "image/jpeg"
+			}
+			data := append([]byte{frame.Data[0]}, mimeType...)
+			frame.Data = append(append(data, 0), frame.Data[4:]...)
+		}
+
+		// In version 2.4, unsynchronisation and data length indicators are
+		// per frame.
+		if version == 4 {
+			if frame.Flags&0x0001 != 0 && len(frame.Data) >= 4 {
+				frame.Data = frame.Data[4:]
+			}
+			if frame.Flags&0x0002 != 0 {
+				frame.Data = removeUnsync(frame.Data)
+			}
+		}
+
+		// Keep the frames embedded in chapters, so they can be re-encoded
+		// for a tag of another version.
+		if frame.ID == "CHAP" || frame.ID == "CTOC" {
+			if n := containerHeaderLen(frame); n > 0 {
+				if subframes, err := parseFrames(frame.Data[n:], version); err == nil && subframes != nil {
+					frame.headerLen, frame.subframes = n, subframes
+				}
+			}
+		}
+
+		frames = append(frames, frame)
+		body = body[headerLen+size:]
+	}
+
+	return frames, nil
+}
+
+// Frame returns the first frame with the given ID, or nil if the tag has no
+// such frame or can't be parsed.
+func (tag *ID3v2Tag) Frame(id string) *ID3v2Frame {
+	frames, _ := tag.Frames()
+	for _, frame := range frames {
+		if frame.ID == id {
+			return frame
+		}
+	}
+	return nil
+}
+
+// Text returns the value of the text frame with the given ID, e.g. "TIT2"
+// for the title, or an empty string if the tag has no such frame.
+func (tag *ID3v2Tag) Text(id string) string {
+	frame := tag.Frame(id)
+	if frame == nil || len(frame.Data) == 0 {
+		return ""
+	}
+	return DecodeID3Text(frame.Data[0], frame.Data[1:])
+}
+
+// DecodeID3Text decodes an ID3v2 text field in the given encoding: 0 for
+// ISO-8859-1, 1 for UTF-16 with a byte order mark, 2 for UTF-16BE, or 3 for
+// UTF-8. Where the field holds several null-separated values, as ID3v2.4
+// allows, they're joined with "/".
+func DecodeID3Text(encoding byte, data []byte) string {
+	var text string
+	switch encoding {
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+			bigEndian, data = false, data[2:]
+		} else if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+			bigEndian, data = true, data[2:]
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(data[2*i:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(data[2*i:])
+			}
+		}
+		text = string(utf16.Decode(units))
+	case 3:
+		text = string(data)
+	default:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+
+	text = strings.TrimRight(text, "\x00")
+	text = strings.ReplaceAll(text, "\uFEFF", "")
+	return strings.ReplaceAll(text, "\x00", "/")
+}
+
+// ReadID3v2Tag returns the ID3v2 tag at the start of a stream, or nil if the
+// stream doesn't start with one. Unlike NextID3v2Tag, it doesn't scan the
+// rest of the stream.
+func ReadID3v2Tag(stream io.Reader) (*ID3v2Tag, error) {
+	obj, err := NextObjectErr(stream)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	tag, _ := obj.(*ID3v2Tag)
+	return tag, nil
+}
+
+// syncsafe decodes a 4-byte syncsafe integer, which uses 7 bits per byte.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// removeUnsync reverses unsynchronisation, which inserts a zero byte after
+// every 0xFF so tag data can't be mistaken for a frame sync.
+func removeUnsync(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		out = append(out, data[i])
+		if data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
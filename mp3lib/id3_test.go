@@ -0,0 +1,34 @@
+package mp3lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Chapters read from a tag can be written to a tag of another version, which
+// encodes the sizes of their embedded frames differently. The sizes only
+// differ from 128 bytes up, so the chapter's title is longer than that.
+func TestChaptersSurviveReencoding(t *testing.T) {
+	title := "Einführung " + strings.Repeat("x", 200)
+	chapter := NewChapterFrame("chp1", time.Second, 2*time.Second, NewTextFrame("TIT2", title))
+	toc := NewTOCFrame("toc", []string{"chp1"}, NewTextFrame("TIT2", "Contents"))
+	tag := NewID3v2Tag([]*ID3v2Frame{NewTextFrame("TALB", "Book"), toc, chapter})
+
+	for _, encoding := range []byte{EncodingUTF8, EncodingUTF16, EncodingAuto} {
+		frames, err := tag.Frames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		copied, _ := NewID3v2TagEncoded(frames, encoding)
+
+		chapters := copied.Chapters()
+		if len(chapters) != 1 || chapters[0].Title != title || chapters[0].Start != time.Second {
+			t.Errorf("encoding %v: got chapters %+v", encoding, chapters)
+		}
+		if frame := copied.Frame("CTOC"); frame == nil || containerHeaderLen(frame) == 0 {
+			t.Errorf("encoding %v: table of contents lost", encoding)
+		}
+		tag = copied
+	}
+}
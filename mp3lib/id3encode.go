@@ -0,0 +1,206 @@
+package mp3lib
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Text encodings of ID3v2 frames. EncodingAuto isn't a real encoding: it
+// selects ISO-8859-1 for text which fits and UTF-16 otherwise.
+const (
+	EncodingLatin1  byte = 0
+	EncodingUTF16   byte = 1
+	EncodingUTF16BE byte = 2
+	EncodingUTF8    byte = 3
+	EncodingAuto    byte = 0xFF
+)
+
+// NewID3v2Tag returns an ID3v2.3 tag containing the given frames. Text is
+// written as ISO-8859-1 where possible, otherwise as UTF-16. Frames are
+// written without flags, so compressed or encrypted frames shouldn't be
+// included.
+func NewID3v2Tag(frames []*ID3v2Frame) *ID3v2Tag {
+	tag, _ := NewID3v2TagEncoded(frames, EncodingAuto)
+	return tag
+}
+
+// NewID3v2TagEncoded returns an ID3v2 tag containing the given frames with
+// their text in the given encoding. The tag is ID3v2.4 for UTF-8, which
+// earlier versions don't support, otherwise ID3v2.3. Characters which can't
+// be written in ISO-8859-1 are replaced with '?', in which case lossy is
+// true.
+func NewID3v2TagEncoded(frames []*ID3v2Frame, encoding byte) (tag *ID3v2Tag, lossy bool) {
+	version := 3
+	if encoding == EncodingUTF8 {
+		version = 4
+	}
+
+	var body []byte
+	for _, frame := range frames {
+		encoded, frameLossy := frame.encode(version, encoding)
+		body = append(body, encoded...)
+		lossy = lossy || frameLossy
+	}
+
+	raw := make([]byte, 10, 10+len(body))
+	copy(raw, "ID3")
+	raw[3] = byte(version)
+	putSyncsafe(raw[6:10], len(body))
+	return &ID3v2Tag{RawBytes: append(raw, body...)}, lossy
+}
+
+// Padded returns a copy of the tag followed by enough padding to make it
+// size bytes long, e.g. to fill a space reserved for it in a file. Returns
+// the tag unchanged if it's already that long or longer, or if it has a
+// footer, which padding mustn't precede.
+func (tag *ID3v2Tag) Padded(size int) *ID3v2Tag {
+	if len(tag.RawBytes) >= size || len(tag.RawBytes) < 10 || tag.RawBytes[5]&0x10 != 0 {
+		return tag
+	}
+	raw := make([]byte, size)
+	copy(raw, tag.RawBytes)
+	putSyncsafe(raw[6:10], size-10)
+	return &ID3v2Tag{RawBytes: raw}
+}
+
+// Returns the frame encoded for a tag of the given major version, with its
+// text transcoded to [encoding]: its ID, size, and flags, followed by its
+// data.
+func (frame *ID3v2Frame) encode(version int, encoding byte) ([]byte, bool) {
+	data, lossy := frame.transcode(version, encoding)
+
+	// Embedded frames are encoded in the same way as the tag's own frames.
+	if frame.subframes != nil {
+		data = append([]byte(nil), frame.Data[:frame.headerLen]...)
+		for _, subframe := range frame.subframes {
+			encoded, subLossy := subframe.encode(version, encoding)
+			data = append(data, encoded...)
+			lossy = lossy || subLossy
+		}
+	}
+
+	raw := make([]byte, 10, 10+len(data))
+	copy(raw, frame.ID)
+	if version == 4 {
+		putSyncsafe(raw[4:8], len(data))
+	} else {
+		binary.BigEndian.PutUint32(raw[4:8], uint32(len(data)))
+	}
+	return append(raw, data...), lossy
+}
+
+// Returns the frame's data with its text transcoded to [encoding] for a tag
+// of the given major version. With EncodingAuto, text which is already
+// ISO-8859-1 or UTF-16 is left alone, as every version supports those.
+// Frames without text, or with a layout we don't know, are returned as is.
+func (frame *ID3v2Frame) transcode(version int, encoding byte) ([]byte, bool) {
+	layout, ok := parseTextLayout(frame)
+	if !ok {
+		return frame.Data, false
+	}
+
+	current := frame.Data[0]
+	if encoding == EncodingAuto {
+		if current == EncodingLatin1 || current == EncodingUTF16 {
+			return frame.Data, false
+		}
+		encoding = id3Encoding(layout.description + layout.text)
+	}
+	if encoding == EncodingUTF8 && version < 4 {
+		encoding = EncodingUTF16
+	}
+
+	lossy := encoding == EncodingLatin1 &&
+		(!fitsLatin1(layout.description) || (layout.hasText && !fitsLatin1(layout.text)))
+
+	data := append([]byte{encoding}, layout.prefix...)
+	if layout.hasDescription {
+		data = append(data, encodeID3Text(encoding, layout.description)...)
+		data = append(data, id3Terminator(encoding)...)
+	}
+	if layout.hasText {
+		data = append(data, encodeID3Text(encoding, layout.text)...)
+	} else {
+		data = append(data, layout.rest...)
+	}
+	return data, lossy
+}
+
+// The parts of a frame with encoded text: the bytes between the encoding
+// byte and the first string, such as the language of a comment, an optional
+// terminated description, and then either a final text value or raw data,
+// such as an image or a Latin-1 URL.
+type textLayout struct {
+	prefix         []byte
+	hasDescription bool
+	description    string
+	hasText        bool
+	text           string
+	rest           []byte
+}
+
+// Splits a frame with encoded text into its parts. Returns false if the
+// frame has no encoded text or is malformed.
+func parseTextLayout(frame *ID3v2Frame) (textLayout, bool) {
+	var layout textLayout
+	if len(frame.ID) != 4 || len(frame.Data) < 1 || frame.Data[0] > EncodingUTF8 || frame.subframes != nil {
+		return layout, false
+	}
+	encoding, body := frame.Data[0], frame.Data[1:]
+
+	switch {
+	case frame.ID == "COMM" || frame.ID == "USLT":
+		if len(body) < 3 {
+			return layout, false
+		}
+		layout.prefix, body = body[:3], body[3:]
+	case frame.ID == "APIC":
+		end := bytes.IndexByte(body, 0)
+		if end < 0 || end+2 > len(body) {
+			return layout, false
+		}
+		layout.prefix, body = body[:end+2], body[end+2:]
+	case frame.ID == "TXXX" || frame.ID == "WXXX":
+	case frame.ID[0] == 'T':
+		layout.hasText, layout.text = true, DecodeID3Text(encoding, body)
+		return layout, true
+	default:
+		return layout, false
+	}
+
+	description, rest, found := splitID3String(encoding, body)
+	if !found {
+		return layout, false
+	}
+	layout.hasDescription, layout.description = true, description
+
+	if frame.ID == "APIC" || frame.ID == "WXXX" {
+		layout.rest = rest
+	} else {
+		layout.hasText, layout.text = true, DecodeID3Text(encoding, rest)
+	}
+	return layout, true
+}
+
+// Splits a terminated string in the given encoding from the start of
+// [data]. Returns false if there's no terminator.
+func splitID3String(encoding byte, data []byte) (string, []byte, bool) {
+	if encoding == EncodingUTF16 || encoding == EncodingUTF16BE {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return DecodeID3Text(encoding, data[:i]), data[i+2:], true
+			}
+		}
+		return "", nil, false
+	}
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return "", nil, false
+	}
+	return DecodeID3Text(encoding, data[:end]), data[end+1:], true
+}
+
+// Returns true if every character of the text fits in ISO-8859-1.
+func fitsLatin1(text string) bool {
+	return id3Encoding(text) == EncodingLatin1
+}
@@ -0,0 +1,385 @@
+package mp3lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Picture type of a front cover image in an APIC frame.
+const PictureFrontCover = 3
+
+// NewTextFrame returns a text frame, e.g. a TIT2 title frame. The text is
+// encoded as ISO-8859-1 if possible, otherwise as UTF-16.
+func NewTextFrame(id, text string) *ID3v2Frame {
+	encoding := id3Encoding(text)
+	data := append([]byte{encoding}, encodeID3Text(encoding, text)...)
+	return &ID3v2Frame{ID: id, Data: data}
+}
+
+// NewUserTextFrame returns a TXXX user-defined text frame, e.g. a
+// ReplayGain value, identified by its description. The text is encoded as
+// for NewTextFrame.
+func NewUserTextFrame(description, text string) *ID3v2Frame {
+	encoding := id3Encoding(description + text)
+	data := append([]byte{encoding}, encodeID3Text(encoding, description)...)
+	data = append(data, id3Terminator(encoding)...)
+	data = append(data, encodeID3Text(encoding, text)...)
+	return &ID3v2Frame{ID: "TXXX", Data: data}
+}
+
+// NewURLFrame returns a WXXX user-defined link frame with an optional
+// description.
+func NewURLFrame(description, url string) *ID3v2Frame {
+	encoding := id3Encoding(description)
+	data := append([]byte{encoding}, encodeID3Text(encoding, description)...)
+	data = append(data, id3Terminator(encoding)...)
+	data = append(data, encodeID3Text(0, url)...)
+	return &ID3v2Frame{ID: "WXXX", Data: data}
+}
+
+// NewPictureFrame returns an APIC frame containing an image, e.g. a JPEG
+// with the MIME type "image/jpeg".
+func NewPictureFrame(mimeType string, pictureType byte, description string, image []byte) *ID3v2Frame {
+	encoding := id3Encoding(description)
+	data := append([]byte{encoding}, mimeType...)
+	data = append(data, 0, pictureType)
+	data = append(data, encodeID3Text(encoding, description)...)
+	data = append(data, id3Terminator(encoding)...)
+	return &ID3v2Frame{ID: "APIC", Data: append(data, image...)}
+}
+
+// NewChapterFrame returns a CHAP frame for a chapter running from start to
+// end, with the given frames, e.g. its title, embedded in it. The chapter's
+// byte offsets are left unset, so players use its times.
+func NewChapterFrame(elementID string, start, end time.Duration, subframes ...*ID3v2Frame) *ID3v2Frame {
+	data := append([]byte(elementID), 0)
+	data = binary.BigEndian.AppendUint32(data, durationMillis(start))
+	data = binary.BigEndian.AppendUint32(data, durationMillis(end))
+	data = binary.BigEndian.AppendUint32(data, math.MaxUint32)
+	data = binary.BigEndian.AppendUint32(data, math.MaxUint32)
+	return newContainerFrame("CHAP", data, subframes)
+}
+
+// ID3v2Chapter is the content of a CHAP chapter frame.
+type ID3v2Chapter struct {
+	ElementID string
+	Start     time.Duration
+	End       time.Duration
+	Title     string // From the chapter's embedded TIT2 frame, if any.
+}
+
+// Chapters returns the tag's CHAP frames in order of their start times.
+// Chapters too short to hold their times are skipped.
+func (tag *ID3v2Tag) Chapters() []ID3v2Chapter {
+	frames, _ := tag.Frames()
+	var chapters []ID3v2Chapter
+	for _, frame := range frames {
+		if frame.ID != "CHAP" {
+			continue
+		}
+		end := bytes.IndexByte(frame.Data, 0)
+		if end < 0 || len(frame.Data) < end+17 {
+			continue
+		}
+		times := frame.Data[end+1:]
+		chapter := ID3v2Chapter{
+			ElementID: string(frame.Data[:end]),
+			Start:     time.Duration(binary.BigEndian.Uint32(times[0:4])) * time.Millisecond,
+			End:       time.Duration(binary.BigEndian.Uint32(times[4:8])) * time.Millisecond,
+		}
+		subframes, _ := parseFrames(times[16:], tag.Version())
+		for _, subframe := range subframes {
+			if subframe.ID == "TIT2" && len(subframe.Data) > 0 {
+				chapter.Title = DecodeID3Text(subframe.Data[0], subframe.Data[1:])
+			}
+		}
+		chapters = append(chapters, chapter)
+	}
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Start < chapters[j].Start })
+	return chapters
+}
+
+// NewTOCFrame returns a top-level CTOC frame listing the element IDs of
+// chapters in order. A CTOC frame can list at most 255 entries; any more are
+// left out.
+func NewTOCFrame(elementID string, children []string, subframes ...*ID3v2Frame) *ID3v2Frame {
+	children = children[:min(len(children), 255)]
+	data := append([]byte(elementID), 0)
+	data = append(data, 0x03, byte(len(children)))
+	for _, child := range children {
+		data = append(data, child...)
+		data = append(data, 0)
+	}
+	return newContainerFrame("CTOC", data, subframes)
+}
+
+// Returns the length of the part of a CHAP or CTOC frame's data which
+// precedes its embedded frames, or 0 if the data is too short to hold it.
+func containerHeaderLen(frame *ID3v2Frame) int {
+	end := bytes.IndexByte(frame.Data, 0)
+	if end < 0 {
+		return 0
+	}
+	n := end + 1
+
+	// A chapter's element ID is followed by its start and end times and
+	// offsets.
+	if frame.ID == "CHAP" {
+		if n+16 > len(frame.Data) {
+			return 0
+		}
+		return n + 16
+	}
+
+	// A table of contents' element ID is followed by its flags, its entry
+	// count, and the element IDs of its entries.
+	if n+2 > len(frame.Data) {
+		return 0
+	}
+	count := int(frame.Data[n+1])
+	n += 2
+	for range count {
+		end := bytes.IndexByte(frame.Data[n:], 0)
+		if end < 0 {
+			return 0
+		}
+		n += end + 1
+	}
+	return n
+}
+
+// Returns a frame with [subframes] embedded after [header], encoded for an
+// ID3v2.3 tag.
+func newContainerFrame(id string, header []byte, subframes []*ID3v2Frame) *ID3v2Frame {
+	frame := &ID3v2Frame{ID: id, headerLen: len(header), subframes: subframes}
+	frame.Data = header
+	for _, subframe := range subframes {
+		encoded, _ := subframe.encode(3, EncodingAuto)
+		frame.Data = append(frame.Data, encoded...)
+	}
+	return frame
+}
+
+// Picture returns the tag's front cover image, or its first image if none is
+// marked as the front cover, or nil if it has no images.
+func (tag *ID3v2Tag) Picture() *ID3v2Frame {
+	frames, _ := tag.Frames()
+	var first *ID3v2Frame
+	for _, frame := range frames {
+		if frame.ID != "APIC" {
+			continue
+		}
+		if first == nil {
+			first = frame
+		}
+		if pictureType(frame) == PictureFrontCover {
+			return frame
+		}
+	}
+	return first
+}
+
+// Returns the picture type of an APIC frame, which follows the text encoding
+// byte and the null-terminated MIME type.
+func pictureType(frame *ID3v2Frame) int {
+	for i := 1; i < len(frame.Data)-1; i++ {
+		if frame.Data[i] == 0 {
+			return int(frame.Data[i+1])
+		}
+	}
+	return -1
+}
+
+// Returns the encoding for an ID3v2.3 text field: ISO-8859-1 if every
+// character fits, otherwise UTF-16.
+func id3Encoding(text string) byte {
+	for _, r := range text {
+		if r > 0xFF {
+			return EncodingUTF16
+		}
+	}
+	return EncodingLatin1
+}
+
+// Encodes text in the given ID3v2 encoding, without a terminator. In
+// ISO-8859-1, characters which don't fit are replaced with '?'.
+func encodeID3Text(encoding byte, text string) []byte {
+	switch encoding {
+	case EncodingUTF16:
+		data := []byte{0xFF, 0xFE}
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = binary.LittleEndian.AppendUint16(data, unit)
+		}
+		return data
+	case EncodingUTF16BE:
+		var data []byte
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = binary.BigEndian.AppendUint16(data, unit)
+		}
+		return data
+	case EncodingUTF8:
+		return []byte(text)
+	default:
+		data := make([]byte, 0, len(text))
+		for _, r := range text {
+			if r > 0xFF {
+				r = '?'
+			}
+			data = append(data, byte(r))
+		}
+		return data
+	}
+}
+
+// Returns the terminator for a string in the given ID3v2 encoding.
+func id3Terminator(encoding byte) []byte {
+	if encoding == EncodingUTF16 || encoding == EncodingUTF16BE {
+		return []byte{0, 0}
+	}
+	return []byte{0}
+}
+
+// Converts a duration to whole milliseconds, clamped to fit in 32 bits.
+func durationMillis(d time.Duration) uint32 {
+	return uint32(min(max(d.Milliseconds(), 0), math.MaxUint32))
+}
+
+// putSyncsafe encodes a 4-byte syncsafe integer, which uses 7 bits per byte.
+func putSyncsafe(b []byte, n int) {
+	b[0] = byte(n>>21) & 0x7F
+	b[1] = byte(n>>14) & 0x7F
+	b[2] = byte(n>>7) & 0x7F
+	b[3] = byte(n) & 0x7F
+}
+
+// ID3v2Comment is the content of a COMM comment frame.
+type ID3v2Comment struct {
+	Language    string // ISO 639-2 language code, e.g. "eng".
+	Description string // Short description of the comment, often empty.
+	Text        string
+}
+
+// NewCommentFrame returns a COMM comment frame. The language is a 3-letter
+// ISO 639-2 code, e.g. "eng"; it's padded or cut to 3 characters.
+func NewCommentFrame(language, description, text string) *ID3v2Frame {
+	return newLanguageFrame("COMM", language, description, text)
+}
+
+// NewLyricsFrame returns a USLT unsynchronised lyrics frame, which can hold
+// any long text. The language is as for NewCommentFrame.
+func NewLyricsFrame(language, description, text string) *ID3v2Frame {
+	return newLanguageFrame("USLT", language, description, text)
+}
+
+// Returns a frame with the layout of COMM and USLT frames: the encoding, a
+// language code, a terminated description, and the text.
+func newLanguageFrame(id, language, description, text string) *ID3v2Frame {
+	encoding := id3Encoding(description + text)
+	data := append([]byte{encoding}, (strings.ToLower(language) + "xxx")[:3]...)
+	data = append(data, encodeID3Text(encoding, description)...)
+	data = append(data, id3Terminator(encoding)...)
+	data = append(data, encodeID3Text(encoding, text)...)
+	return &ID3v2Frame{ID: id, Data: data}
+}
+
+// Comments returns the content of the tag's COMM frames.
+func (tag *ID3v2Tag) Comments() []ID3v2Comment {
+	frames, _ := tag.Frames()
+	var comments []ID3v2Comment
+	for _, frame := range frames {
+		if frame.ID != "COMM" {
+			continue
+		}
+		if content, ok := frame.TextContent(); ok {
+			comments = append(comments, ID3v2Comment(content))
+		}
+	}
+	return comments
+}
+
+// ID3v2Text is the text content of a text frame, e.g. TIT2, a user-defined
+// text frame (TXXX), a comment or lyrics frame (COMM, USLT), or a link frame,
+// e.g. WXXX.
+type ID3v2Text struct {
+	Language    string // COMM and USLT frames only.
+	Description string // COMM, USLT, TXXX, and WXXX frames only.
+	Text        string // The text, or the URL of a link frame.
+}
+
+// TextContent returns the text content of a text, comment, lyrics, or link
+// frame. Returns false for other frames or if the frame can't be parsed.
+func (frame *ID3v2Frame) TextContent() (ID3v2Text, bool) {
+	if len(frame.ID) == 4 && frame.ID[0] == 'W' && frame.ID != "WXXX" {
+		return ID3v2Text{Text: DecodeID3Text(EncodingLatin1, frame.Data)}, true
+	}
+	if frame.ID == "APIC" {
+		return ID3v2Text{}, false
+	}
+
+	layout, ok := parseTextLayout(frame)
+	if !ok {
+		return ID3v2Text{}, false
+	}
+	content := ID3v2Text{Description: layout.description, Text: layout.text}
+	if frame.ID == "COMM" || frame.ID == "USLT" {
+		content.Language = strings.TrimRight(string(layout.prefix), "\x00")
+	}
+	if frame.ID == "WXXX" {
+		content.Text = DecodeID3Text(EncodingLatin1, layout.rest)
+	}
+	return content, true
+}
+
+// ID3v2Picture is the content of an APIC picture frame.
+type ID3v2Picture struct {
+	MIMEType    string // E.g. "image/jpeg".
+	Type        byte   // E.g. PictureFrontCover.
+	Description string
+	Data        []byte
+}
+
+// Pictures returns the content of the tag's APIC frames.
+func (tag *ID3v2Tag) Pictures() []ID3v2Picture {
+	frames, _ := tag.Frames()
+	var pictures []ID3v2Picture
+	for _, frame := range frames {
+		if frame.ID != "APIC" {
+			continue
+		}
+		layout, ok := parseTextLayout(frame)
+		if !ok {
+			continue
+		}
+		prefix := layout.prefix
+		pictures = append(pictures, ID3v2Picture{
+			MIMEType:    string(prefix[:len(prefix)-2]),
+			Type:        prefix[len(prefix)-1],
+			Description: layout.description,
+			Data:        layout.rest,
+		})
+	}
+	return pictures
+}
+
+// Key identifies the frame among the frames of a tag: a tag can hold only
+// one frame with each key. For most frames this is the ID, but a tag can
+// hold several comments, lyrics, user-defined text and link frames, and
+// images, so long as their languages and descriptions differ.
+func (frame *ID3v2Frame) Key() string {
+	switch frame.ID {
+	case "COMM", "USLT", "TXXX", "WXXX", "APIC":
+		if layout, ok := parseTextLayout(frame); ok {
+			language := ""
+			if frame.ID == "COMM" || frame.ID == "USLT" {
+				language = string(layout.prefix)
+			}
+			return frame.ID + "\x00" + language + "\x00" + layout.description
+		}
+	}
+	return frame.ID
+}
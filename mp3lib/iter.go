@@ -0,0 +1,37 @@
+package mp3lib
+
+import (
+	"io"
+	"iter"
+)
+
+// Frames returns an iterator over the MP3 frames in the stream, skipping ID3
+// tags and garbage data:
+//
+//	for frame, err := range mp3lib.Frames(file) {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    ...
+//	}
+//
+// Iteration stops when the stream is exhausted. If a read fails, or the
+// stream ends partway through a frame, the error is yielded with a nil frame
+// as the final value.
+func Frames(r io.Reader) iter.Seq2[*MP3Frame, error] {
+	return func(yield func(*MP3Frame, error) bool) {
+		reader := NewFrameReader(r)
+		for {
+			frame, err := reader.ReadFrame()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(frame, nil) {
+				return
+			}
+		}
+	}
+}
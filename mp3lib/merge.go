@@ -0,0 +1,161 @@
+package mp3lib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// MergeOptions holds the optional settings for Merge.
+type MergeOptions struct {
+	// Tag, if not nil, is written at the start of the output.
+	Tag *ID3v2Tag
+
+	// Progress, if not nil, is called as the merge goes on. It's called at
+	// the end of each input file, including files with no frames, and after
+	// each megabyte of frames within a file, on the goroutine running Merge.
+	Progress func(MergeProgress)
+}
+
+// MergeProgress describes how far a merge has got.
+type MergeProgress struct {
+	FileIndex  int    // Index in the inputs of the file being read.
+	BytesDone  uint64 // Bytes of audio frames written so far.
+	FramesDone uint64 // Number of audio frames written so far.
+}
+
+// Number of bytes of frames written between calls to a merge's progress
+// callback.
+const mergeProgressInterval = 1 << 20
+
+// Merge writes the MP3 frames of the files at inputs to out, in order. Tags,
+// garbage data, and the inputs' own VBR headers are left out. Layer III
+// output starts with an Xing header if it has more than one bitrate, or an
+// Info header otherwise, recording its frame and byte counts. The header's
+// space is reserved before the frames and filled in at the end, so out must
+// be seekable; the output is written from its position when Merge is
+// called. The inputs' audio parameters aren't checked, so they should
+// match.
+//
+// Merge stops and returns the context's error if ctx is cancelled, leaving
+// out incomplete. A nil opts is the same as the zero MergeOptions.
+func Merge(ctx context.Context, inputs []string, out io.WriteSeeker, opts *MergeOptions) error {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+
+	start, err := out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+
+	var tagLen int
+	if opts.Tag != nil {
+		tagLen = len(opts.Tag.RawBytes)
+		if _, err := writer.Write(opts.Tag.RawBytes); err != nil {
+			return err
+		}
+	}
+
+	m := &merger{writer: writer, progress: opts.Progress}
+	for index, input := range inputs {
+		if err := m.mergeFile(ctx, index, input); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if m.headerLen == 0 {
+		return nil
+	}
+
+	header := NewVBRHeaderFrame(m.template, m.stats.Frames, m.stats.Bytes, &m.toc, !m.stats.IsVBR(), m.headerLen)
+	if _, err := out.Seek(start+int64(tagLen), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := out.Write(header.RawBytes); err != nil {
+		return err
+	}
+	_, err = out.Seek(0, io.SeekEnd)
+	return err
+}
+
+// merger holds the state of a Merge call between input files.
+type merger struct {
+	writer    *bufio.Writer
+	progress  func(MergeProgress)
+	template  *MP3Frame // Header fields of the first frame, or nil.
+	headerLen int       // Length of the space reserved for a VBR header.
+	stats     Stats
+	toc       XingTOC
+}
+
+// mergeFile appends the frames of the file at path, the input at index, to
+// the output. A truncated frame at the end of the file is dropped.
+func (m *merger) mergeFile(ctx context.Context, index int, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := NewFrameReader(NewContextReader(ctx, file))
+	frame := &MP3Frame{}
+	var read int
+	reported := m.stats.Bytes
+	for {
+		err := reader.ReadInto(frame)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		read++
+		if read <= VBRHeaderSearchFrames && IsVBRHeaderFrame(frame) {
+			continue
+		}
+
+		if m.template == nil {
+			m.template = &MP3Frame{}
+			*m.template = *frame
+			m.template.RawBytes = nil
+			if frame.MPEGLayer == MPEGLayerIII {
+				m.headerLen = len(NewVBRHeaderFrame(m.template, 0, 0, &XingTOC{}, false, 0).RawBytes)
+				if _, err := m.writer.Write(make([]byte, m.headerLen)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := m.writer.Write(frame.RawBytes); err != nil {
+			return err
+		}
+		m.stats.Add(frame)
+		m.toc.Add(frame)
+
+		if m.progress != nil && m.stats.Bytes-reported >= mergeProgressInterval {
+			reported = m.stats.Bytes
+			m.report(index)
+		}
+	}
+
+	// A cancelled read can look like the end of the file to the reader.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if m.progress != nil {
+		m.report(index)
+	}
+	return nil
+}
+
+// report passes the merge's progress to the callback.
+func (m *merger) report(index int) {
+	m.progress(MergeProgress{FileIndex: index, BytesDone: m.stats.Bytes, FramesDone: m.stats.Frames})
+}
@@ -0,0 +1,183 @@
+package mp3lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Writes a file of the given frames, each repeated count times, to a
+// temporary directory and returns its path.
+func writeTestInput(t *testing.T, name string, count int, frames ...[]byte) string {
+	t.Helper()
+	var data []byte
+	for _, frame := range frames {
+		data = append(data, bytes.Repeat(frame, count)...)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMerge(t *testing.T) {
+	slow := testFrameWithBitRate(t, 5)
+	fast := testFrameWithBitRate(t, 9)
+	inputs := []string{
+		writeTestInput(t, "one.mp3", 5000, fast),
+		writeTestInput(t, "two.mp3", 5000, slow),
+	}
+	tag := NewID3v2Tag([]*ID3v2Frame{NewTextFrame("TIT2", "Merged")})
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var calls []MergeProgress
+	progress := func(p MergeProgress) {
+		if len(calls) > 0 {
+			last := calls[len(calls)-1]
+			if p.FileIndex < last.FileIndex || p.BytesDone < last.BytesDone || p.FramesDone < last.FramesDone {
+				t.Errorf("progress %+v after %+v, want rising counts", p, last)
+			}
+		}
+		calls = append(calls, p)
+	}
+	if err := Merge(context.Background(), inputs, out, &MergeOptions{Tag: tag, Progress: progress}); err != nil {
+		t.Fatal(err)
+	}
+	want := MergeProgress{FileIndex: 1, BytesDone: uint64(5000 * (len(slow) + len(fast))), FramesDone: 10000}
+	if len(calls) < 3 || calls[len(calls)-1] != want {
+		t.Errorf("progress called %v times ending with %+v, want at least 3 ending with %+v", len(calls), calls[len(calls)-1], want)
+	}
+
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, tag.RawBytes) {
+		t.Fatal("output doesn't start with the tag")
+	}
+	reader := bytes.NewReader(data[len(tag.RawBytes):])
+	header := NextFrame(reader)
+	xing, err := ParseXingHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xing.ID != "Xing" || xing.Frames != 10000 || int(xing.Bytes) != len(data)-len(tag.RawBytes) {
+		t.Errorf("got %v header with %v frames and %v bytes, want Xing with 10000 and %v",
+			xing.ID, xing.Frames, xing.Bytes, len(data)-len(tag.RawBytes))
+	}
+
+	var frames int
+	for NextFrame(reader) != nil {
+		frames++
+	}
+	if frames != 10000 {
+		t.Errorf("read %v frames after the header, want 10000", frames)
+	}
+}
+
+func TestMergeCBRHasInfoHeader(t *testing.T) {
+	input := writeTestInput(t, "cbr.mp3", 10, testFrame(t))
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := Merge(context.Background(), []string{input, input}, file, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	xing, err := ParseXingHeader(NextFrame(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xing.ID != "Info" || xing.Frames != 20 {
+		t.Errorf("got %v header with %v frames, want Info with 20", xing.ID, xing.Frames)
+	}
+}
+
+func TestMergeCancelled(t *testing.T) {
+	input := writeTestInput(t, "long.mp3", 20000, testFrame(t))
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Merge(ctx, []string{input}, file, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Merge with a cancelled context returned %v, want context.Canceled", err)
+	}
+
+	// Cancelling partway through stops the merge before the end of the first file.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	progress := func(MergeProgress) {
+		calls++
+		cancel()
+	}
+	err = Merge(ctx, []string{input, input}, file, &MergeOptions{Progress: progress})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Merge cancelled partway returned %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("progress called %v times after cancelling, want once", calls)
+	}
+}
+
+func TestMergeTruncated(t *testing.T) {
+	frame := testFrame(t)
+	truncated := writeTestInput(t, "truncated.mp3", 1, bytes.Repeat(frame, 10), frame[:len(frame)/2])
+	complete := writeTestInput(t, "complete.mp3", 5, frame)
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Small files still report their progress.
+	var calls []MergeProgress
+	progress := func(p MergeProgress) {
+		calls = append(calls, p)
+	}
+	if err := Merge(context.Background(), []string{truncated, complete}, file, &MergeOptions{Progress: progress}); err != nil {
+		t.Fatalf("Merge with a truncated input returned %v, want nil", err)
+	}
+	want := []MergeProgress{
+		{FileIndex: 0, BytesDone: uint64(10 * len(frame)), FramesDone: 10},
+		{FileIndex: 1, BytesDone: uint64(15 * len(frame)), FramesDone: 15},
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("progress calls %+v, want %+v", calls, want)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(data)
+	xing, err := ParseXingHeader(NextFrame(reader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xing.Frames != 15 {
+		t.Errorf("header records %v frames, want 15", xing.Frames)
+	}
+	if reader.Len() != 15*len(frame) {
+		t.Errorf("got %v bytes of frames, want %v without the partial frame", reader.Len(), 15*len(frame))
+	}
+}
@@ -0,0 +1,624 @@
+/*
+Package mp3lib is a simple library for parsing MP3 files.
+
+It began as a copy of github.com/dmulholl/mp3lib v1.0.0. See readme.md.
+*/
+package mp3lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Library version.
+const Version = "1.0.0"
+
+// Flag controlling the display of debugging information.
+var DebugMode = false
+
+// MaxTagSize is the largest ID3v2 or APEv2 tag, in bytes, which will be read
+// into memory. A tag's size comes from its header, so without a limit a
+// corrupt header could make the parser allocate hundreds of megabytes. Larger
+// tags produce an ErrTagTooLarge error. Zero means no limit.
+var MaxTagSize = 64 << 20
+
+// checkTagSize returns an error if a tag of [size] bytes exceeds MaxTagSize.
+func checkTagSize(size int64) error {
+	if MaxTagSize > 0 && size > int64(MaxTagSize) {
+		return fmt.Errorf("%w (%d bytes)", ErrTagTooLarge, size)
+	}
+	return nil
+}
+
+// MPEG version enum.
+const (
+	MPEGVersion2_5 = iota
+	MPEGVersionReserved
+	MPEGVersion2
+	MPEGVersion1
+)
+
+// MPEG layer enum.
+const (
+	MPEGLayerReserved = iota
+	MPEGLayerIII
+	MPEGLayerII
+	MPEGLayerI
+)
+
+// Channel mode enum.
+const (
+	Stereo = iota
+	JointStereo
+	DualChannel
+	Mono
+)
+
+// Bit rates.
+var v1l1_br = []int{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448}
+var v1l2_br = []int{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384}
+var v1l3_br = []int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+var v2l1_br = []int{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256}
+var v2l2_br = []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+var v2l3_br = []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+
+// Sampling rates.
+var v1_sr = []int{44100, 48000, 32000}
+var v2_sr = []int{22050, 24000, 16000}
+var v25_sr = []int{11025, 12000, 8000}
+
+// MP3Frame represents an individual frame parsed from an MP3 stream.
+type MP3Frame struct {
+	MPEGVersion   byte
+	MPEGLayer     byte
+	CrcProtection bool
+	BitRate       int
+	SamplingRate  int
+	PaddingBit    bool
+	PrivateBit    bool
+	ChannelMode   byte
+	ModeExtension byte
+	CopyrightBit  bool
+	OriginalBit   bool
+	Emphasis      byte
+	SampleCount   int
+	FrameLength   int
+	RawBytes      []byte
+}
+
+// ID3v1Tag represents an ID3v1 metadata tag.
+type ID3v1Tag struct {
+	RawBytes []byte
+}
+
+// ID3v2Tag represents an ID3v2 metadata tag.
+type ID3v2Tag struct {
+	RawBytes []byte
+}
+
+// NextFrame loads the next MP3 frame from the input stream. Skips over ID3
+// tags and unrecognised/garbage data in the stream. Returns nil when the
+// stream has been exhausted or if a read error occurs. Use NextFrameErr to
+// distinguish between these cases.
+func NextFrame(stream io.Reader) *MP3Frame {
+	frame, _ := NextFrameErr(stream)
+	return frame
+}
+
+// NextFrameErr loads the next MP3 frame from the input stream. Skips over ID3
+// tags and unrecognised/garbage data in the stream. Returns io.EOF when the
+// stream has been exhausted, ErrTruncatedFrame if the stream ends partway
+// through a frame, io.ErrUnexpectedEOF if it ends partway through a tag, or
+// the underlying error if a read fails.
+func NextFrameErr(stream io.Reader) (*MP3Frame, error) {
+	for {
+		obj, err := NextObjectErr(stream)
+		if err != nil {
+			return nil, err
+		}
+		switch obj := obj.(type) {
+		case *MP3Frame:
+			return obj, nil
+		case *ID3v1Tag:
+			debug("NextFrame: skipping ID3v1 tag")
+		case *ID3v2Tag:
+			debug("NextFrame: skipping ID3v2 tag")
+		case *APEv2Tag:
+			debug("NextFrame: skipping APEv2 tag")
+		case *RIFFHeader:
+			debug("NextFrame: skipping RIFF header")
+		}
+	}
+}
+
+// NextID3v2Tag loads the next ID3v2 tag from the input stream, skipping all
+// other data. Returns nil when the stream has been exhausted or if a read
+// error occurs. Use NextID3v2TagErr to distinguish between these cases.
+func NextID3v2Tag(stream io.Reader) *ID3v2Tag {
+	tag, _ := NextID3v2TagErr(stream)
+	return tag
+}
+
+// NextID3v2TagErr loads the next ID3v2 tag from the input stream, skipping
+// all other data. Returns errors in the same way as NextFrameErr.
+func NextID3v2TagErr(stream io.Reader) (*ID3v2Tag, error) {
+	for {
+		obj, err := NextObjectErr(stream)
+		if err != nil {
+			return nil, err
+		}
+		switch obj := obj.(type) {
+		case *MP3Frame:
+			debug("NextID3v2Tag: skipping MP3 frame")
+		case *ID3v1Tag:
+			debug("NextID3v2Tag: skipping ID3v1 tag")
+		case *ID3v2Tag:
+			return obj, nil
+		case *APEv2Tag:
+			debug("NextID3v2Tag: skipping APEv2 tag")
+		case *RIFFHeader:
+			debug("NextID3v2Tag: skipping RIFF header")
+		}
+	}
+}
+
+// NextObject loads the next recognised object from the input stream. Skips
+// over unrecognised/garbage data. Returns *MP3Frame, *ID3v1Tag, *ID3v2Tag,
+// *APEv2Tag, *RIFFHeader, or nil when the stream has been exhausted or if a read error occurs. Use
+// NextObjectErr to distinguish between these cases.
+func NextObject(stream io.Reader) interface{} {
+	obj, _ := NextObjectErr(stream)
+	return obj
+}
+
+// NextObjectErr loads the next recognised object from the input stream.
+// Skips over unrecognised/garbage data. Returns *MP3Frame, *ID3v1Tag,
+// *ID3v2Tag, *APEv2Tag, or *RIFFHeader. Returns errors in the same way as NextFrameErr.
+func NextObjectErr(stream io.Reader) (interface{}, error) {
+	return nextObject(stream, 0)
+}
+
+// nextObject implements NextObjectErr. If maxResync is greater than zero,
+// it returns ErrResyncLimit after skipping more than maxResync bytes of
+// unrecognised data in a row.
+func nextObject(stream io.Reader, maxResync int64) (interface{}, error) {
+	var skipped int64
+
+	// The unrecognised data skipped in a row, up to the size of the largest
+	// APEv2 tag. A tag with only a footer follows its items, which look like
+	// garbage until the footer is found and claims them.
+	var skippedData []byte
+
+	// Each MP3 frame begins with a 4-byte header.
+	buffer := make([]byte, 4)
+	lastByte := buffer[3:]
+
+	// Fill the header buffer. A stream ending in fewer than 4 bytes of
+	// leftover data is exhausted as these can't be an object.
+	if _, err := io.ReadFull(stream, buffer); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	// Scan forward until we find an object or reach the end of the stream.
+	for {
+
+		// Check for an ID3v1 tag: 'TAG'.
+		if buffer[0] == 84 && buffer[1] == 65 && buffer[2] == 71 {
+
+			tag := &ID3v1Tag{}
+			tag.RawBytes = make([]byte, 128)
+			copy(tag.RawBytes, buffer)
+
+			if err := fillBuffer(stream, tag.RawBytes[4:]); err != nil {
+				return nil, err
+			}
+
+			return tag, nil
+		}
+
+		// Check for an ID3v2 tag: 'ID3'.
+		if buffer[0] == 73 && buffer[1] == 68 && buffer[2] == 51 {
+
+			// Read the remainder of the 10 byte tag header.
+			remainder := make([]byte, 6)
+			if err := fillBuffer(stream, remainder); err != nil {
+				return nil, err
+			}
+
+			// The last 4 bytes of the header indicate the length of the tag.
+			// This length does not include the header itself.
+			length :=
+				(int(remainder[2]) << (7 * 3)) |
+					(int(remainder[3]) << (7 * 2)) |
+					(int(remainder[4]) << (7 * 1)) |
+					(int(remainder[5]) << (7 * 0))
+
+			if err := checkTagSize(int64(10 + length)); err != nil {
+				return nil, err
+			}
+
+			tag := &ID3v2Tag{}
+			tag.RawBytes = make([]byte, 10+length)
+			copy(tag.RawBytes, buffer)
+			copy(tag.RawBytes[4:], remainder)
+
+			if err := fillBuffer(stream, tag.RawBytes[10:]); err != nil {
+				return nil, err
+			}
+
+			return tag, nil
+		}
+
+		// Check for an APEv2 tag: 'APETAGEX'. We need to read ahead to
+		// check the rest of the preamble; if it doesn't match, the bytes
+		// we've read are pushed back onto the stream and scanned as usual.
+		if bytes.Equal(buffer, apePreamble[:4]) {
+			block := make([]byte, 32)
+			copy(block, buffer)
+
+			n, err := io.ReadFull(stream, block[4:8])
+			if err == nil && bytes.Equal(block[4:8], apePreamble[4:]) {
+				if err := fillBuffer(stream, block[8:]); err != nil {
+					return nil, err
+				}
+				return readAPEv2Tag(stream, block, skippedData)
+			}
+			stream = io.MultiReader(bytes.NewReader(block[4:4+n]), stream)
+		}
+
+		// Check for a RIFF/WAVE header: 'RIFF', then the file size, then
+		// 'WAVE'. Bytes read ahead are pushed back if it doesn't match.
+		if bytes.Equal(buffer, []byte("RIFF")) {
+			block := make([]byte, 12)
+			copy(block, buffer)
+
+			n, err := io.ReadFull(stream, block[4:])
+			if err == nil && isRIFFWave(block) {
+				return readRIFFHeader(stream, block)
+			}
+			stream = io.MultiReader(bytes.NewReader(block[4:4+n]), stream)
+		}
+
+		// Check for a frame header, indicated by an 11-bit frame-sync
+		// sequence.
+		if buffer[0] == 0xFF && (buffer[1]&0xE0) == 0xE0 {
+
+			var header MP3Frame
+
+			if ok := parseHeader(buffer, &header); ok {
+				debug("NextObject: found frame")
+
+				frame := newFrame(&header)
+				copy(frame.RawBytes, buffer)
+
+				if err := fillBuffer(stream, frame.RawBytes[4:]); err != nil {
+					ReleaseFrame(frame)
+					if err == io.ErrUnexpectedEOF {
+						return nil, ErrTruncatedFrame
+					}
+					return nil, err
+				}
+
+				return frame, nil
+			}
+		}
+
+		// Nothing found. Shift the buffer forward by one byte and try again.
+		debug("NextObject: sync error: skipping byte")
+		skipped++
+		if maxResync > 0 && skipped > maxResync {
+			return nil, ErrResyncLimit
+		}
+		if len(skippedData) == 2*apeMaxSize {
+			skippedData = append(skippedData[:0], skippedData[apeMaxSize:]...)
+		}
+		skippedData = append(skippedData, buffer[0])
+		buffer[0] = buffer[1]
+		buffer[1] = buffer[2]
+		buffer[2] = buffer[3]
+		if _, err := io.ReadFull(stream, lastByte); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ParseHeader attempts to parse a slice of 4 bytes as a valid MP3 frame header.
+// Returns nil if the bytes are not a valid header. The returned frame's
+// RawBytes field is empty.
+func ParseHeader(header []byte) *MP3Frame {
+	if len(header) < 4 || header[0] != 0xFF || (header[1]&0xE0) != 0xE0 {
+		return nil
+	}
+	frame := &MP3Frame{}
+	if ok := parseHeader(header, frame); !ok {
+		return nil
+	}
+	return frame
+}
+
+// parseHeader attempts to parse a slice of 4 bytes as a valid MP3 header. The
+// return value is a boolean indicating success. If the header is valid its
+// values are written into the supplied MP3Frame struct.
+func parseHeader(header []byte, frame *MP3Frame) bool {
+
+	// MPEG version. (2 bits)
+	frame.MPEGVersion = (header[1] & 0x18) >> 3
+	if frame.MPEGVersion == MPEGVersionReserved {
+		return false
+	}
+
+	// MPEG layer. (2 bits.)
+	frame.MPEGLayer = (header[1] & 0x06) >> 1
+	if frame.MPEGLayer == MPEGLayerReserved {
+		return false
+	}
+
+	// CRC (cyclic redundency check) protection. (1 bit.)
+	frame.CrcProtection = (header[1] & 0x01) == 0x00
+
+	// Bit rate index. (4 bits.)
+	bitRateIndex := (header[2] & 0xF0) >> 4
+	if bitRateIndex == 0 || bitRateIndex == 15 {
+		return false
+	}
+
+	// Bit rate.
+	if frame.MPEGVersion == MPEGVersion1 {
+		switch frame.MPEGLayer {
+		case MPEGLayerI:
+			frame.BitRate = v1l1_br[bitRateIndex] * 1000
+		case MPEGLayerII:
+			frame.BitRate = v1l2_br[bitRateIndex] * 1000
+		case MPEGLayerIII:
+			frame.BitRate = v1l3_br[bitRateIndex] * 1000
+		}
+	} else {
+		switch frame.MPEGLayer {
+		case MPEGLayerI:
+			frame.BitRate = v2l1_br[bitRateIndex] * 1000
+		case MPEGLayerII:
+			frame.BitRate = v2l2_br[bitRateIndex] * 1000
+		case MPEGLayerIII:
+			frame.BitRate = v2l3_br[bitRateIndex] * 1000
+		}
+	}
+
+	// Sampling rate index. (2 bits.)
+	samplingRateIndex := (header[2] & 0x0C) >> 2
+	if samplingRateIndex == 3 {
+		return false
+	}
+
+	// Sampling rate.
+	switch frame.MPEGVersion {
+	case MPEGVersion1:
+		frame.SamplingRate = v1_sr[samplingRateIndex]
+	case MPEGVersion2:
+		frame.SamplingRate = v2_sr[samplingRateIndex]
+	case MPEGVersion2_5:
+		frame.SamplingRate = v25_sr[samplingRateIndex]
+	}
+
+	// Padding bit. (1 bit.)
+	frame.PaddingBit = (header[2] & 0x02) == 0x02
+
+	// Private bit. (1 bit.)
+	frame.PrivateBit = (header[2] & 0x01) == 0x01
+
+	// Channel mode. (2 bits.)
+	frame.ChannelMode = (header[3] & 0xC0) >> 6
+
+	// Mode Extension. Valid only for Joint Stereo mode. (2 bits.)
+	frame.ModeExtension = (header[3] & 0x30) >> 4
+	if frame.ChannelMode != JointStereo && frame.ModeExtension != 0 {
+		return false
+	}
+
+	// Copyright bit. (1 bit.)
+	frame.CopyrightBit = (header[3] & 0x08) == 0x08
+
+	// Original bit. (1 bit.)
+	frame.OriginalBit = (header[3] & 0x04) == 0x04
+
+	// Emphasis. (2 bits.)
+	frame.Emphasis = (header[3] & 0x03)
+	if frame.Emphasis == 2 {
+		return false
+	}
+
+	// Number of samples in the frame. We need this to determine the frame size.
+	if frame.MPEGVersion == MPEGVersion1 {
+		switch frame.MPEGLayer {
+		case MPEGLayerI:
+			frame.SampleCount = 384
+		case MPEGLayerII:
+			frame.SampleCount = 1152
+		case MPEGLayerIII:
+			frame.SampleCount = 1152
+		}
+	} else {
+		switch frame.MPEGLayer {
+		case MPEGLayerI:
+			frame.SampleCount = 384
+		case MPEGLayerII:
+			frame.SampleCount = 1152
+		case MPEGLayerIII:
+			frame.SampleCount = 576
+		}
+	}
+
+	// If the padding bit is set we add an extra 'slot' to the frame length.
+	// A layer I slot is 4 bytes long; layer II and III slots are 1 byte long.
+	var padding int = 0
+
+	if frame.PaddingBit {
+		if frame.MPEGLayer == MPEGLayerI {
+			padding = 4
+		} else {
+			padding = 1
+		}
+	}
+
+	// Calculate the frame length in bytes. There's a lot of confusion online
+	// about how to do this and definitive documentation is hard to find as
+	// the official MP3 specification is not publicly available. The
+	// basic formula seems to boil down to:
+	//
+	//     bytes_per_sample = (bit_rate / sampling_rate) / 8
+	//     frame_length = sample_count * bytes_per_sample + padding
+	//
+	// In practice we need to rearrange this formula to avoid rounding errors.
+	//
+	// I can't find any definitive statement on whether this length is
+	// supposed to include the 4-byte header and the optional 2-byte CRC.
+	// Experimentation on mp3 files captured from the wild indicates that it
+	// includes the header at least.
+	//
+	// Layer I frames are a whole number of 4-byte slots, so the length is
+	// rounded down to a multiple of 4 before the padding is added.
+	if frame.MPEGLayer == MPEGLayerI {
+		frame.FrameLength = 12*frame.BitRate/frame.SamplingRate*4 + padding
+	} else {
+		frame.FrameLength =
+			(frame.SampleCount/8)*frame.BitRate/frame.SamplingRate + padding
+	}
+
+	return true
+}
+
+// getSideInfoSize returns the length in bytes of the side information section
+// of the supplied MP3 frame.
+func getSideInfoSize(frame *MP3Frame) (size int) {
+
+	if frame.MPEGLayer == MPEGLayerIII {
+		if frame.MPEGVersion == MPEGVersion1 {
+			if frame.ChannelMode == Mono {
+				size = 17
+			} else {
+				size = 32
+			}
+		} else {
+			if frame.ChannelMode == Mono {
+				size = 9
+			} else {
+				size = 17
+			}
+		}
+	}
+
+	return size
+}
+
+// IsXingHeader returns true if the supplied frame is an Xing VBR header.
+func IsXingHeader(frame *MP3Frame) bool {
+	return xingOffset(frame) >= 0
+}
+
+// xingOffset returns the offset in the frame of the ID of an Xing or Info
+// header, or -1 if the frame isn't one.
+//
+// The header begins directly after the 4-byte frame header and the side
+// information, which is 17 or 32 bytes long in MPEG-1 frames and 9 or 17
+// bytes in MPEG-2 and 2.5 frames, depending on the channel mode. Decoders
+// look for it there whether or not the frame has a CRC, but some encoders
+// place it after the CRC, and some place the header of an MPEG-2 or 2.5 file
+// at the MPEG-1 offset or the other way round, so these offsets are tried
+// if the header isn't in its proper place.
+func xingOffset(frame *MP3Frame) int {
+	size := getSideInfoSize(frame)
+	offsets := []int{4 + size}
+	if frame.MPEGLayer == MPEGLayerIII {
+		offsets = append(offsets, getSideInfoOffset(frame)+size, 4+9, 4+17, 4+32)
+	}
+
+	for _, offset := range offsets {
+		if len(frame.RawBytes) < offset+4 {
+			continue
+		}
+		id := frame.RawBytes[offset : offset+4]
+		if bytes.Equal(id, []byte("Xing")) || bytes.Equal(id, []byte("Info")) {
+			return offset
+		}
+	}
+	return -1
+}
+
+// IsVbriHeader returns true if the supplied frame is a Fraunhofer VBRI header.
+func IsVbriHeader(frame *MP3Frame) bool {
+
+	// The VBRI header begins after a fixed 32-byte offset. We also need to
+	// allow 4 bytes for the frame header.
+	if len(frame.RawBytes) < 4+32+4 {
+		return false
+	}
+
+	flag := frame.RawBytes[4+32 : 4+32+4]
+	if bytes.Equal(flag, []byte("VBRI")) {
+		return true
+	}
+
+	return false
+}
+
+// VBRHeaderSearchFrames is the number of frames at the start of a stream
+// which are checked for a VBR header. The header is normally the first
+// frame, but some encoders write a junk frame before it.
+const VBRHeaderSearchFrames = 4
+
+// IsVBRHeaderFrame returns true if the supplied frame is a VBR header of any
+// kind: an Xing header, its CBR 'Info' variant, or a VBRI header. These
+// frames contain no audio and describe the stream as a whole, so a frame
+// which is one should be skipped when copying the stream's audio. Safe to
+// call with a nil frame or a frame of any length.
+func IsVBRHeaderFrame(frame *MP3Frame) bool {
+	if frame == nil {
+		return false
+	}
+	return IsXingHeader(frame) || IsVbriHeader(frame)
+}
+
+// NewXingHeader creates a new Xing header frame for a VBR file.
+func NewXingHeader(totalFrames, totalBytes uint32) *MP3Frame {
+	return newXingFrame("Xing", totalFrames, totalBytes)
+}
+
+// NewInfoHeader creates a new Info header frame for a CBR file. Info headers
+// have the same layout as Xing headers but a different ID, which tells
+// players the file has a constant bitrate.
+func NewInfoHeader(totalFrames, totalBytes uint32) *MP3Frame {
+	return newXingFrame("Info", totalFrames, totalBytes)
+}
+
+// newXingFrame creates a new Xing-layout header frame with the given ID and
+// the number-of-frames and number-of-bytes fields.
+func newXingFrame(id string, totalFrames, totalBytes uint32) *MP3Frame {
+	return NewXingFrame(nil, &XingInfo{
+		ID:     id,
+		Flags:  XingFramesFlag | XingBytesFlag,
+		Frames: totalFrames,
+		Bytes:  totalBytes,
+	})
+}
+
+// Print debugging information to stderr.
+func debug(message string) {
+	if DebugMode {
+		fmt.Fprintln(os.Stderr, "DEBUG:", message)
+	}
+}
+
+// Attempt to read len(buffer) bytes from the input stream to complete an
+// object. Returns io.ErrUnexpectedEOF if the stream ends before the buffer
+// is filled.
+func fillBuffer(stream io.Reader, buffer []byte) error {
+	_, err := io.ReadFull(stream, buffer)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
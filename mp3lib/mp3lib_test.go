@@ -0,0 +1,93 @@
+package mp3lib
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Returns a layer III frame without a CRC and with a zeroed body, with the
+// given MPEG version, bitrate and sampling rate indexes, and channel mode.
+func testLayerIIIFrame(t testing.TB, version, bitRateIndex, rateIndex, mode byte) []byte {
+	t.Helper()
+	frame := NewFrame(version, MPEGLayerIII, bitRateIndex, rateIndex, mode)
+	if frame == nil {
+		t.Fatalf("invalid test frame: version %v, bitrate index %v, rate index %v, mode %v",
+			version, bitRateIndex, rateIndex, mode)
+	}
+	return frame.RawBytes
+}
+
+// Returns an MPEG-1 layer III frame at 44.1 kHz, joint stereo, with the
+// given bitrate index and a zeroed body.
+func testFrameWithBitRate(t testing.TB, bitRateIndex byte) []byte {
+	t.Helper()
+	return testLayerIIIFrame(t, MPEGVersion1, bitRateIndex, 0, JointStereo)
+}
+
+// Returns an MPEG-1 layer III frame at 128 kbps, 44.1 kHz, joint stereo,
+// with a zeroed body.
+func testFrame(t testing.TB) []byte {
+	t.Helper()
+	return testFrameWithBitRate(t, 9)
+}
+
+// Returns a stream like a typical VBR file: an ID3v2 tag, then count layer
+// III frames cycling through a range of bitrates, with an ID3v1 tag at the
+// end.
+func testStream(t testing.TB, count int) []byte {
+	t.Helper()
+	var frames [][]byte
+	for index := byte(5); index <= 11; index++ {
+		frames = append(frames, testFrameWithBitRate(t, index))
+	}
+
+	stream := NewID3v2Tag([]*ID3v2Frame{NewTextFrame("TIT2", "Benchmark")}).RawBytes
+	for i := range count {
+		stream = append(stream, frames[i%len(frames)]...)
+	}
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	return append(stream, tag...)
+}
+
+func BenchmarkNextFrame(b *testing.B) {
+	stream := testStream(b, 10000)
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+
+	for range b.N {
+		reader := bytes.NewReader(stream)
+		var frames int
+		for NextFrame(reader) != nil {
+			frames++
+		}
+		if frames != 10000 {
+			b.Fatalf("read %v frames, want 10000", frames)
+		}
+	}
+}
+
+func BenchmarkFrameReader(b *testing.B) {
+	stream := testStream(b, 10000)
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+
+	frame := &MP3Frame{}
+	for range b.N {
+		reader := NewFrameReader(bytes.NewReader(stream))
+		var frames int
+		for {
+			err := reader.ReadInto(frame)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+			frames++
+		}
+		if frames != 10000 {
+			b.Fatalf("read %v frames, want 10000", frames)
+		}
+	}
+}
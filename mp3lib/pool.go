@@ -0,0 +1,35 @@
+package mp3lib
+
+import "sync"
+
+// Pool of frames for NextObjectErr to read into. Frames are returned to the
+// pool by ReleaseFrame.
+var framePool = sync.Pool{
+	New: func() any {
+		return &MP3Frame{}
+	},
+}
+
+// newFrame returns a frame from the pool with the header fields of [header]
+// and a RawBytes slice of header.FrameLength bytes. The slice's existing
+// content is undefined.
+func newFrame(header *MP3Frame) *MP3Frame {
+	frame := framePool.Get().(*MP3Frame)
+	buffer := frame.RawBytes
+	*frame = *header
+	if cap(buffer) < header.FrameLength {
+		buffer = make([]byte, header.FrameLength)
+	}
+	frame.RawBytes = buffer[:header.FrameLength]
+	return frame
+}
+
+// ReleaseFrame returns a frame read by NextFrame, NextFrameErr, NextObject,
+// NextObjectErr, or a Validator to a pool so its memory can be reused by later
+// reads. The frame must not be used after it's been released. Releasing frames
+// is optional, but reduces allocations when reading long streams.
+func ReleaseFrame(frame *MP3Frame) {
+	if frame != nil {
+		framePool.Put(frame)
+	}
+}
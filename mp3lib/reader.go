@@ -0,0 +1,214 @@
+package mp3lib
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+// Default size of a FrameReader's internal buffer.
+const defaultBufferSize = 64 * 1024
+
+// FrameReader reads MP3 frames from a stream through an internal buffer. Unlike
+// NextFrame, it can read frames into an existing MP3Frame, reusing its
+// RawBytes slice, so reading a stream doesn't allocate per frame.
+type FrameReader struct {
+	reader      *bufio.Reader
+	offset      int64         // Number of bytes consumed from the stream.
+	frameOffset int64         // Offset of the most recently read frame.
+	maxResync   int64         // Limit on unrecognised data skipped in a row, or zero for none.
+	skipped     int64         // Total unrecognised data skipped.
+	frames      int           // Number of frames read.
+	timestamp   time.Duration // Start time of the most recently read frame.
+	elapsed     time.Duration // Playing time of the frames read so far.
+}
+
+// NewFrameReader returns a new FrameReader with a default-sized buffer.
+func NewFrameReader(stream io.Reader) *FrameReader {
+	return NewFrameReaderSize(stream, defaultBufferSize)
+}
+
+// NewFrameReaderSize returns a new FrameReader whose buffer has at least the
+// specified size.
+func NewFrameReaderSize(stream io.Reader, size int) *FrameReader {
+	return &FrameReader{reader: bufio.NewReaderSize(stream, size)}
+}
+
+// SetMaxResync limits the number of bytes of unrecognised data the reader
+// will skip in a row while looking for the next frame. Once the limit is
+// exceeded, reads return ErrResyncLimit. A limit of zero, the default, means
+// no limit.
+func (r *FrameReader) SetMaxResync(n int64) {
+	r.maxResync = n
+}
+
+// ReadFrame reads the next MP3 frame into a newly allocated MP3Frame. Returns
+// errors in the same way as NextFrameErr.
+func (r *FrameReader) ReadFrame() (*MP3Frame, error) {
+	frame := &MP3Frame{}
+	if err := r.ReadInto(frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ReadInto reads the next MP3 frame into the supplied MP3Frame, overwriting
+// its fields. The frame's RawBytes slice is reused if it has enough capacity.
+// Skips over ID3 tags and unrecognised/garbage data in the stream. Returns
+// errors in the same way as NextFrameErr.
+func (r *FrameReader) ReadInto(frame *MP3Frame) error {
+	var skipped int64
+	for {
+		header, err := r.reader.Peek(4)
+		if err != nil {
+			if err == io.EOF && len(header) > 0 {
+				// Fewer than 4 bytes of leftover data can't be an object.
+				r.discard(len(header))
+			}
+			return err
+		}
+
+		// Skip ID3v1 tags: 'TAG'.
+		if header[0] == 84 && header[1] == 65 && header[2] == 71 {
+			debug("FrameReader: skipping ID3v1 tag")
+			if err := r.discard(128); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Skip ID3v2 tags: 'ID3'. The last 4 bytes of the 10-byte tag
+		// header indicate the length of the tag, excluding the header.
+		if header[0] == 73 && header[1] == 68 && header[2] == 51 {
+			tagHeader, err := r.reader.Peek(10)
+			if err != nil {
+				return unexpected(err)
+			}
+			length :=
+				(int(tagHeader[6]) << (7 * 3)) |
+					(int(tagHeader[7]) << (7 * 2)) |
+					(int(tagHeader[8]) << (7 * 1)) |
+					(int(tagHeader[9]) << (7 * 0))
+			debug("FrameReader: skipping ID3v2 tag")
+			if err := r.discard(10 + length); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Skip APEv2 tags: 'APETAGEX'. If the block is a header, the tag
+		// items and footer follow it. If it's a footer, the items precede
+		// it and have been skipped as unrecognised data, so they're no
+		// longer counted as such. A corrupt block is skipped by itself.
+		if bytes.Equal(header, apePreamble[:4]) {
+			block, err := r.reader.Peek(32)
+			if err == nil && bytes.Equal(block[:8], apePreamble) {
+				debug("FrameReader: skipping APEv2 tag")
+				length := 32
+				if ape, ok := parseAPEBlock(block); ok && ape.flags&apeIsHeader != 0 {
+					length += int(ape.size)
+				} else if ok {
+					items := int64(footerItems(ape, int(min(skipped, apeMaxSize))))
+					skipped -= items
+					r.skipped -= items
+				}
+				if err := r.discard(length); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		// Skip the RIFF/WAVE header and chunks preceding the data chunk of
+		// an MPEG-in-WAV file.
+		if bytes.Equal(header, []byte("RIFF")) {
+			block, err := r.reader.Peek(12)
+			if err == nil && isRIFFWave(block) {
+				debug("FrameReader: skipping RIFF header")
+				prefix := make([]byte, 12)
+				io.ReadFull(r.reader, prefix)
+				riff, err := readRIFFHeader(r.reader, prefix)
+				if err != nil {
+					return err
+				}
+				r.offset += int64(len(riff.RawBytes))
+				continue
+			}
+		}
+
+		// Check for a frame header.
+		if header[0] == 0xFF && (header[1]&0xE0) == 0xE0 {
+			buffer := frame.RawBytes
+			*frame = MP3Frame{}
+			if ok := parseHeader(header, frame); ok {
+				if cap(buffer) < frame.FrameLength {
+					buffer = make([]byte, frame.FrameLength)
+				}
+				frame.RawBytes = buffer[:frame.FrameLength]
+				r.frameOffset = r.offset
+				n, err := io.ReadFull(r.reader, frame.RawBytes)
+				r.offset += int64(n)
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return ErrTruncatedFrame
+				} else if err != nil {
+					return err
+				}
+
+				// A VBR header at the start of the stream isn't played, so it has no duration.
+				r.timestamp = r.elapsed
+				if r.frames >= VBRHeaderSearchFrames || !IsVBRHeaderFrame(frame) {
+					r.elapsed += frame.Duration()
+				}
+				r.frames++
+				return nil
+			}
+			frame.RawBytes = buffer
+		}
+
+		// Nothing found. Skip a byte and try again.
+		debug("FrameReader: sync error: skipping byte")
+		skipped++
+		r.skipped++
+		if r.maxResync > 0 && skipped > r.maxResync {
+			return ErrResyncLimit
+		}
+		r.discard(1)
+	}
+}
+
+// Offset returns the byte offset in the stream of the most recently read
+// frame.
+func (r *FrameReader) Offset() int64 {
+	return r.frameOffset
+}
+
+// Skipped returns the total number of bytes of unrecognised data skipped so
+// far. Tags and other recognised objects aren't counted.
+func (r *FrameReader) Skipped() int64 {
+	return r.skipped
+}
+
+// Timestamp returns the playback time at which the most recently read frame
+// starts, i.e. the total duration of the frames read before it. A VBR header
+// frame at the start of the stream is counted as having no duration.
+func (r *FrameReader) Timestamp() time.Duration {
+	return r.timestamp
+}
+
+// discard skips n bytes, returning io.ErrUnexpectedEOF if the stream ends
+// first.
+func (r *FrameReader) discard(n int) error {
+	discarded, err := r.reader.Discard(n)
+	r.offset += int64(discarded)
+	return unexpected(err)
+}
+
+// unexpected converts io.EOF to io.ErrUnexpectedEOF for reads which end
+// partway through an object.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package mp3lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// WAVE format tags for MPEG audio.
+const (
+	WaveFormatMPEG       = 0x0050
+	WaveFormatMPEGLayer3 = 0x0055
+)
+
+// Chunks larger than this before the data chunk are assumed to be corrupt.
+const riffMaxChunkSize = 16 << 20
+
+// RIFFHeader represents the RIFF/WAVE header and chunks preceding the data
+// chunk of an MPEG-in-WAV file. The MPEG frames follow in the data chunk.
+// Any chunks after the data chunk are treated as unrecognised data.
+type RIFFHeader struct {
+	Format   uint16 // Format tag from the 'fmt ' chunk, e.g. WaveFormatMPEGLayer3.
+	DataSize uint32 // Size of the data chunk.
+	RawBytes []byte
+}
+
+// isRIFFWave returns true if the 12 bytes of data begin a RIFF/WAVE file.
+func isRIFFWave(data []byte) bool {
+	return len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE"))
+}
+
+// readRIFFHeader reads the chunks of a RIFF/WAVE file up to and including
+// the header of the data chunk. The 12-byte RIFF header, already read from
+// the stream, is supplied as prefix.
+func readRIFFHeader(stream io.Reader, prefix []byte) (*RIFFHeader, error) {
+	header := &RIFFHeader{RawBytes: append([]byte(nil), prefix...)}
+
+	for {
+		chunkHeader := make([]byte, 8)
+		if err := fillBuffer(stream, chunkHeader); err != nil {
+			return nil, err
+		}
+		header.RawBytes = append(header.RawBytes, chunkHeader...)
+
+		id := string(chunkHeader[:4])
+		size := binary.LittleEndian.Uint32(chunkHeader[4:])
+		if id == "data" {
+			header.DataSize = size
+			return header, nil
+		}
+
+		// Give up on a corrupt chunk and let the caller scan for frames.
+		if size > riffMaxChunkSize {
+			debug("readRIFFHeader: chunk too large")
+			return header, nil
+		}
+
+		// Chunks are padded to an even length.
+		chunk := make([]byte, size+size%2)
+		if err := fillBuffer(stream, chunk); err != nil {
+			return nil, err
+		}
+		header.RawBytes = append(header.RawBytes, chunk...)
+
+		if id == "fmt " && size >= 2 {
+			header.Format = binary.LittleEndian.Uint16(chunk)
+		}
+	}
+}
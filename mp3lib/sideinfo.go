@@ -0,0 +1,203 @@
+package mp3lib
+
+import "errors"
+
+// getSideInfoOffset returns the offset of the side information section of a
+// layer III frame, allowing for the 4-byte header and the optional 2-byte CRC.
+func getSideInfoOffset(frame *MP3Frame) int {
+	if frame.CrcProtection {
+		return 6
+	}
+	return 4
+}
+
+// MainDataBegin returns the main_data_begin field of a layer III frame's side
+// information. A non-zero value is a negative offset, in bytes, to the point
+// in the preceding frames where the frame's audio data begins - the so-called
+// bit reservoir. Returns 0 for other layers or if the frame is too short.
+func MainDataBegin(frame *MP3Frame) int {
+	offset := getSideInfoOffset(frame)
+	if frame.MPEGLayer != MPEGLayerIII || len(frame.RawBytes) < offset+2 {
+		return 0
+	}
+
+	// The field is 9 bits long for MPEG version 1, 8 bits otherwise.
+	reader := &bitReader{data: frame.RawBytes[offset:]}
+	if frame.MPEGVersion == MPEGVersion1 {
+		return reader.read(9)
+	}
+	return reader.read(8)
+}
+
+// ClearMainDataBegin sets the main_data_begin field of a layer III frame to
+// zero so the frame no longer depends on audio data from preceding frames.
+// Updates the frame's CRC if it's CRC-protected.
+func ClearMainDataBegin(frame *MP3Frame) {
+	SetMainDataBegin(frame, 0)
+}
+
+// SetMainDataBegin sets the main_data_begin field of a layer III frame. The
+// value is truncated to the field's 9 bits for MPEG version 1, 8 bits
+// otherwise. Updates the frame's CRC if it's CRC-protected.
+func SetMainDataBegin(frame *MP3Frame, value int) {
+	offset := getSideInfoOffset(frame)
+	if frame.MPEGLayer != MPEGLayerIII || len(frame.RawBytes) < offset+2 {
+		return
+	}
+
+	if frame.MPEGVersion == MPEGVersion1 {
+		frame.RawBytes[offset] = byte(value >> 1)
+		frame.RawBytes[offset+1] = frame.RawBytes[offset+1]&0x7F | byte(value&1)<<7
+	} else {
+		frame.RawBytes[offset] = byte(value)
+	}
+
+	UpdateCRC(frame)
+}
+
+// maxMainDataBegin returns the largest value of a layer III frame's
+// main_data_begin field.
+func maxMainDataBegin(frame *MP3Frame) int {
+	if frame.MPEGVersion == MPEGVersion1 {
+		return 511
+	}
+	return 255
+}
+
+// SideInfo holds the side information section of a layer III frame, which
+// describes how to decode the frame's audio data.
+type SideInfo struct {
+	MainDataBegin int
+	PrivateBits   int
+
+	// Scale factor selection information, per channel and scale factor band.
+	// MPEG version 1 only.
+	Scfsi [2][4]bool
+
+	// Granule information, indexed by granule then channel. MPEG version 1
+	// frames have two granules; MPEG version 2 and 2.5 frames have one.
+	Granules [2][2]Granule
+
+	NumGranules int
+	NumChannels int
+}
+
+// Granule holds the side information for a single granule and channel.
+type Granule struct {
+	Part2_3Length     int
+	BigValues         int
+	GlobalGain        int
+	ScalefacCompress  int
+	WindowSwitching   bool
+	BlockType         int
+	MixedBlock        bool
+	TableSelect       [3]int
+	SubblockGain      [3]int
+	Region0Count      int
+	Region1Count      int
+	Preflag           bool
+	ScalefacScale     bool
+	Count1TableSelect int
+}
+
+// ParseSideInfo parses the side information section of a layer III frame.
+func ParseSideInfo(frame *MP3Frame) (*SideInfo, error) {
+	if frame.MPEGLayer != MPEGLayerIII {
+		return nil, errors.New("mp3lib: side information is only defined for layer III frames")
+	}
+
+	offset := getSideInfoOffset(frame)
+	size := getSideInfoSize(frame)
+	if len(frame.RawBytes) < offset+size {
+		return nil, errors.New("mp3lib: frame is too short to contain side information")
+	}
+
+	info := &SideInfo{NumGranules: 2, NumChannels: 2}
+	if frame.MPEGVersion != MPEGVersion1 {
+		info.NumGranules = 1
+	}
+	if frame.ChannelMode == Mono {
+		info.NumChannels = 1
+	}
+
+	reader := &bitReader{data: frame.RawBytes[offset : offset+size]}
+
+	if frame.MPEGVersion == MPEGVersion1 {
+		info.MainDataBegin = reader.read(9)
+		if info.NumChannels == 1 {
+			info.PrivateBits = reader.read(5)
+		} else {
+			info.PrivateBits = reader.read(3)
+		}
+		for ch := 0; ch < info.NumChannels; ch++ {
+			for band := 0; band < 4; band++ {
+				info.Scfsi[ch][band] = reader.read(1) == 1
+			}
+		}
+	} else {
+		info.MainDataBegin = reader.read(8)
+		if info.NumChannels == 1 {
+			info.PrivateBits = reader.read(1)
+		} else {
+			info.PrivateBits = reader.read(2)
+		}
+	}
+
+	for gr := 0; gr < info.NumGranules; gr++ {
+		for ch := 0; ch < info.NumChannels; ch++ {
+			g := &info.Granules[gr][ch]
+			g.Part2_3Length = reader.read(12)
+			g.BigValues = reader.read(9)
+			g.GlobalGain = reader.read(8)
+			if frame.MPEGVersion == MPEGVersion1 {
+				g.ScalefacCompress = reader.read(4)
+			} else {
+				g.ScalefacCompress = reader.read(9)
+			}
+			g.WindowSwitching = reader.read(1) == 1
+			if g.WindowSwitching {
+				g.BlockType = reader.read(2)
+				g.MixedBlock = reader.read(1) == 1
+				for i := 0; i < 2; i++ {
+					g.TableSelect[i] = reader.read(5)
+				}
+				for i := 0; i < 3; i++ {
+					g.SubblockGain[i] = reader.read(3)
+				}
+			} else {
+				for i := 0; i < 3; i++ {
+					g.TableSelect[i] = reader.read(5)
+				}
+				g.Region0Count = reader.read(4)
+				g.Region1Count = reader.read(3)
+			}
+			if frame.MPEGVersion == MPEGVersion1 {
+				g.Preflag = reader.read(1) == 1
+			}
+			g.ScalefacScale = reader.read(1) == 1
+			g.Count1TableSelect = reader.read(1)
+		}
+	}
+
+	return info, nil
+}
+
+// bitReader reads big-endian bit fields from a byte slice.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+// read returns the next n bits as an integer. Bits past the end of the data
+// read as zero.
+func (r *bitReader) read(n int) int {
+	var value int
+	for i := 0; i < n; i++ {
+		value <<= 1
+		if r.pos/8 < len(r.data) {
+			value |= int(r.data[r.pos/8]>>(7-r.pos%8)) & 1
+		}
+		r.pos++
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package mp3lib
+
+// NewSilentFrame returns a layer III frame of silence in the same format as
+// the template frame: the same MPEG version, sampling rate, channel mode, and
+// CRC protection. The frame's side information is all zeros, so it uses no
+// audio data of its own and doesn't borrow any from preceding frames; its
+// main data area is left as zeros which a following frame can borrow instead.
+//
+// The frame has the template's bit rate if that leaves at least minMainData
+// bytes in its main data area, otherwise the lowest bit rate which does.
+// Returns nil if the template isn't a layer III frame or no bit rate is large
+// enough.
+func NewSilentFrame(template *MP3Frame, minMainData int) *MP3Frame {
+	if template.MPEGLayer != MPEGLayerIII || len(template.RawBytes) < 4 {
+		return nil
+	}
+
+	header := make([]byte, 4)
+	copy(header, template.RawBytes[:4])
+
+	// Clear the padding bit so the frame length depends only on the bit rate.
+	header[2] &^= 0x02
+
+	for index := int(header[2] >> 4); index < 15; index++ {
+		header[2] = header[2]&0x0F | byte(index)<<4
+
+		frame := &MP3Frame{}
+		if !parseHeader(header, frame) {
+			return nil
+		}
+		if frame.FrameLength-getSideInfoOffset(frame)-getSideInfoSize(frame) < minMainData {
+			continue
+		}
+
+		frame.RawBytes = make([]byte, frame.FrameLength)
+		copy(frame.RawBytes, header)
+		UpdateCRC(frame)
+		return frame
+	}
+
+	return nil
+}
+
+// NewFrame returns a frame without CRC protection with the given MPEG
+// version, layer, bit rate index, sampling rate index, and channel mode, and
+// a body of zeros, which decodes as silence. The version, layer, and channel
+// mode take the values of the MP3Frame fields. Returns nil if the fields
+// don't make a valid header.
+func NewFrame(version, layer, bitRateIndex, samplingRateIndex, channelMode byte) *MP3Frame {
+	header := []byte{
+		0xFF,
+		0xE0 | (version&0x03)<<3 | (layer&0x03)<<1 | 0x01,
+		(bitRateIndex&0x0F)<<4 | (samplingRateIndex&0x03)<<2,
+		(channelMode & 0x03) << 6,
+	}
+	frame := &MP3Frame{}
+	if !parseHeader(header, frame) {
+		return nil
+	}
+	frame.RawBytes = make([]byte, frame.FrameLength)
+	copy(frame.RawBytes, header)
+	return frame
+}
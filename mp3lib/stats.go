@@ -0,0 +1,47 @@
+package mp3lib
+
+import "time"
+
+// Stats accumulates statistics about a stream of MP3 frames. The zero value
+// is ready to use.
+type Stats struct {
+	Frames     uint64
+	Bytes      uint64
+	Duration   time.Duration
+	MinBitRate int
+	MaxBitRate int
+	BitRates   map[int]uint64 // Number of frames at each bitrate.
+}
+
+// Add records a single frame.
+func (s *Stats) Add(frame *MP3Frame) {
+	if s.BitRates == nil {
+		s.BitRates = make(map[int]uint64)
+	}
+
+	if s.Frames == 0 || frame.BitRate < s.MinBitRate {
+		s.MinBitRate = frame.BitRate
+	}
+	if s.Frames == 0 || frame.BitRate > s.MaxBitRate {
+		s.MaxBitRate = frame.BitRate
+	}
+
+	s.Frames++
+	s.Bytes += uint64(len(frame.RawBytes))
+	s.Duration += frame.Duration()
+	s.BitRates[frame.BitRate]++
+}
+
+// AverageBitRate returns the average bitrate of the frames in bits per
+// second, calculated from the total size and duration.
+func (s *Stats) AverageBitRate() int {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return int(float64(s.Bytes*8) / s.Duration.Seconds())
+}
+
+// IsVBR returns true if the frames have more than one bitrate.
+func (s *Stats) IsVBR() bool {
+	return s.MinBitRate != s.MaxBitRate
+}
@@ -0,0 +1,224 @@
+package mp3lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Severity indicates how serious a validation issue is.
+type Severity int
+
+const (
+	// SeverityInfo marks a noteworthy but harmless feature of the stream.
+	SeverityInfo Severity = iota
+	// SeverityWarning marks data which players will usually skip over.
+	SeverityWarning
+	// SeverityError marks damaged audio data.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// IssueKind identifies the kind of problem an issue describes.
+type IssueKind int
+
+const (
+	// IssueGarbage marks a run of unrecognised data.
+	IssueGarbage IssueKind = iota
+	// IssueCRCFailure marks a frame which failed its CRC check.
+	IssueCRCFailure
+	// IssueTruncatedFrame marks a frame cut short by the end of the stream.
+	IssueTruncatedFrame
+	// IssueBitrateChange marks the first change of bitrate.
+	IssueBitrateChange
+)
+
+// Issue describes a problem found while validating a stream. Description is
+// a noun phrase, e.g. "a truncated frame".
+type Issue struct {
+	Kind        IssueKind
+	Severity    Severity
+	Offset      int64
+	Description string
+}
+
+// Validate reads the entire stream and returns a list of the issues found:
+// runs of garbage data, frames which fail their CRC check, truncated frames,
+// and the first change of bitrate.
+func Validate(r io.Reader) ([]Issue, error) {
+	var issues []Issue
+	validator := NewValidator(r)
+	for {
+		frame, issue, err := validator.Next()
+		if err != nil {
+			return issues, err
+		}
+		if issue != nil {
+			issues = append(issues, *issue)
+		} else if frame == nil {
+			return issues, nil
+		}
+		ReleaseFrame(frame)
+	}
+}
+
+// Validator reads MP3 frames from a stream, reporting issues as it goes.
+type Validator struct {
+	counter     *countingReader
+	end         int64     // Offset of the end of the last object read.
+	pending     *MP3Frame // Frame found after a run of garbage data.
+	frames      int
+	bitRate     int
+	bitRateSeen bool
+	maxResync   int64
+}
+
+// NewValidator returns a Validator reading from r.
+func NewValidator(r io.Reader) *Validator {
+	return &Validator{counter: &countingReader{stream: r}}
+}
+
+// SetMaxResync limits the number of bytes of unrecognised data the validator
+// will skip in a row while looking for the next frame. Once the limit is
+// exceeded, Next returns ErrResyncLimit. A limit of zero, the default, means
+// no limit.
+func (v *Validator) SetMaxResync(n int64) {
+	v.maxResync = n
+}
+
+// Frames returns the number of frames read so far.
+func (v *Validator) Frames() int {
+	return v.frames
+}
+
+// Next returns the next MP3 frame, skipping ID3 tags. The return values are:
+//
+//   - (frame, nil, nil) for a valid frame.
+//   - (frame, issue, nil) for a frame with an issue - a failed CRC check or a
+//     change of bitrate.
+//   - (nil, issue, nil) for garbage data or a truncated frame. Call again to
+//     continue reading.
+//   - (nil, nil, nil) when the stream has been exhausted.
+//   - (nil, nil, err) if reading from the stream fails.
+func (v *Validator) Next() (*MP3Frame, *Issue, error) {
+	if v.pending != nil {
+		frame := v.pending
+		v.pending = nil
+		return frame, v.checkFrame(frame), nil
+	}
+
+	for {
+		v.counter.mark()
+		obj, err := nextObject(v.counter, v.maxResync)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, v.checkTrailingData(), nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		var length int
+		switch obj := obj.(type) {
+		case *MP3Frame:
+			length = len(obj.RawBytes)
+		case *ID3v1Tag:
+			length = len(obj.RawBytes)
+		case *ID3v2Tag:
+			length = len(obj.RawBytes)
+		case *APEv2Tag:
+			length = len(obj.RawBytes)
+		case *RIFFHeader:
+			length = len(obj.RawBytes)
+		}
+
+		start := v.counter.count - int64(length)
+		gap := start - v.end
+		offset := v.end
+		v.end = v.counter.count
+
+		frame, isFrame := obj.(*MP3Frame)
+		if gap > 0 {
+			if isFrame {
+				v.pending = frame
+			}
+			return nil, &Issue{IssueGarbage, SeverityWarning, offset, fmt.Sprintf("%v bytes of unrecognised data", gap)}, nil
+		}
+
+		if isFrame {
+			return frame, v.checkFrame(frame), nil
+		}
+	}
+}
+
+// checkFrame checks the CRC of a frame which ends at the current offset,
+// then checks for the first change of bitrate. A VBR header frame is
+// ignored for the bitrate check.
+func (v *Validator) checkFrame(frame *MP3Frame) *Issue {
+	offset := v.end - int64(len(frame.RawBytes))
+	isHeader := v.frames < VBRHeaderSearchFrames && IsVBRHeaderFrame(frame)
+	v.frames++
+
+	if !VerifyCRC(frame) {
+		return &Issue{IssueCRCFailure, SeverityError, offset, "a frame which failed its CRC check"}
+	}
+
+	if isHeader {
+		return nil
+	}
+	if v.bitRate == 0 {
+		v.bitRate = frame.BitRate
+	} else if frame.BitRate != v.bitRate && !v.bitRateSeen {
+		v.bitRateSeen = true
+		return &Issue{IssueBitrateChange, SeverityInfo, offset, fmt.Sprintf(
+			"a change of bitrate from %v to %v kbps", v.bitRate/1000, frame.BitRate/1000)}
+	}
+	return nil
+}
+
+// checkTrailingData checks for leftover data after the last object in the
+// stream. If the leftover data begins with a valid frame header, the final
+// frame has been truncated.
+func (v *Validator) checkTrailingData() *Issue {
+	leftover := v.counter.count - v.end
+	if leftover == 0 {
+		return nil
+	}
+	offset := v.end
+	v.end = v.counter.count
+	if ParseHeader(v.counter.head) != nil {
+		return &Issue{IssueTruncatedFrame, SeverityError, offset, "a truncated frame"}
+	}
+	return &Issue{IssueGarbage, SeverityWarning, offset, fmt.Sprintf("%v bytes of unrecognised data", leftover)}
+}
+
+// countingReader wraps a stream and counts the bytes read from it. It also
+// records the first few bytes read after each call to mark() so we can
+// inspect data the parser skipped over.
+type countingReader struct {
+	stream io.Reader
+	count  int64
+	head   []byte
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.stream.Read(buf)
+	r.count += int64(n)
+	if len(r.head) < 4 {
+		r.head = append(r.head, buf[:min(n, 4-len(r.head))]...)
+	}
+	return n, err
+}
+
+func (r *countingReader) mark() {
+	r.head = r.head[:0]
+}
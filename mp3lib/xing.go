@@ -0,0 +1,355 @@
+package mp3lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Flags indicating which optional fields are present in an Xing header.
+const (
+	XingFramesFlag  = 0x0001
+	XingBytesFlag   = 0x0002
+	XingTOCFlag     = 0x0004
+	XingQualityFlag = 0x0008
+)
+
+// XingInfo holds the fields of an Xing or Info VBR header. Fields not
+// present in the header are left as zero values; TOC is nil if the header
+// has no table of contents.
+//
+// Encoders based on LAME, including FFmpeg, extend the header with the name
+// of the encoder and the number of samples of silence it added at the start
+// and end of the stream, which gapless players skip. Encoder is empty if the
+// header has no such extension.
+type XingInfo struct {
+	ID      string // "Xing", or "Info" for a CBR stream.
+	Flags   uint32
+	Frames  uint32
+	Bytes   uint32
+	TOC     []byte
+	Quality uint32
+	Encoder string // E.g. "LAME3.100".
+	Delay   int    // Samples of encoder delay at the start of the stream.
+	Padding int    // Samples of padding at the end of the stream.
+}
+
+// The length of the LAME extension of an Xing header.
+const lameExtensionLen = 36
+
+// ParseXingHeader parses the Xing header in the supplied frame.
+func ParseXingHeader(frame *MP3Frame) (*XingInfo, error) {
+	offset := xingOffset(frame)
+	if offset < 0 {
+		return nil, errors.New("mp3lib: frame is not an Xing header")
+	}
+
+	id := string(frame.RawBytes[offset : offset+4])
+	data := frame.RawBytes[offset+4:]
+	if len(data) < 4 {
+		return nil, errors.New("mp3lib: truncated Xing header")
+	}
+
+	info := &XingInfo{ID: id, Flags: binary.BigEndian.Uint32(data)}
+	data = data[4:]
+
+	readUint32 := func() (uint32, error) {
+		if len(data) < 4 {
+			return 0, errors.New("mp3lib: truncated Xing header")
+		}
+		value := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		return value, nil
+	}
+
+	var err error
+	if info.Flags&XingFramesFlag != 0 {
+		if info.Frames, err = readUint32(); err != nil {
+			return nil, err
+		}
+	}
+	if info.Flags&XingBytesFlag != 0 {
+		if info.Bytes, err = readUint32(); err != nil {
+			return nil, err
+		}
+	}
+	if info.Flags&XingTOCFlag != 0 {
+		if len(data) < 100 {
+			return nil, errors.New("mp3lib: truncated Xing header")
+		}
+		info.TOC = append([]byte(nil), data[:100]...)
+		data = data[100:]
+	}
+	if info.Flags&XingQualityFlag != 0 {
+		if info.Quality, err = readUint32(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The LAME extension starts with a 9-byte encoder name. The delay and
+	// padding are 12-bit values packed into the 3 bytes at offset 21.
+	if len(data) >= lameExtensionLen && isLAMEEncoder(data[:4]) {
+		info.Encoder = strings.TrimRight(string(data[:9]), "\x00 ")
+		info.Delay = int(data[21])<<4 | int(data[22])>>4
+		info.Padding = int(data[22]&0x0F)<<8 | int(data[23])
+	}
+
+	return info, nil
+}
+
+// Returns true if [name] is the start of the name of an encoder which writes
+// the LAME extension.
+func isLAMEEncoder(name []byte) bool {
+	for _, prefix := range []string{"LAME", "Lavc", "Lavf", "L3.9"} {
+		if string(name) == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// NewXingFrame creates an Xing header frame, or an Info header frame if
+// info.ID is "Info", containing the fields of info which its flags mark as
+// present. The frame matches the MPEG version, sampling rate, channel mode,
+// and CRC protection of template, which should be a frame from the stream,
+// and has the lowest bitrate which leaves room for the fields. A protected
+// frame's CRC is filled in, so decoders which check CRCs don't drop it or
+// mistake it for damaged audio. If template is nil, the frame is MPEG-1 at
+// 44.1 kHz, mono, without a CRC.
+func NewXingFrame(template *MP3Frame, info *XingInfo) *MP3Frame {
+	return NewXingFrameSized(template, info, 0)
+}
+
+// NewXingFrameSized is like NewXingFrame, but the frame is also at least
+// length bytes long if any bitrate allows it. A frame built from the same
+// template with length set to the length of another header frame, e.g. one
+// whose space has been reserved in a file, has that length if it doesn't
+// have more fields.
+func NewXingFrameSized(template *MP3Frame, info *XingInfo, length int) *MP3Frame {
+	id := info.ID
+	if id != "Info" {
+		id = "Xing"
+	}
+
+	var fields []byte
+	fields = binary.BigEndian.AppendUint32(fields, info.Flags)
+	if info.Flags&XingFramesFlag != 0 {
+		fields = binary.BigEndian.AppendUint32(fields, info.Frames)
+	}
+	if info.Flags&XingBytesFlag != 0 {
+		fields = binary.BigEndian.AppendUint32(fields, info.Bytes)
+	}
+	if info.Flags&XingTOCFlag != 0 {
+		toc := make([]byte, 100)
+		copy(toc, info.TOC)
+		fields = append(fields, toc...)
+	}
+	if info.Flags&XingQualityFlag != 0 {
+		fields = binary.BigEndian.AppendUint32(fields, info.Quality)
+	}
+
+	// The header is layer III. The fields follow the CRC, if any, and the
+	// side information, whose size depends on the MPEG version and channel
+	// mode.
+	header := xingTemplateHeader(template)
+	frame := &MP3Frame{}
+	for index := byte(1); index < 15; index++ {
+		header[2] = header[2]&0x0F | index<<4
+		parseHeader(header, frame)
+		if frame.FrameLength >= max(length, getSideInfoOffset(frame)+getSideInfoSize(frame)+4+len(fields)) {
+			break
+		}
+	}
+
+	frame.RawBytes = make([]byte, frame.FrameLength)
+	copy(frame.RawBytes, header)
+	offset := getSideInfoOffset(frame) + getSideInfoSize(frame)
+	copy(frame.RawBytes[offset:], id)
+	copy(frame.RawBytes[offset+4:], fields)
+	UpdateCRC(frame)
+
+	return frame
+}
+
+// NewVBRHeaderFrame returns an Xing header frame for a stream of totalFrames
+// audio frames and totalBytes bytes, or an Info header if cbr is true. The
+// frame has the format of template, a frame from the stream, as with
+// NewXingFrame. It records the number of frames, the length of the stream
+// including the header itself, and a table of contents built from toc. The
+// counts are 32-bit fields; a count too large to fit is left out of the
+// header rather than wrapped. The frame is at least length bytes long, as
+// with NewXingFrameSized, so it can fill a space reserved for a header with
+// more fields.
+func NewVBRHeaderFrame(template *MP3Frame, totalFrames, totalBytes uint64, toc *XingTOC, cbr bool, length int) *MP3Frame {
+	info := &XingInfo{ID: "Xing", Flags: XingBytesFlag | XingTOCFlag}
+	if cbr {
+		info.ID = "Info"
+	}
+	if totalFrames <= math.MaxUint32 {
+		info.Flags |= XingFramesFlag
+		info.Frames = uint32(totalFrames)
+	}
+
+	// The header's size depends only on which fields it has, so it's sized
+	// first and then rebuilt with the byte count and table of contents, which
+	// include it. Leaving out the byte count doesn't shrink the rebuilt
+	// header, so the count and table stay correct.
+	headerLen := len(NewXingFrameSized(template, info, length).RawBytes)
+	if streamBytes := uint64(headerLen) + totalBytes; streamBytes <= math.MaxUint32 {
+		info.Bytes = uint32(streamBytes)
+	} else {
+		info.Flags &^= XingBytesFlag
+	}
+	info.TOC = toc.Entries(headerLen)
+	return NewXingFrameSized(template, info, headerLen)
+}
+
+// Returns the 4-byte header of a layer III frame with the MPEG version,
+// sampling rate, channel mode, CRC protection, and flags of template, and the
+// bitrate index left as zero.
+func xingTemplateHeader(template *MP3Frame) []byte {
+	header := []byte{0xFF, 0xFB, 0x00, 0xC0}
+	if template == nil {
+		return header
+	}
+
+	rates := v1_sr
+	switch template.MPEGVersion {
+	case MPEGVersion2:
+		rates = v2_sr
+	case MPEGVersion2_5:
+		rates = v25_sr
+	}
+	rateIndex := -1
+	for i, rate := range rates {
+		if rate == template.SamplingRate {
+			rateIndex = i
+		}
+	}
+	if rateIndex < 0 {
+		return header
+	}
+
+	header[1] = 0xE0 | template.MPEGVersion<<3 | MPEGLayerIII<<1 | 0x01
+	if template.CrcProtection {
+		header[1] &^= 0x01
+	}
+	header[2] = byte(rateIndex) << 2
+	header[3] = template.ChannelMode<<6 | template.Emphasis
+	if template.CopyrightBit {
+		header[3] |= 0x08
+	}
+	if template.OriginalBit {
+		header[3] |= 0x04
+	}
+	return header
+}
+
+// XingTOC records the times and positions of the frames in a stream to build
+// the table of contents of an Xing header. Positions are sampled, so the
+// memory used is bounded however long the stream. The zero value is ready to
+// use.
+type XingTOC struct {
+	points   []tocPoint
+	step     uint64 // Number of frames between points.
+	frames   uint64
+	bytes    uint64
+	duration time.Duration
+}
+
+// The time and position in the stream at which a frame starts.
+type tocPoint struct {
+	time   time.Duration
+	offset uint64
+}
+
+// The most points recorded by an XingTOC before they're thinned out.
+const maxTOCPoints = 2048
+
+// Add records a single frame.
+func (t *XingTOC) Add(frame *MP3Frame) {
+	if t.step == 0 {
+		t.step = 1
+	}
+	if t.frames%t.step == 0 {
+		// When the points are full, every other one is dropped and the step
+		// doubled, which keeps them evenly spaced.
+		if len(t.points) == maxTOCPoints {
+			for i := range maxTOCPoints / 2 {
+				t.points[i] = t.points[2*i]
+			}
+			t.points = t.points[:maxTOCPoints/2]
+			t.step *= 2
+		}
+		if t.frames%t.step == 0 {
+			t.points = append(t.points, tocPoint{t.duration, t.bytes})
+		}
+	}
+	t.frames++
+	t.bytes += uint64(len(frame.RawBytes))
+	t.duration += frame.Duration()
+}
+
+// Entries returns the 100 entries of the table of contents. Entry i is the
+// position in the stream at i percent of its duration, as a fraction of the
+// stream's length in units of 1/256. The stream is taken to start with a
+// header frame of headerLen bytes, which is included in its length.
+func (t *XingTOC) Entries(headerLen int) []byte {
+	toc := make([]byte, 100)
+	total := float64(uint64(headerLen) + t.bytes)
+	if len(t.points) == 0 || t.duration <= 0 {
+		return toc
+	}
+
+	for i := range toc {
+		target := t.duration * time.Duration(i) / 100
+
+		// Interpolate between the last point at or before the target time and
+		// the next point, or the end of the stream.
+		j := sort.Search(len(t.points), func(j int) bool { return t.points[j].time > target }) - 1
+		from := t.points[max(j, 0)]
+		to := tocPoint{t.duration, t.bytes}
+		if j+1 < len(t.points) {
+			to = t.points[j+1]
+		}
+		position := float64(from.offset)
+		if to.time > from.time {
+			position += float64(to.offset-from.offset) * float64(target-from.time) / float64(to.time-from.time)
+		}
+
+		toc[i] = byte(min((float64(headerLen)+position)*256/total, 255))
+	}
+	return toc
+}
+
+// SeekOffset returns the approximate byte offset of the audio playing at
+// time target in a file of fileSize bytes and the given total duration. The
+// offset is interpolated from the Xing table of contents, which maps each
+// percentage of the duration to a fraction of the file in units of 1/256.
+// If xing is nil or has no table of contents, the offset is estimated
+// assuming a constant bitrate.
+func SeekOffset(xing *XingInfo, duration, target time.Duration, fileSize int64) int64 {
+	if duration <= 0 || target <= 0 {
+		return 0
+	}
+
+	percent := float64(target) / float64(duration) * 100
+	percent = min(max(percent, 0), 100)
+
+	if xing == nil || len(xing.TOC) < 100 {
+		return int64(percent / 100 * float64(fileSize))
+	}
+
+	index := min(int(percent), 99)
+	lower := float64(xing.TOC[index])
+	upper := 256.0
+	if index < 99 {
+		upper = float64(xing.TOC[index+1])
+	}
+
+	fraction := lower + (upper-lower)*(percent-float64(index))
+	return int64(fraction / 256 * float64(fileSize))
+}
@@ -0,0 +1,350 @@
+package mp3lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Returns the name of an MPEG version for test names.
+func versionName(version byte) string {
+	switch version {
+	case MPEGVersion1:
+		return "MPEG-1"
+	case MPEGVersion2:
+		return "MPEG-2"
+	}
+	return "MPEG-2.5"
+}
+
+// The lowest bitrate frames, 32 kbps for MPEG-1 and 8 kbps for MPEG-2 and
+// 2.5, are too short to hold an Xing header with a table of contents, so the
+// header has to use a higher bitrate than the stream.
+func TestVBRHeaderFromLowBitRateFrame(t *testing.T) {
+	for _, version := range []byte{MPEGVersion1, MPEGVersion2, MPEGVersion2_5} {
+		for rateIndex := byte(0); rateIndex < 3; rateIndex++ {
+			for _, mode := range []byte{Stereo, Mono} {
+				raw := testLayerIIIFrame(t, version, 1, rateIndex, mode)
+				template := ParseHeader(raw)
+				template.RawBytes = raw
+				name := fmt.Sprintf("%v/%v/mode%v", versionName(version), template.SamplingRate, mode)
+
+				t.Run(name, func(t *testing.T) {
+					var toc XingTOC
+					for range 100 {
+						toc.Add(template)
+					}
+					header := NewVBRHeaderFrame(template, 100, uint64(100*len(raw)), &toc, false, 0)
+					checkXingFrame(t, template, header)
+
+					reparsed := NextFrame(bytes.NewReader(header.RawBytes))
+					if reparsed == nil || !bytes.Equal(reparsed.RawBytes, header.RawBytes) {
+						t.Fatalf("header frame doesn't reparse")
+					}
+					info, err := ParseXingHeader(reparsed)
+					if err != nil {
+						t.Fatal(err)
+					}
+					wantFlags := uint32(XingFramesFlag | XingBytesFlag | XingTOCFlag)
+					if info.ID != "Xing" || info.Flags != wantFlags {
+						t.Errorf("ID %q, flags %#x; want \"Xing\", %#x", info.ID, info.Flags, wantFlags)
+					}
+					if info.Frames != 100 || info.Bytes != uint32(100*len(raw)+len(header.RawBytes)) {
+						t.Errorf("frames %v, bytes %v; want 100, %v", info.Frames, info.Bytes, 100*len(raw)+len(header.RawBytes))
+					}
+					if len(info.TOC) != 100 || !slices.IsSorted(info.TOC) || info.TOC[99] <= info.TOC[0] {
+						t.Errorf("bad table of contents %v", info.TOC)
+					}
+
+					// The header written over a reserved slot must fit it exactly.
+					reserved := NewVBRHeaderFrame(template, 0, 0, &XingTOC{}, false, 0)
+					if len(reserved.RawBytes) != len(header.RawBytes) {
+						t.Errorf("reserved %v bytes, filled %v", len(reserved.RawBytes), len(header.RawBytes))
+					}
+				})
+
+				t.Run(name+"/all-fields", func(t *testing.T) {
+					info := &XingInfo{
+						ID:      "Info",
+						Flags:   XingFramesFlag | XingBytesFlag | XingTOCFlag | XingQualityFlag,
+						Frames:  1,
+						Bytes:   2,
+						TOC:     bytes.Repeat([]byte{7}, 100),
+						Quality: 3,
+					}
+					header := NewXingFrame(template, info)
+					checkXingFrame(t, template, header)
+					if need := xingOffset(header) + 4 + 4 + 4 + 4 + 100 + 4; header.FrameLength < need {
+						t.Errorf("frame length %v, need %v", header.FrameLength, need)
+					}
+				})
+			}
+		}
+	}
+}
+
+// Checks that [header] is a well-formed Xing header frame in the format of
+// [template], with a valid CRC if the template has one, long enough to hold
+// its payload.
+func checkXingFrame(t *testing.T, template, header *MP3Frame) {
+	t.Helper()
+	if len(header.RawBytes) != header.FrameLength {
+		t.Fatalf("%v bytes, frame length %v", len(header.RawBytes), header.FrameLength)
+	}
+	parsed := ParseHeader(header.RawBytes)
+	if parsed == nil || parsed.FrameLength != header.FrameLength {
+		t.Fatalf("frame header % x doesn't parse to the frame's length", header.RawBytes[:4])
+	}
+	if parsed.MPEGVersion != template.MPEGVersion || parsed.MPEGLayer != MPEGLayerIII ||
+		parsed.SamplingRate != template.SamplingRate || parsed.ChannelMode != template.ChannelMode {
+		t.Errorf("header format %+v doesn't match template %+v", parsed, template)
+	}
+	if parsed.CrcProtection != template.CrcProtection {
+		t.Errorf("header frame CRC protection %v, want %v", parsed.CrcProtection, template.CrcProtection)
+	}
+	if parsed.CrcProtection {
+		crc, ok := FrameCRC(header)
+		if stored := binary.BigEndian.Uint16(header.RawBytes[4:6]); !ok || crc != stored {
+			t.Errorf("stored CRC %#04x, want %#04x", stored, crc)
+		}
+	}
+
+	want := getSideInfoOffset(template) + getSideInfoSize(template)
+	if offset := xingOffset(header); offset != want {
+		t.Errorf("Xing ID at offset %v, want %v", offset, want)
+	}
+	if need := want + 4 + 4 + 4 + 4 + 100; header.FrameLength < need {
+		t.Errorf("frame length %v, need %v", header.FrameLength, need)
+	}
+}
+
+// A header built from a CRC-protected frame is protected too, with a valid
+// CRC, and reads back like an unprotected one.
+func TestXingFrameCRC(t *testing.T) {
+	for _, version := range []byte{MPEGVersion1, MPEGVersion2, MPEGVersion2_5} {
+		for _, mode := range []byte{Stereo, Mono} {
+			raw := testLayerIIIFrame(t, version, 5, 0, mode)
+			raw[1] &^= 0x01
+			template := ParseHeader(raw)
+			template.RawBytes = raw
+			UpdateCRC(template)
+
+			t.Run(fmt.Sprintf("%v/mode%v", versionName(version), mode), func(t *testing.T) {
+				var toc XingTOC
+				toc.Add(template)
+				header := NewVBRHeaderFrame(template, 1, uint64(len(raw)), &toc, true, 0)
+				checkXingFrame(t, template, header)
+
+				reserved := NewVBRHeaderFrame(template, 0, 0, &XingTOC{}, false, 0)
+				if len(reserved.RawBytes) != len(header.RawBytes) {
+					t.Errorf("reserved %v bytes, filled %v", len(reserved.RawBytes), len(header.RawBytes))
+				}
+
+				info, err := ParseXingHeader(NextFrame(bytes.NewReader(header.RawBytes)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.ID != "Info" || info.Frames != 1 {
+					t.Errorf("got %v header with %v frames, want Info with 1", info.ID, info.Frames)
+				}
+			})
+		}
+	}
+}
+
+// A merge of 8 and 16 kbps MPEG-2 mono frames, typical of speech, starts
+// with a header which holds all its fields and is skipped when the output is
+// read back.
+func TestMergeLowBitRate(t *testing.T) {
+	slow := testLayerIIIFrame(t, MPEGVersion2, 1, 0, Mono)
+	fast := testLayerIIIFrame(t, MPEGVersion2, 2, 0, Mono)
+	input := writeTestInput(t, "speech.mp3", 500, slow, fast)
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := Merge(context.Background(), []string{input}, file, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bytes.NewReader(data)
+	header := NextFrame(reader)
+	checkXingFrame(t, ParseHeader(slow), header)
+	xing, err := ParseXingHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xing.ID != "Xing" || xing.Frames != 1000 || int(xing.Bytes) != len(data) || len(xing.TOC) != 100 {
+		t.Errorf("got %v header with %v frames, %v bytes, and %v TOC entries, want Xing with 1000, %v, and 100",
+			xing.ID, xing.Frames, xing.Bytes, len(xing.TOC), len(data))
+	}
+
+	var frames int
+	for frame := NextFrame(reader); frame != nil; frame = NextFrame(reader) {
+		if IsVBRHeaderFrame(frame) {
+			t.Fatalf("audio frame %v is a VBR header", frames)
+		}
+		frames++
+	}
+	if frames != 1000 {
+		t.Errorf("read %v frames after the header, want 1000", frames)
+	}
+}
+
+// The Xing header follows the side information, which is 32 or 17 bytes in
+// MPEG-1 frames and 17 or 9 bytes in MPEG-2 and 2.5 frames, for stereo
+// modes and mono.
+func TestXingOffset(t *testing.T) {
+	tests := []struct {
+		version byte
+		mode    byte
+		want    int
+	}{
+		{MPEGVersion1, Stereo, 36},
+		{MPEGVersion1, JointStereo, 36},
+		{MPEGVersion1, DualChannel, 36},
+		{MPEGVersion1, Mono, 21},
+		{MPEGVersion2, Stereo, 21},
+		{MPEGVersion2, JointStereo, 21},
+		{MPEGVersion2, DualChannel, 21},
+		{MPEGVersion2, Mono, 13},
+		{MPEGVersion2_5, Stereo, 21},
+		{MPEGVersion2_5, JointStereo, 21},
+		{MPEGVersion2_5, DualChannel, 21},
+		{MPEGVersion2_5, Mono, 13},
+	}
+
+	for _, test := range tests {
+		for rateIndex := byte(0); rateIndex < 3; rateIndex++ {
+			raw := testLayerIIIFrame(t, test.version, 9, rateIndex, test.mode)
+			frame := ParseHeader(raw)
+			name := fmt.Sprintf("%v/%v/mode%v", versionName(test.version), frame.SamplingRate, test.mode)
+
+			t.Run(name, func(t *testing.T) {
+				frame.RawBytes = raw
+				if offset := xingOffset(frame); offset != -1 {
+					t.Errorf("frame without a header: offset %v, want -1", offset)
+				}
+
+				for _, id := range []string{"Xing", "Info"} {
+					frame.RawBytes = bytes.Clone(raw)
+					copy(frame.RawBytes[test.want:], id)
+					if offset := xingOffset(frame); offset != test.want {
+						t.Errorf("%v: offset %v, want %v", id, offset, test.want)
+					}
+
+					// A header cut short inside its ID isn't found.
+					frame.RawBytes = frame.RawBytes[:test.want+3]
+					if offset := xingOffset(frame); offset != -1 {
+						t.Errorf("%v truncated: offset %v, want -1", id, offset)
+					}
+				}
+
+				// Some encoders put the header after the CRC.
+				crc := ParseHeader(raw)
+				crc.CrcProtection = true
+				crc.RawBytes = bytes.Clone(raw)
+				crc.RawBytes[1] &^= 0x01
+				copy(crc.RawBytes[test.want+2:], "Xing")
+				if offset := xingOffset(crc); offset != test.want+2 {
+					t.Errorf("after the CRC: offset %v, want %v", offset, test.want+2)
+				}
+			})
+		}
+	}
+}
+
+// An MPEG-2 or 2.5 header written at the MPEG-1 offset, or the reverse, is
+// still found.
+func TestXingOffsetMisplaced(t *testing.T) {
+	tests := []struct {
+		version byte
+		mode    byte
+		at      int
+	}{
+		{MPEGVersion2, Stereo, 36},
+		{MPEGVersion2, Mono, 21},
+		{MPEGVersion2, Mono, 36},
+		{MPEGVersion2_5, Mono, 36},
+		{MPEGVersion1, Stereo, 21},
+		{MPEGVersion1, Mono, 13},
+	}
+
+	for _, test := range tests {
+		raw := testLayerIIIFrame(t, test.version, 9, 0, test.mode)
+		copy(raw[test.at:], "Xing")
+		frame := ParseHeader(raw)
+		frame.RawBytes = raw
+		if offset := xingOffset(frame); offset != test.at {
+			t.Errorf("%v mode %v: offset %v, want %v", versionName(test.version), test.mode, offset, test.at)
+		}
+	}
+}
+
+// Every field written by NewXingFrame is read back by ParseXingHeader, in
+// each MPEG version and channel mode.
+func TestXingRoundTrip(t *testing.T) {
+	toc := make([]byte, 100)
+	for i := range toc {
+		toc[i] = byte(i * 255 / 99)
+	}
+
+	for _, version := range []byte{MPEGVersion1, MPEGVersion2, MPEGVersion2_5} {
+		for _, mode := range []byte{Stereo, JointStereo, DualChannel, Mono} {
+			for rateIndex := byte(0); rateIndex < 3; rateIndex++ {
+				template := ParseHeader(testLayerIIIFrame(t, version, 1, rateIndex, mode))
+				want := &XingInfo{
+					ID:      "Xing",
+					Flags:   XingFramesFlag | XingBytesFlag | XingTOCFlag | XingQualityFlag,
+					Frames:  123456,
+					Bytes:   98765432,
+					TOC:     toc,
+					Quality: 57,
+				}
+				name := fmt.Sprintf("%v/%v/mode%v", versionName(version), template.SamplingRate, mode)
+
+				header := NextFrame(bytes.NewReader(NewXingFrame(template, want).RawBytes))
+				if header == nil {
+					t.Errorf("%v: header frame doesn't reparse", name)
+					continue
+				}
+				if header.MPEGVersion != version || header.ChannelMode != mode || header.SamplingRate != template.SamplingRate {
+					t.Errorf("%v: header is %v mode %v at %v Hz", name,
+						versionName(header.MPEGVersion), header.ChannelMode, header.SamplingRate)
+				}
+				if offset := xingOffset(header); offset != 4+getSideInfoSize(template) {
+					t.Errorf("%v: offset %v, want %v", name, offset, 4+getSideInfoSize(template))
+				}
+
+				got, err := ParseXingHeader(header)
+				if err != nil {
+					t.Errorf("%v: %v", name, err)
+					continue
+				}
+				if got.ID != want.ID || got.Flags != want.Flags || got.Frames != want.Frames ||
+					got.Bytes != want.Bytes || got.Quality != want.Quality || !bytes.Equal(got.TOC, want.TOC) {
+					t.Errorf("%v: got %+v, want %+v", name, got, want)
+				}
+
+				// Only the flagged fields are written.
+				want.ID, want.Flags = "Info", XingFramesFlag
+				got, err = ParseXingHeader(NewXingFrame(template, want))
+				if err != nil || got.ID != "Info" || got.Flags != XingFramesFlag || got.Frames != want.Frames ||
+					got.Bytes != 0 || got.TOC != nil || got.Quality != 0 {
+					t.Errorf("%v: frames only: got %+v, %v", name, got, err)
+				}
+			}
+		}
+	}
+}
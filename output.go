@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Default size of the buffers for reading input files and writing the output file. Frames are
+// only a few hundred bytes each, so writing them individually would cost a system call per
+// frame, which is slow on network filesystems.
+const defaultBufferSize = 4 << 20
+
+// Size of the chunks of output passed to the writing goroutine, and the number of jobs which
+// can be queued for it before the merge has to wait.
+const (
+	chunkSize  = 256 << 10
+	queueDepth = 16
+)
+
+// Pool of chunks for the writing goroutine. Chunks are returned once they've been written.
+var chunkPool = sync.Pool{
+	New: func() any {
+		chunk := make([]byte, 0, chunkSize)
+		return &chunk
+	},
+}
+
+// Writes a merge's output file in order: ID3v2 tag, VBR header, audio frames. A VBR header, and
+// a tag with chapters, can only be built once all the frames have been written, so space is
+// reserved for them at the start of the file and they're written into it once the file is
+// closed.
+//
+// If the output is being hashed, no space is reserved, as the hash would miss anything written
+// into it later; a header or tag is instead inserted by Finish in a single pass over the file
+// which hashes it again. Finish does the same for a VBR header with no space reserved for it,
+// e.g. when the output has a variable bitrate that wasn't predicted. Output which doesn't need
+// a header or a late tag is never rewritten.
+//
+// Writes are queued and performed by a separate goroutine, so the merge can read the next
+// frames while earlier ones are being written. An error from the goroutine is returned by the
+// next call to Write or CopyRange, or by Close.
+type outputWriter struct {
+	path        string        // Path of the file being written.
+	tmpdir      string        // Directory for the temporary file used by Finish.
+	file        *os.File      // The file being written.
+	buffer      *bufio.Writer // Buffers writes to the file.
+	hasher      hash.Hash     // Hashes the file's content if not nil.
+	writer      io.Writer     // Writes to the buffer and the hasher.
+	tagSize     int64         // Size of the ID3v2 tag at the start of the file, or the space reserved for it.
+	vbrSize     int64         // Size of the space reserved for a VBR header following the tag.
+	vbrReserved bool          // ReserveVBRHeader was called.
+	lateTag     []byte        // Tag for Finish to insert at the start of the file.
+	lateVBR     []byte        // VBR header for Finish to insert following the tag.
+	chunk       *[]byte       // Chunk being filled by Write.
+	jobs        chan writeJob // Queue of jobs for the writing goroutine.
+	done        chan struct{} // Closed when the writing goroutine exits.
+	mutex       sync.Mutex    // Guards err.
+	err         error         // First error from the writing goroutine.
+}
+
+// A job for the writing goroutine: write a chunk, copy a range from a file, or close a file.
+type writeJob struct {
+	chunk  *[]byte
+	src    *os.File
+	offset int64
+	length int64
+	close  bool
+}
+
+// Create the file at [path], with a write buffer of [bufferSize] bytes, or the default size if
+// zero, and start the writing goroutine. Finish's temporary file goes in [tmpdir], or the
+// file's own directory if [tmpdir] is empty. If [hasher] isn't nil, it's fed the file's content
+// as it's written.
+func newOutputWriter(path, tmpdir string, bufferSize int, hasher hash.Hash) (*outputWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	buffer := bufio.NewWriterSize(file, bufferSize)
+
+	w := &outputWriter{
+		path:   path,
+		tmpdir: tmpdir,
+		file:   file,
+		buffer: buffer,
+		hasher: hasher,
+		writer: buffer,
+		jobs:   make(chan writeJob, queueDepth),
+		done:   make(chan struct{}),
+	}
+	if hasher != nil {
+		w.writer = io.MultiWriter(buffer, hasher)
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Run the queued jobs in order until the queue is closed. After an error, the remaining jobs
+// are skipped but their chunks and files are still released.
+func (w *outputWriter) run() {
+	defer close(w.done)
+	for job := range w.jobs {
+		if w.failed() == nil {
+			var err error
+			if job.chunk != nil {
+				_, err = w.writer.Write(*job.chunk)
+			} else if !job.close {
+				err = w.copyRange(job.src, job.offset, job.length)
+			}
+			if err != nil {
+				w.fail(err)
+			}
+		}
+		if job.chunk != nil {
+			*job.chunk = (*job.chunk)[:0]
+			chunkPool.Put(job.chunk)
+		}
+		if job.close {
+			job.src.Close()
+		}
+	}
+}
+
+// Record an error from the writing goroutine. Only the first is kept.
+func (w *outputWriter) fail(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Returns the first error from the writing goroutine, if any.
+func (w *outputWriter) failed() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.err
+}
+
+// Write the ID3v2 tag from the file at [tagpath], if it has one. Must be called before any
+// frames are written.
+func (w *outputWriter) WriteID3v2Tag(tagpath string) error {
+	tagFile, err := os.Open(tagpath)
+	if err != nil {
+		return err
+	}
+
+	id3tag, err := mp3lib.NextID3v2TagErr(tagFile)
+	tagFile.Close()
+
+	if isEndOfStream(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot read the ID3 tag from '%v': %w", tagpath, err)
+	}
+
+	return w.WriteTag(id3tag)
+}
+
+// Write an ID3v2 tag. Must be called before any frames are written.
+func (w *outputWriter) WriteTag(tag *mp3lib.ID3v2Tag) error {
+	n, err := w.Write(tag.RawBytes)
+	w.tagSize += int64(n)
+	return err
+}
+
+// Reserve [size] bytes at the start of the file for an ID3v2 tag which is written with FillTag
+// once the file is closed. Must be called before anything else is written. If the file is being
+// hashed, no space is written and the tag is inserted by Finish instead.
+func (w *outputWriter) ReserveTag(size int) error {
+	if w.hasher != nil {
+		return nil
+	}
+	n, err := w.Write(make([]byte, size))
+	w.tagSize += int64(n)
+	return err
+}
+
+// Reserve space for a VBR header built from [template], a frame from the stream, which is
+// written with FillVBRHeader once the file is closed. The space is large enough for a header
+// with every field mp3lib.NewVBRHeaderFrame can give it. Must be called after any tag is written
+// and before any frames are. If the file is being hashed, no space is written and the header is
+// inserted by Finish instead.
+func (w *outputWriter) ReserveVBRHeader(template *mp3lib.MP3Frame) error {
+	w.vbrReserved = true
+	if w.hasher != nil {
+		return nil
+	}
+	header := mp3lib.NewVBRHeaderFrame(template, 0, 0, &mp3lib.XingTOC{}, false, 0)
+	n, err := w.Write(make([]byte, len(header.RawBytes)))
+	w.vbrSize += int64(n)
+	return err
+}
+
+// Returns true if ReserveVBRHeader was called, so the file needs a header even if its frames
+// turn out to have a constant bitrate.
+func (w *outputWriter) VBRHeaderReserved() bool {
+	return w.vbrReserved
+}
+
+// Write implements io.Writer for the audio frames and anything following them. The data is
+// copied, so the caller can reuse [data] as soon as Write returns.
+func (w *outputWriter) Write(data []byte) (int, error) {
+	if err := w.failed(); err != nil {
+		return 0, err
+	}
+
+	written := len(data)
+	for len(data) > 0 {
+		if w.chunk == nil {
+			w.chunk = chunkPool.Get().(*[]byte)
+		}
+		n := min(len(data), chunkSize-len(*w.chunk))
+		*w.chunk = append(*w.chunk, data[:n]...)
+		data = data[n:]
+		if len(*w.chunk) == chunkSize {
+			w.queueChunk()
+		}
+	}
+
+	return written, nil
+}
+
+// Queue the chunk being filled by Write, if it isn't empty.
+func (w *outputWriter) queueChunk() {
+	if w.chunk != nil && len(*w.chunk) > 0 {
+		w.jobs <- writeJob{chunk: w.chunk}
+		w.chunk = nil
+	}
+}
+
+// Wait for the queued jobs to finish, then flush any buffered data and close the file. It's
+// safe to call Close more than once.
+func (w *outputWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	w.queueChunk()
+	close(w.jobs)
+	<-w.done
+
+	err := w.failed()
+	if err == nil {
+		err = w.buffer.Flush()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return err
+}
+
+// Write an Xing VBR header into the space reserved by ReserveVBRHeader, or an Info header if
+// [cbr] is true. If no space was written for it, the header is inserted by Finish. Must be
+// called after Close. See mp3lib.NewVBRHeaderFrame for the header's contents.
+func (w *outputWriter) FillVBRHeader(template *mp3lib.MP3Frame, totalFrames, totalBytes uint64, toc *mp3lib.XingTOC, cbr bool) error {
+	header := mp3lib.NewVBRHeaderFrame(template, totalFrames, totalBytes, toc, cbr, int(w.vbrSize))
+	if w.vbrSize == 0 {
+		w.lateVBR = header.RawBytes
+		return nil
+	}
+	if int64(len(header.RawBytes)) != w.vbrSize {
+		return fmt.Errorf("the VBR header (%v bytes) doesn't fit the %v bytes reserved for it", len(header.RawBytes), w.vbrSize)
+	}
+	return w.writeAt(w.tagSize, header.RawBytes)
+}
+
+// Write [tag] into the space reserved by ReserveTag, padded to fill it. If no space was written
+// for it, the tag is inserted by Finish. Must be called after Close.
+func (w *outputWriter) FillTag(tag *mp3lib.ID3v2Tag) error {
+	if w.tagSize == 0 {
+		w.lateTag = tag.RawBytes
+		return nil
+	}
+	padded := tag.Padded(int(w.tagSize))
+	if int64(len(padded.RawBytes)) != w.tagSize {
+		return fmt.Errorf("the ID3 tag (%v bytes) doesn't fit the %v bytes reserved for it", len(tag.RawBytes), w.tagSize)
+	}
+	return w.writeAt(0, padded.RawBytes)
+}
+
+// Insert any tag and VBR header passed to FillTag and FillVBRHeader which had no space written
+// for them. Both go in with a single rewrite of the file. Must be called after the Fill methods.
+func (w *outputWriter) Finish() error {
+	if w.lateTag == nil && w.lateVBR == nil {
+		return nil
+	}
+	offset := w.tagSize
+	if w.lateTag != nil {
+		offset = 0
+	}
+	data := append(w.lateTag[:len(w.lateTag):len(w.lateTag)], w.lateVBR...)
+	if err := w.insert(offset, data); err != nil {
+		return err
+	}
+	if w.lateTag != nil {
+		w.tagSize = int64(len(w.lateTag))
+	}
+	if w.lateVBR != nil {
+		w.vbrSize = int64(len(w.lateVBR))
+	}
+	w.lateTag, w.lateVBR = nil, nil
+	return nil
+}
+
+// Returns the offset in the file of the first audio frame, following the ID3v2 tag and VBR
+// header, if any.
+func (w *outputWriter) FramesOffset() int64 {
+	return w.tagSize + w.vbrSize
+}
+
+// Insert a block of bytes into the closed file at [offset]. The file is rewritten via a
+// temporary file in the writer's tmpdir which replaces the original on success. If the tmpdir
+// is empty the temporary file is created in the same directory as the original. The hasher, if
+// any, is reset and fed the new content of the file.
+func (w *outputWriter) insert(offset int64, data []byte) error {
+	tmpdir := w.tmpdir
+	if tmpdir == "" {
+		tmpdir = filepath.Dir(w.path)
+	}
+
+	outputFile, err := os.CreateTemp(tmpdir, filepath.Base(w.path)+".*.mp3cat.tmp")
+	if err != nil {
+		return err
+	}
+	tmppath := outputFile.Name()
+
+	inputFile, err := os.Open(w.path)
+	if err != nil {
+		outputFile.Close()
+		os.Remove(tmppath)
+		return err
+	}
+
+	// CreateTemp uses restrictive permissions; match the original file instead.
+	info, err := inputFile.Stat()
+	if err == nil {
+		err = outputFile.Chmod(info.Mode().Perm())
+	}
+
+	// The rewritten file replaces the original, so the checksum starts again from scratch.
+	var output io.Writer = outputFile
+	if w.hasher != nil {
+		w.hasher.Reset()
+		output = io.MultiWriter(outputFile, w.hasher)
+	}
+
+	if err == nil {
+		_, err = io.CopyN(output, inputFile, offset)
+	}
+	if err == nil {
+		_, err = output.Write(data)
+	}
+	if err == nil {
+		_, err = io.Copy(output, inputFile)
+	}
+
+	inputFile.Close()
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmppath)
+		return err
+	}
+
+	return moveFile(tmppath, w.path)
+}
+
+// Overwrite the bytes of the closed file at [offset] with [data].
+func (w *outputWriter) writeAt(offset int64, data []byte) error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, offset)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Queue a copy of [length] bytes from [src], starting at [offset], to the end of the file. The
+// copy goes directly between the two files, which lets the kernel move the data without
+// copying it through userspace where the platform supports it, e.g. with copy_file_range on
+// Linux. If the file is being hashed, the range is read through the hasher instead.
+func (w *outputWriter) CopyRange(src *os.File, offset, length int64) error {
+	if err := w.failed(); err != nil {
+		return err
+	}
+	w.queueChunk()
+	w.jobs <- writeJob{src: src, offset: offset, length: length}
+	return nil
+}
+
+// Queue closing [src] once any earlier copies from it have finished.
+func (w *outputWriter) CloseAfterCopy(src *os.File) {
+	w.jobs <- writeJob{src: src, close: true}
+}
+
+// Copy a range from [src] to the end of the file. Runs on the writing goroutine.
+func (w *outputWriter) copyRange(src *os.File, offset, length int64) error {
+	if err := w.buffer.Flush(); err != nil {
+		return err
+	}
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	var n int64
+	var err error
+	if w.hasher != nil {
+		n, err = io.Copy(io.MultiWriter(w.file, w.hasher), io.LimitReader(src, length))
+	} else {
+		n, err = w.file.ReadFrom(io.LimitReader(src, length))
+	}
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A run of frames which are adjacent in an input file and copied to the output unmodified. The
+// whole run is written with a single CopyRange call when it ends.
+type frameSpan struct {
+	src   *os.File // A second handle on the input file, or nil if spans aren't in use.
+	start int64    // Offset of the run's first byte in the input file.
+	end   int64    // Offset of the byte following the run.
+}
+
+// Add the frame at [offset] to the run. If the frame doesn't directly follow the run, the run
+// is written to [w] first and a new run started.
+func (s *frameSpan) add(w *outputWriter, offset int64, length int) error {
+	if offset != s.end {
+		if err := s.flush(w); err != nil {
+			return err
+		}
+		s.start = offset
+	}
+	s.end = offset + int64(length)
+	return nil
+}
+
+// Write the run to [w] and reset it.
+func (s *frameSpan) flush(w *outputWriter) error {
+	if s.src == nil || s.end == s.start {
+		return nil
+	}
+	err := w.CopyRange(s.src, s.start, s.end-s.start)
+	s.start = s.end
+	return err
+}
+
+// Close the second handle on the input file once [w] has finished copying from it.
+func (s *frameSpan) close(w *outputWriter) {
+	if s.src != nil {
+		w.CloseAfterCopy(s.src)
+	}
+}
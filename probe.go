@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+var probeHelptext = fmt.Sprintf(`
+Usage: %s probe [files]
+
+  Describes the structure of MP3 files without changing them: the tags
+  they contain and any comments in them, any VBR header, the parameters of
+  the first frame, the duration and bitrate, and the amount of unrecognised
+  data skipped.
+
+  With --json, prints a JSON array with an object for each file. Fields are
+  only ever added to this format, never renamed or removed.
+
+Arguments:
+  [files]                 List of files to probe.
+
+Options:
+  --workers <n>           Probe up to n files at once. Defaults to 1.
+
+Flags:
+  -h, --help              Display this help text and exit.
+  --json                  Print the results as JSON.
+`, filepath.Base(os.Args[0]))
+
+// The result of probing a file. This is the stable schema of 'probe --json'.
+type probeResult struct {
+	Path         string       `json:"path"`
+	Size         int64        `json:"size"`
+	Tags         []probeTag   `json:"tags"`
+	VBRHeader    *probeHeader `json:"vbr_header"`
+	FirstFrame   *probeFrame  `json:"first_frame"`
+	Frames       uint64       `json:"frames"`
+	AudioBytes   uint64       `json:"audio_bytes"`
+	Duration     float64      `json:"duration"`
+	MinBitrate   int          `json:"min_bitrate"`
+	MaxBitrate   int          `json:"max_bitrate"`
+	Bitrate      int          `json:"bitrate"`
+	VBR          bool         `json:"vbr"`
+	GarbageBytes int64        `json:"garbage_bytes"`
+	Truncated    bool         `json:"truncated"`
+
+	duration time.Duration
+}
+
+// A tag or container header found in a probed file.
+type probeTag struct {
+	Type    string `json:"type"`
+	Offset  int64  `json:"offset"`
+	Size    int    `json:"size"`
+	Version string `json:"version,omitempty"`
+
+	// The comments (COMM frames) of an ID3v2 tag.
+	Comments []probeComment `json:"comments,omitempty"`
+}
+
+// A comment in a probed file's ID3v2 tag.
+type probeComment struct {
+	Language    string `json:"language"`
+	Description string `json:"description"`
+	Text        string `json:"text"`
+}
+
+// The VBR header of a probed file.
+type probeHeader struct {
+	Type    string `json:"type"`
+	Offset  int64  `json:"offset"`
+	Frames  uint32 `json:"frames"`
+	Bytes   uint32 `json:"bytes"`
+	TOC     bool   `json:"toc"`
+	Quality uint32 `json:"quality"`
+}
+
+// The parameters of the first audio frame of a probed file.
+type probeFrame struct {
+	Offset      int64  `json:"offset"`
+	Version     string `json:"version"`
+	Layer       string `json:"layer"`
+	Bitrate     int    `json:"bitrate"`
+	SampleRate  int    `json:"sample_rate"`
+	ChannelMode string `json:"channel_mode"`
+	CRC         bool   `json:"crc"`
+	Emphasis    int    `json:"emphasis"`
+	Copyright   bool   `json:"copyright"`
+	Original    bool   `json:"original"`
+	Private     bool   `json:"private"`
+}
+
+// Run the 'probe' command. Returns the process exit code.
+func runProbe(ctx context.Context, parser *argo.ArgParser) int {
+	if len(parser.Args) == 0 {
+		printErrorf(errUsage, "you must specify files to probe")
+		return 1
+	}
+
+	workers, err := parseWorkers(parser)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	// Results are collected in order, as the JSON output is a single array.
+	type probeOutcome struct {
+		result *probeResult
+		err    error
+	}
+	exitCode := 0
+	results := []*probeResult{}
+	work := func(ctx context.Context, i int) probeOutcome {
+		result, err := probeFile(ctx, fixLongPath(parser.Args[i]))
+		return probeOutcome{result, err}
+	}
+	runWorkers(ctx, len(parser.Args), workers, work, func(i int, outcome probeOutcome) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		if outcome.err != nil {
+			printError(outcome.err)
+			exitCode = 1
+			return true
+		}
+		outcome.result.Path = parser.Args[i]
+		results = append(results, outcome.result)
+		return true
+	})
+	if ctx.Err() != nil {
+		printError(ctx.Err())
+		return 1
+	}
+
+	if parser.Found("json") {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return exitCode
+	}
+
+	for _, result := range results {
+		printProbeResult(result)
+	}
+	return exitCode
+}
+
+// Counts the bytes read from a stream, so the offsets of the objects read from it are known.
+type offsetReader struct {
+	reader io.Reader
+	offset int64
+}
+
+func (r *offsetReader) Read(buf []byte) (int, error) {
+	n, err := r.reader.Read(buf)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Read the file at [path] object by object and describe its contents.
+func probeFile(ctx context.Context, path string) (*probeResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := &probeResult{Tags: []probeTag{}}
+	if info, err := file.Stat(); err == nil {
+		result.Size = info.Size()
+	}
+
+	stream := &offsetReader{reader: bufio.NewReader(mp3lib.NewContextReader(ctx, file))}
+	var stats mp3lib.Stats
+	var framesRead int
+
+	for {
+		before := stream.offset
+		obj, err := mp3lib.NextObjectErr(stream)
+		if errors.Is(err, mp3lib.ErrTruncatedFrame) {
+			result.Truncated = true
+			break
+		} else if isEndOfStream(err) {
+			result.GarbageBytes += stream.offset - before
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var size int
+		switch obj := obj.(type) {
+		case *mp3lib.MP3Frame:
+			size = len(obj.RawBytes)
+		case *mp3lib.ID3v1Tag:
+			size = len(obj.RawBytes)
+		case *mp3lib.ID3v2Tag:
+			size = len(obj.RawBytes)
+		case *mp3lib.APEv2Tag:
+			size = len(obj.RawBytes)
+		case *mp3lib.RIFFHeader:
+			size = len(obj.RawBytes)
+		}
+		offset := stream.offset - int64(size)
+		result.GarbageBytes += offset - before
+
+		switch obj := obj.(type) {
+		case *mp3lib.MP3Frame:
+			framesRead++
+			if framesRead <= mp3lib.VBRHeaderSearchFrames && result.VBRHeader == nil && mp3lib.IsVBRHeaderFrame(obj) {
+				result.VBRHeader = describeVBRHeader(obj, offset)
+			} else {
+				if result.FirstFrame == nil {
+					result.FirstFrame = describeFrame(obj, offset)
+				}
+				stats.Add(obj)
+			}
+			mp3lib.ReleaseFrame(obj)
+		case *mp3lib.ID3v1Tag:
+			result.Tags = append(result.Tags, probeTag{Type: "ID3v1", Offset: offset, Size: size})
+		case *mp3lib.ID3v2Tag:
+			version := fmt.Sprintf("2.%v", obj.Version())
+			tag := probeTag{Type: "ID3v2", Offset: offset, Size: size, Version: version}
+			for _, comment := range obj.Comments() {
+				tag.Comments = append(tag.Comments, probeComment(comment))
+			}
+			result.Tags = append(result.Tags, tag)
+		case *mp3lib.APEv2Tag:
+			version := fmt.Sprintf("%v", obj.Version/1000)
+			result.Tags = append(result.Tags, probeTag{Type: "APEv2", Offset: offset, Size: size, Version: version})
+		case *mp3lib.RIFFHeader:
+			result.Tags = append(result.Tags, probeTag{Type: "RIFF", Offset: offset, Size: size})
+		}
+	}
+
+	result.Frames = stats.Frames
+	result.AudioBytes = stats.Bytes
+	result.duration = stats.Duration
+	result.Duration = stats.Duration.Seconds()
+	result.MinBitrate = stats.MinBitRate / 1000
+	result.MaxBitrate = stats.MaxBitRate / 1000
+	result.Bitrate = stats.AverageBitRate() / 1000
+	result.VBR = stats.IsVBR()
+
+	return result, nil
+}
+
+// Describe a VBR header frame at [offset].
+func describeVBRHeader(frame *mp3lib.MP3Frame, offset int64) *probeHeader {
+	if !mp3lib.IsXingHeader(frame) {
+		return &probeHeader{Type: "VBRI", Offset: offset}
+	}
+	header := &probeHeader{Type: "Xing", Offset: offset}
+	if xing, err := mp3lib.ParseXingHeader(frame); err == nil {
+		header.Type = xing.ID
+		header.Frames = xing.Frames
+		header.Bytes = xing.Bytes
+		header.TOC = xing.TOC != nil
+		header.Quality = xing.Quality
+	}
+	return header
+}
+
+// Describe an audio frame at [offset].
+func describeFrame(frame *mp3lib.MP3Frame, offset int64) *probeFrame {
+	return &probeFrame{
+		Offset:      offset,
+		Version:     mpegVersionName(frame),
+		Layer:       layerName(frame.MPEGLayer),
+		Bitrate:     frame.BitRate / 1000,
+		SampleRate:  frame.SamplingRate,
+		ChannelMode: channelModeName(frame.ChannelMode),
+		CRC:         frame.CrcProtection,
+		Emphasis:    int(frame.Emphasis),
+		Copyright:   frame.CopyrightBit,
+		Original:    frame.OriginalBit,
+		Private:     frame.PrivateBit,
+	}
+}
+
+// Returns a channel mode as a string, e.g. 'joint stereo'.
+func channelModeName(mode byte) string {
+	switch mode {
+	case mp3lib.Stereo:
+		return "stereo"
+	case mp3lib.JointStereo:
+		return "joint stereo"
+	case mp3lib.DualChannel:
+		return "dual channel"
+	case mp3lib.Mono:
+		return "mono"
+	}
+	return "unknown"
+}
+
+// Print a probe result as text.
+func printProbeResult(result *probeResult) {
+	fmt.Printf("• %v\n", result.Path)
+	fmt.Printf("  size: %v\n", formatBytes(uint64(result.Size)))
+	for _, tag := range result.Tags {
+		name := tag.Type
+		if tag.Type == "ID3v2" || tag.Type == "APEv2" {
+			name = fmt.Sprintf("%v (version %v)", tag.Type, tag.Version)
+		}
+		fmt.Printf("  tag: %v at offset %v, %v bytes\n", name, tag.Offset, tag.Size)
+		for _, comment := range tag.Comments {
+			fmt.Printf("    comment [%v]", comment.Language)
+			if comment.Description != "" {
+				fmt.Printf(" %q", comment.Description)
+			}
+			fmt.Printf(": %q\n", comment.Text)
+		}
+	}
+	if result.VBRHeader != nil {
+		fmt.Printf("  vbr header: %v at offset %v", result.VBRHeader.Type, result.VBRHeader.Offset)
+		if result.VBRHeader.Frames != 0 {
+			fmt.Printf(", %v frames", result.VBRHeader.Frames)
+		}
+		if result.VBRHeader.Bytes != 0 {
+			fmt.Printf(", %v bytes", result.VBRHeader.Bytes)
+		}
+		fmt.Println()
+	} else if result.VBR && result.FirstFrame != nil && result.FirstFrame.Layer == "III" {
+		fmt.Printf("  vbr header: missing; players may show the wrong duration (see 'mp3cat verify --fix')\n")
+	}
+	if frame := result.FirstFrame; frame != nil {
+		fmt.Printf("  format: MPEG-%v layer %v, %v Hz, %v", frame.Version, frame.Layer, frame.SampleRate, frame.ChannelMode)
+		if frame.CRC {
+			fmt.Print(", CRC-protected")
+		}
+		fmt.Println()
+		fmt.Printf("  header bits: emphasis %v, copyright %v, original %v, private %v\n",
+			emphasisName(byte(frame.Emphasis)), bitState(frame.Copyright),
+			bitState(frame.Original), bitState(frame.Private))
+	}
+	fmt.Printf("  frames: %v\n", result.Frames)
+	fmt.Printf("  duration: %v\n", formatTimestamp(result.duration))
+	if result.VBR {
+		fmt.Printf("  bitrate: %v kbps average, VBR %v-%v kbps\n", result.Bitrate, result.MinBitrate, result.MaxBitrate)
+	} else {
+		fmt.Printf("  bitrate: %v kbps CBR\n", result.MinBitrate)
+	}
+	if result.GarbageBytes > 0 {
+		fmt.Printf("  unrecognised data: %v bytes\n", result.GarbageBytes)
+	}
+	if result.Truncated {
+		fmt.Println("  truncated final frame")
+	}
+}
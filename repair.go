@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmulholl/argo/v4"
+)
+
+var repairHelptext = fmt.Sprintf(`
+Usage: %s repair <file>
+
+  Writes a cleaned copy of an MP3 file, dropping garbage data, truncated
+  frames, and frames which fail their CRC check. Any ID3v2 tag is kept.
+  A fresh VBR header is written if the file has multiple bitrates.
+
+    $ mp3cat repair broken.mp3 -o fixed.mp3
+
+Arguments:
+  <file>                  File to repair.
+
+Options:
+  -o, --out <path>        Output filepath. Defaults to '<file>-repaired.mp3'.
+  -t, --tmpdir <path>     Directory for temporary files. Defaults to the
+                          output file's directory.
+
+Flags:
+  -f, --force             Overwrite an existing output file.
+  -h, --help              Display this help text and exit.
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+  -s, --silent            Silent mode. Output nothing.
+`, filepath.Base(os.Args[0]))
+
+// Run the 'repair' command. Returns the process exit code.
+func runRepair(ctx context.Context, parser *argo.ArgParser) int {
+	setQuiet(parser.Found("quiet"), parser.Found("silent"))
+
+	if len(parser.Args) != 1 {
+		printErrorf(errUsage, "you must specify a single file to repair")
+		return 1
+	}
+
+	inpath := fixLongPath(parser.Args[0])
+	if _, err := os.Stat(inpath); err != nil {
+		printErrorf(errFileNotFound, "the file '%v' does not exist", inpath)
+		return 1
+	}
+
+	outpath := parser.StringValue("out")
+	if outpath == "" {
+		outpath = strings.TrimSuffix(inpath, filepath.Ext(inpath)) + "-repaired.mp3"
+	}
+	outpath = fixLongPath(outpath)
+
+	lock, err := acquireLock(outpath, 0)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	defer lock.release()
+
+	err = merge(ctx, []string{inpath}, &mergeOptions{
+		outpath: outpath,
+		tagpath: inpath,
+		tmpdir:  fixLongPath(parser.StringValue("tmpdir")),
+		force:   parser.Found("force"),
+		repair:  true,
+	})
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	return 0
+}
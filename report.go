@@ -0,0 +1,210 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// The contents of a --report summary of a merge.
+type report struct {
+	Version  string
+	Created  string // Empty with --reproducible.
+	Output   string
+	Duration string
+	Size     string
+	Bitrate  int
+	Header   string
+	Checksum string
+	Inputs   []reportInput
+	Warnings []string
+}
+
+// An input file in a report.
+type reportInput struct {
+	Index    int
+	Path     string
+	Title    string
+	Start    string
+	Duration string
+	Frames   int
+	Bitrate  int
+	Garbage  bool
+}
+
+// Markdown template for reports.
+const markdownReport = `# mp3cat report: {{md .Output}}
+
+Created {{with .Created}}{{.}} {{end}}by mp3cat {{.Version}}.
+
+## Output
+
+| | |
+|---|---|
+| File | {{md .Output}} |
+| Duration | {{.Duration}} |
+| Size | {{.Size}} |
+| Average bitrate | {{.Bitrate}} kbps |
+| VBR header | {{.Header}} |
+{{- if .Checksum}}
+| Checksum | {{md .Checksum}} |
+{{- end}}
+
+## Input files
+
+| # | File | Start | Duration | Frames | Bitrate | Garbage |
+|---:|---|---|---|---:|---:|---|
+{{- range .Inputs}}
+| {{.Index}} | {{md .Path}} | {{.Start}} | {{.Duration}} | {{.Frames}} | {{.Bitrate}} kbps | {{if .Garbage}}yes{{else}}no{{end}} |
+{{- end}}
+
+## Chapters
+
+{{range .Inputs -}}
+- {{.Start}} {{if .Title}}{{md .Title}}{{else}}{{md (base .Path)}}{{end}}
+{{end}}
+## Warnings
+
+{{range .Warnings -}}
+- {{md .}}
+{{else -}}
+None.
+{{end -}}
+`
+
+// HTML template for reports.
+const htmlReport = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mp3cat report: {{.Output}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>mp3cat report: {{.Output}}</h1>
+<p>Created {{with .Created}}{{.}} {{end}}by mp3cat {{.Version}}.</p>
+
+<h2>Output</h2>
+<table>
+<tr><th>File</th><td>{{.Output}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+<tr><th>Size</th><td>{{.Size}}</td></tr>
+<tr><th>Average bitrate</th><td>{{.Bitrate}} kbps</td></tr>
+<tr><th>VBR header</th><td>{{.Header}}</td></tr>
+{{- if .Checksum}}
+<tr><th>Checksum</th><td>{{.Checksum}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Input files</h2>
+<table>
+<tr><th>#</th><th>File</th><th>Start</th><th>Duration</th><th>Frames</th><th>Bitrate</th><th>Garbage</th></tr>
+{{- range .Inputs}}
+<tr><td>{{.Index}}</td><td>{{.Path}}</td><td>{{.Start}}</td><td>{{.Duration}}</td><td>{{.Frames}}</td><td>{{.Bitrate}} kbps</td><td>{{if .Garbage}}yes{{else}}no{{end}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Chapters</h2>
+<ul>
+{{- range .Inputs}}
+<li>{{.Start}} {{if .Title}}{{.Title}}{{else}}{{base .Path}}{{end}}</li>
+{{- end}}
+</ul>
+
+<h2>Warnings</h2>
+{{- if .Warnings}}
+<ul>
+{{- range .Warnings}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- else}}
+<p>None.</p>
+{{- end}}
+</body>
+</html>
+`
+
+// Write a report of the merge to [path], as HTML if the path ends in '.html' or '.htm', or as
+// Markdown otherwise.
+func writeReport(path string, r *report) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		err = renderHTMLReport(file, r)
+	default:
+		err = renderMarkdownReport(file, r)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Write [r] to [w] as Markdown.
+func renderMarkdownReport(w io.Writer, r *report) error {
+	tmpl := template.Must(template.New("report").Funcs(template.FuncMap{
+		"md":   escapeMarkdown,
+		"base": filepath.Base,
+	}).Parse(markdownReport))
+	return tmpl.Execute(w, r)
+}
+
+// Write [r] to [w] as HTML.
+func renderHTMLReport(w io.Writer, r *report) error {
+	tmpl := htmltemplate.Must(htmltemplate.New("report").Funcs(htmltemplate.FuncMap{
+		"base": filepath.Base,
+	}).Parse(htmlReport))
+	return tmpl.Execute(w, r)
+}
+
+// Escapes characters with a special meaning in Markdown, including the '|' table delimiter.
+func escapeMarkdown(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`, "|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`",
+		"[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;", "\n", " ",
+	).Replace(text)
+}
+
+// Build the report of a merge from its inputs and the statistics and size of its output.
+func newReport(outpath string, starts []inputStart, stats *mp3lib.Stats, size int64, header, checksum string) *report {
+	r := &report{
+		Version:  version,
+		Created:  time.Now().Format(time.DateTime),
+		Output:   outpath,
+		Duration: formatDuration(stats.Duration),
+		Size:     formatBytes(uint64(size)),
+		Bitrate:  stats.AverageBitRate() / 1000,
+		Header:   header,
+		Checksum: checksum,
+		Warnings: recordedWarnings(),
+	}
+	for i, start := range starts {
+		r.Inputs = append(r.Inputs, reportInput{
+			Index:    i + 1,
+			Path:     start.path,
+			Title:    readTitle(start.path),
+			Start:    formatTimestamp(start.timestamp),
+			Duration: formatDuration(start.stats.Duration),
+			Frames:   start.frames,
+			Bitrate:  start.stats.AverageBitRate() / 1000,
+			Garbage:  start.garbage,
+		})
+	}
+	return r
+}
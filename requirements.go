@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Constraints on the input files specified with the --require-* options.
+type requirements struct {
+	sampleRate int    // Required sampling rate in Hz, or zero for any.
+	channels   string // Required channel layout, 'mono' or 'stereo', or empty for any.
+	cbr        bool   // Require a single bitrate across all input files.
+	layer      byte   // Required MPEG layer, e.g. mp3lib.MPEGLayerII, or zero for any.
+}
+
+// Audio parameters collected from an input file's frames.
+type streamInfo struct {
+	sampleRates map[int]bool
+	bitRates    map[int]bool
+	firstRate   int  // Bitrate of the first audio frame.
+	mono        bool // True if any frame is mono.
+	stereo      bool // True if any frame has two channels.
+	layers      map[byte]bool
+	frames      int
+}
+
+// Scan the MP3 frames of the file at [path], skipping any VBR header.
+func scanFile(path string) (*streamInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info := &streamInfo{
+		sampleRates: make(map[int]bool),
+		bitRates:    make(map[int]bool),
+		layers:      make(map[byte]bool),
+	}
+
+	var read int
+	for frame, err := range mp3lib.Frames(file) {
+		if isEndOfStream(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		read++
+		if read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+			continue
+		}
+		if info.frames == 0 {
+			info.firstRate = frame.BitRate
+		}
+		info.frames++
+		info.sampleRates[frame.SamplingRate] = true
+		info.bitRates[frame.BitRate] = true
+		info.layers[frame.MPEGLayer] = true
+		if frame.ChannelMode == mp3lib.Mono {
+			info.mono = true
+		} else {
+			info.stereo = true
+		}
+	}
+
+	return info, nil
+}
+
+// Check the input files against the requirements. Returns a list of messages describing the
+// files which fail to meet them.
+func checkRequirements(files []string, reqs *requirements) ([]string, error) {
+	var problems []string
+	var firstBitRate int
+
+	for _, file := range files {
+		info, err := scanFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if reqs.sampleRate != 0 {
+			for rate := range info.sampleRates {
+				if rate != reqs.sampleRate {
+					problems = append(problems, fmt.Sprintf(
+						"'%v' has a sample rate of %v Hz, required %v Hz",
+						file, joinInts(info.sampleRates), reqs.sampleRate))
+					break
+				}
+			}
+		}
+
+		if (reqs.channels == "mono" && info.stereo) || (reqs.channels == "stereo" && info.mono) {
+			problems = append(problems, fmt.Sprintf(
+				"'%v' is not %v", file, reqs.channels))
+		}
+
+		if reqs.layer != 0 {
+			for layer := range info.layers {
+				if layer != reqs.layer {
+					problems = append(problems, fmt.Sprintf(
+						"'%v' contains layer %v audio, required layer %v",
+						file, layerName(layer), layerName(reqs.layer)))
+					break
+				}
+			}
+		}
+
+		// The output's bitrate is set by the first audio frame of the first file with any.
+		if reqs.cbr && len(info.bitRates) > 0 {
+			if firstBitRate == 0 {
+				firstBitRate = info.firstRate
+			}
+			if len(info.bitRates) > 1 || !info.bitRates[firstBitRate] {
+				problems = append(problems, fmt.Sprintf(
+					"'%v' has a bitrate of %v kbps, required a constant %v kbps",
+					file, joinInts(divideKeys(info.bitRates, 1000)), firstBitRate/1000))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// Parse the argument of the --require-channels option.
+func parseChannels(arg string) (string, error) {
+	switch strings.ToLower(arg) {
+	case "mono", "1":
+		return "mono", nil
+	case "stereo", "2":
+		return "stereo", nil
+	}
+	return "", conditionErrorf(errUsage, "invalid channel layout '%v', expected 'mono' or 'stereo'", arg)
+}
+
+// Parse the argument of the --require-layer option.
+func parseLayer(arg string) (byte, error) {
+	switch strings.ToUpper(arg) {
+	case "1", "I":
+		return mp3lib.MPEGLayerI, nil
+	case "2", "II":
+		return mp3lib.MPEGLayerII, nil
+	case "3", "III":
+		return mp3lib.MPEGLayerIII, nil
+	}
+	return 0, conditionErrorf(errUsage, "invalid MPEG layer '%v', expected 1, 2, or 3", arg)
+}
+
+// Returns a new set containing the keys of [set] divided by [divisor].
+func divideKeys(set map[int]bool, divisor int) map[int]bool {
+	result := make(map[int]bool)
+	for key := range set {
+		result[key/divisor] = true
+	}
+	return result
+}
+
+// Returns the keys of [set] in ascending order as a '/' separated string, e.g. '44100/48000'.
+func joinInts(set map[int]bool) string {
+	var keys []int
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	var parts []string
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprint(key))
+	}
+	return strings.Join(parts, "/")
+}
+
+// Compares a frame against the first frame of the output. Returns a description of the
+// difference and the warning it raises if the frames have a different sample rate, channel
+// layout, MPEG version or MPEG layer, otherwise an empty string.
+func describeMismatch(first, frame *mp3lib.MP3Frame) (string, condition) {
+	if frame.SamplingRate != first.SamplingRate {
+		return fmt.Sprintf(
+			"a sample rate of %v Hz but the output has %v Hz",
+			frame.SamplingRate, first.SamplingRate), warnMixedSampleRate
+	}
+	if (frame.ChannelMode == mp3lib.Mono) != (first.ChannelMode == mp3lib.Mono) {
+		return fmt.Sprintf(
+			"%v audio but the output is %v",
+			channelLayout(frame), channelLayout(first)), warnMixedChannels
+	}
+	if frame.MPEGVersion != first.MPEGVersion {
+		return fmt.Sprintf(
+			"MPEG version %v audio but the output is MPEG version %v",
+			mpegVersionName(frame), mpegVersionName(first)), warnMixedVersion
+	}
+	if frame.MPEGLayer != first.MPEGLayer {
+		return fmt.Sprintf(
+			"MPEG layer %v audio but the output is MPEG layer %v",
+			layerName(frame.MPEGLayer), layerName(first.MPEGLayer)), warnMixedLayer
+	}
+	return "", condition{}
+}
+
+// Describes the first difference between the emphasis, copyright, original, and private bits
+// of a frame and the first frame of the output, or returns an empty string if they match.
+func describeFlagMismatch(first, frame *mp3lib.MP3Frame) string {
+	if frame.Emphasis != first.Emphasis {
+		return fmt.Sprintf(
+			"emphasis %v but the output has emphasis %v",
+			emphasisName(frame.Emphasis), emphasisName(first.Emphasis))
+	}
+	bits := []struct {
+		name         string
+		value, first bool
+	}{
+		{"copyright", frame.CopyrightBit, first.CopyrightBit},
+		{"original", frame.OriginalBit, first.OriginalBit},
+		{"private", frame.PrivateBit, first.PrivateBit},
+	}
+	for _, bit := range bits {
+		if bit.value != bit.first {
+			return fmt.Sprintf(
+				"the %v bit %v but the output has it %v",
+				bit.name, bitState(bit.value), bitState(bit.first))
+		}
+	}
+	return ""
+}
+
+// Returns 'set' or 'clear'.
+func bitState(value bool) string {
+	if value {
+		return "set"
+	}
+	return "clear"
+}
+
+// Returns the name of an emphasis setting, e.g. '50/15 ms'.
+func emphasisName(emphasis byte) string {
+	switch emphasis {
+	case 0:
+		return "none"
+	case 1:
+		return "50/15 ms"
+	case 3:
+		return "CCITT J.17"
+	}
+	return "reserved"
+}
+
+// Returns 'mono' or 'stereo'.
+func channelLayout(frame *mp3lib.MP3Frame) string {
+	if frame.ChannelMode == mp3lib.Mono {
+		return "mono"
+	}
+	return "stereo"
+}
+
+// Returns the frame's MPEG version as a string, e.g. '2.5'.
+func mpegVersionName(frame *mp3lib.MP3Frame) string {
+	switch frame.MPEGVersion {
+	case mp3lib.MPEGVersion1:
+		return "1"
+	case mp3lib.MPEGVersion2:
+		return "2"
+	case mp3lib.MPEGVersion2_5:
+		return "2.5"
+	}
+	return "unknown"
+}
+
+// Returns an MPEG layer as a Roman numeral, e.g. 'III'.
+func layerName(layer byte) string {
+	switch layer {
+	case mp3lib.MPEGLayerI:
+		return "I"
+	case mp3lib.MPEGLayerII:
+		return "II"
+	case mp3lib.MPEGLayerIII:
+		return "III"
+	}
+	return "unknown"
+}
+
+// Returns true if the input files mix CRC-protected and unprotected frames, judged by the first
+// audio frame of each file. Files which can't be read are ignored here; the merge reports them.
+func hasMixedCRC(paths []string) bool {
+	var protected, unprotected bool
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		var read int
+		for frame, err := range mp3lib.Frames(file) {
+			if err != nil {
+				break
+			}
+			read++
+			if read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+				continue
+			}
+			if frame.CrcProtection {
+				protected = true
+			} else {
+				unprotected = true
+			}
+			break
+		}
+		file.Close()
+		if protected && unprotected {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+var retagHelptext = fmt.Sprintf(`
+Usage: %s retag [files]
+
+  Sets the same tags on a batch of MP3 files in place, e.g. the parts of
+  a book. The files' other tag frames are kept. The audio isn't changed.
+
+    $ mp3cat retag --dir out/ --album "Book Title" --artist "Author" --number
+
+  With --number, each file's track number is set to its position in the
+  list of files, as 'n/total'. Files from --dir are in natural order.
+
+Arguments:
+  [files]                 List of files to retag.
+
+Options:
+  --album <text>          Set the album (TALB).
+  --album-artist <text>   Set the album artist (TPE2).
+  --artist <text>         Set the artist (TPE1).
+  --comment <text>        Set a comment (COMM), replacing any comment with the
+                          same language and description.
+  --comment-desc <text>   Description of the --comment. Defaults to none.
+  --comment-lang <code>   Language of the --comment, as a 3-letter ISO 639-2
+                          code. Defaults to 'eng'.
+  -d, --dir <path>        Directory of files to retag.
+  --genre <genre>         Set the genre (TCON), as a name or an ID3v1 genre
+                          code, e.g. 'Audiobook' or '183'.
+  --tag-encoding <enc>    Text encoding of the tags: 'utf8', 'utf16', or
+                          'latin1'. UTF-8 tags are written as ID3v2.4.
+  -t, --tmpdir <path>     Directory for temporary files. Defaults to each
+                          file's own directory.
+
+Flags:
+  -h, --help              Display this help text and exit.
+  --include-hidden        Include hidden files and directories when scanning
+                          a directory with --dir.
+  --number                Set each file's track number (TRCK).
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+`, filepath.Base(os.Args[0]))
+
+// Run the 'retag' command. Returns the process exit code.
+func runRetag(ctx context.Context, parser *argo.ArgParser) int {
+	setQuiet(parser.Found("quiet"), false)
+
+	var files []string
+	if parser.Found("dir") {
+		var err error
+		files, err = findFiles(fixLongPath(parser.StringValue("dir")), "", parser.Found("include-hidden"))
+		if err != nil {
+			printError(err)
+			return 1
+		}
+	} else {
+		for _, arg := range expandGlobs(parser.Args) {
+			files = append(files, fixLongPath(arg))
+		}
+	}
+	if len(files) == 0 {
+		printErrorf(errUsage, "you must specify files to retag")
+		return 1
+	}
+
+	if parser.Found("tag-encoding") {
+		encoding, err := parseTagEncoding(parser.StringValue("tag-encoding"))
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		tagEncoding = encoding
+	}
+
+	// Text frames set on every file.
+	var frames []*mp3lib.ID3v2Frame
+	for _, option := range []struct{ name, id string }{
+		{"album", "TALB"}, {"artist", "TPE1"}, {"album-artist", "TPE2"},
+	} {
+		if parser.Found(option.name) {
+			frames = append(frames, mp3lib.NewTextFrame(option.id, parser.StringValue(option.name)))
+		}
+	}
+	if parser.Found("genre") {
+		genre, err := parseGenre(parser.StringValue("genre"))
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		frames = append(frames, mp3lib.NewTextFrame("TCON", genre))
+	}
+	if parser.Found("comment") {
+		comment, err := commentFrame(parser)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		frames = append(frames, comment)
+	}
+	if len(frames) == 0 && !parser.Found("number") {
+		printErrorf(errUsage, "nothing to set: use --album, --artist, --album-artist, --genre, --comment, or --number")
+		return 1
+	}
+
+	for i, file := range files {
+		if ctx.Err() != nil {
+			printError(ctx.Err())
+			return 1
+		}
+		set := frames
+		if parser.Found("number") {
+			track := fmt.Sprintf("%v/%v", i+1, len(files))
+			set = append(set[:len(set):len(set)], mp3lib.NewTextFrame("TRCK", track))
+		}
+		if err := retagFile(file, fixLongPath(parser.StringValue("tmpdir")), set); err != nil {
+			printError(fmt.Errorf("cannot retag '%v': %w", file, err))
+			return 1
+		}
+		printInfo("Retagged: %v", file)
+	}
+
+	return 0
+}
+
+// Rewrites the file at [path] with [frames] set in its ID3v2 tag, replacing any frames with the
+// same keys. The rest of the file is copied unchanged, via a temporary file in [tmpdir], or the
+// file's own directory if it's empty.
+func retagFile(path, tmpdir string, frames []*mp3lib.ID3v2Frame) error {
+	return rewriteFile(path, tmpdir, func(w io.Writer) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		tag, err := mp3lib.ReadID3v2Tag(file)
+		if err != nil {
+			return err
+		}
+
+		var audioOffset int64
+		if tag != nil {
+			audioOffset = int64(len(tag.RawBytes))
+		}
+		if _, err := file.Seek(audioOffset, io.SeekStart); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(retaggedTag(tag, frames).RawBytes); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		return err
+	})
+}
+
+// Returns a copy of [tag] with [frames] set, as withFrames does, but keeping its chapters and
+// table of contents, which describe the file's audio and so still apply to it.
+func retaggedTag(tag *mp3lib.ID3v2Tag, frames []*mp3lib.ID3v2Frame) *mp3lib.ID3v2Tag {
+	var kept []*mp3lib.ID3v2Frame
+	if tag != nil {
+		kept = copyableFrames(tag)
+	}
+	for _, frame := range frames {
+		kept = setFrame(kept, frame)
+	}
+	return newTag(append(kept, copyableChapterFrames(tag)...))
+}
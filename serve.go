@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dmulholl/argo/v4"
+)
+
+var serveHelptext = fmt.Sprintf(`
+Usage: %s serve
+
+  Runs an HTTP server which merges MP3 files on request. POST a merge job
+  to /merge and the merged file is streamed back as the response body.
+
+  A job can be a JSON object listing files on the server, relative to the
+  root directory:
+
+    $ curl -d '{"files": ["a.mp3", "b.mp3"], "meta": 1}' \
+        http://localhost:8080/merge -o merged.mp3
+
+  The optional "meta" field is the 1-based index of the file whose ID3v2
+  tag should be copied to the output.
+
+  A job can also be a multipart form of uploaded files, merged in the order
+  they're sent:
+
+    $ curl -F file=@a.mp3 -F file=@b.mp3 \
+        http://localhost:8080/merge -o merged.mp3
+
+Options:
+  -l, --listen <addr>     Address to listen on. Defaults to '127.0.0.1:8080',
+                          which only accepts local connections. The server
+                          has no authentication, so take care listening on
+                          other interfaces, e.g. with ':8080'.
+  --max-upload <size>     Maximum size of an upload request. Defaults to 1G.
+  -r, --root <path>       Directory for server-local files. Paths outside it,
+                          including symbolic links to files outside it, are
+                          rejected. Defaults to the current directory.
+
+Flags:
+  -h, --help              Display this help text and exit.
+`, filepath.Base(os.Args[0]))
+
+// A merge job submitted as JSON.
+type serveJob struct {
+	Files []string `json:"files"`
+	Meta  int      `json:"meta"`
+}
+
+// Handles requests for the 'serve' command.
+type server struct {
+	root      string
+	maxUpload int64
+}
+
+// Run the 'serve' command. Returns the process exit code.
+func runServe(ctx context.Context, parser *argo.ArgParser) int {
+	// The root is resolved so paths can be checked against it once their own links are resolved.
+	root, err := filepath.Abs(parser.StringValue("root"))
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	maxUpload, err := parseSize(parser.StringValue("max-upload"))
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	srv := &server{root: root, maxUpload: maxUpload}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /merge", srv.handleMerge)
+
+	httpServer := &http.Server{
+		Addr:              parser.StringValue("listen"),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	// Shut down gracefully on an interrupt, letting running merges clean up.
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	printInfo("Listening on %v", httpServer.Addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		printError(err)
+		return 1
+	}
+
+	return 0
+}
+
+// Handle a POST request to /merge.
+func (s *server) handleMerge(w http.ResponseWriter, r *http.Request) {
+	workdir, err := os.MkdirTemp("", "mp3cat-serve-")
+	if err != nil {
+		s.writeError(w, err, "", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workdir)
+
+	var files []string
+	var tagpath string
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		files, err = s.saveUploads(r, workdir)
+	} else {
+		files, tagpath, err = s.readJob(r)
+	}
+	if err != nil {
+		s.writeError(w, err, workdir, http.StatusBadRequest)
+		return
+	}
+
+	if err := validateFiles(files); err != nil {
+		s.writeError(w, err, workdir, http.StatusBadRequest)
+		return
+	}
+
+	outpath := filepath.Join(workdir, "output.mp3")
+	err = merge(r.Context(), files, &mergeOptions{
+		outpath: outpath,
+		tagpath: tagpath,
+		tmpdir:  workdir,
+	})
+	if err != nil {
+		s.writeError(w, err, workdir, http.StatusUnprocessableEntity)
+		return
+	}
+
+	outfile, err := os.Open(outpath)
+	if err != nil {
+		s.writeError(w, err, workdir, http.StatusInternalServerError)
+		return
+	}
+	defer outfile.Close()
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Content-Disposition", `attachment; filename="output.mp3"`)
+	http.ServeContent(w, r, "output.mp3", time.Now(), outfile)
+}
+
+// Write an error response for [err], logging the full error on the server only. The response
+// mustn't reveal the server's file layout, so paths in the root directory or in the request's
+// [workdir] are made relative to it, and an internal error gets a generic message.
+func (s *server) writeError(w http.ResponseWriter, err error, workdir string, status int) {
+	printError(err)
+	if status >= http.StatusInternalServerError {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	message := err.Error()
+	for _, dir := range []string{workdir, s.root} {
+		if dir != "" && !strings.HasSuffix(dir, string(filepath.Separator)) {
+			message = strings.ReplaceAll(message, dir+string(filepath.Separator), "")
+		}
+	}
+	http.Error(w, message, status)
+}
+
+// Decode a JSON merge job, resolving its files against the server's root directory.
+func (s *server) readJob(r *http.Request) (files []string, tagpath string, err error) {
+	var job serveJob
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&job); err != nil {
+		return nil, "", fmt.Errorf("invalid merge job: %w", err)
+	}
+
+	if len(job.Files) == 0 {
+		return nil, "", errors.New("the merge job has no files")
+	}
+
+	for _, name := range job.Files {
+		path, err := s.resolve(name)
+		if err != nil {
+			return nil, "", err
+		}
+		files = append(files, path)
+	}
+
+	if job.Meta != 0 {
+		if job.Meta < 1 || job.Meta > len(files) {
+			return nil, "", fmt.Errorf("meta index %v is out of range", job.Meta)
+		}
+		tagpath = files[job.Meta-1]
+	}
+
+	return files, tagpath, nil
+}
+
+// Resolve a server-local path against the root directory. Returns an error if the path
+// points outside it, either itself or through a symbolic link.
+func (s *server) resolve(name string) (string, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(name))
+	if !isWithin(s.root, path) {
+		return "", fmt.Errorf("the path '%v' is outside the root directory", name)
+	}
+
+	path, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("the file '%v' does not exist", name)
+	} else if err != nil {
+		return "", err
+	}
+	if !isWithin(s.root, path) {
+		return "", fmt.Errorf("the path '%v' is outside the root directory", name)
+	}
+	return path, nil
+}
+
+// Returns true if [path] is [dir] or inside it. Both must be clean, and both absolute or both
+// relative to the same directory.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Save the files uploaded in a multipart form to [dir], returning their paths in the order
+// they were sent.
+func (s *server) saveUploads(r *http.Request, dir string) ([]string, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, s.maxUpload)
+	multipart, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for {
+		part, err := multipart.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%04d.mp3", len(files)+1))
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(file, part)
+		file.Close()
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, path)
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("the upload contains no files")
+	}
+
+	return files, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sorts a list of file paths into case-insensitive natural order, so 'track2.mp3' comes before
+// 'Track10.mp3'. Paths are compared component by component, so the order is the same whatever
+// the platform's path separator or the filesystem's listing order.
+func sortNatural(paths []string) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		return naturalPathLess(paths[i], paths[j])
+	})
+}
+
+// Returns true if path [a] sorts before path [b] in natural order.
+func naturalPathLess(a, b string) bool {
+	partsA := strings.Split(filepath.ToSlash(a), "/")
+	partsB := strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		if partsA[i] != partsB[i] {
+			return naturalLess(partsA[i], partsB[i])
+		}
+	}
+	return len(partsA) < len(partsB)
+}
+
+// Returns true if [a] sorts before [b] in case-insensitive natural order. Runs of digits are
+// compared by numeric value. Strings which only differ in case or leading zeros are ordered
+// bytewise so the result is always deterministic.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			startA, startB := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			numA := strings.TrimLeft(a[startA:i], "0")
+			numB := strings.TrimLeft(b[startB:j], "0")
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			if numA != numB {
+				return numA < numB
+			}
+			continue
+		}
+
+		runeA, sizeA := utf8.DecodeRuneInString(a[i:])
+		runeB, sizeB := utf8.DecodeRuneInString(b[j:])
+		lowerA, lowerB := unicode.ToLower(runeA), unicode.ToLower(runeB)
+		if lowerA != lowerB {
+			return lowerA < lowerB
+		}
+		i += sizeA
+		j += sizeB
+	}
+
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Expands any arguments which contain glob patterns and don't name an existing file. Shells on
+// Windows don't expand globs, and quoted patterns aren't expanded anywhere, so we do it here,
+// sorting the matches into natural order. Patterns with no matches are left as they are.
+func expandGlobs(args []string) []string {
+	var expanded []string
+	for _, arg := range args {
+		if _, err := os.Stat(arg); err == nil || !strings.ContainsAny(arg, "*?[") {
+			expanded = append(expanded, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			expanded = append(expanded, arg)
+			continue
+		}
+		sortNatural(matches)
+		expanded = append(expanded, matches...)
+	}
+	return expanded
+}
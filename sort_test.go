@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		// Digit runs compare by value.
+		{"track2.mp3", "track10.mp3", true},
+		{"track10.mp3", "track2.mp3", false},
+		{"9", "10", true},
+		{"part1-2", "part1-10", true},
+		{"12345678901234567890", "99", false},
+
+		// Leading zeros don't change the value; ties are broken bytewise.
+		{"track02.mp3", "track10.mp3", true},
+		{"track002.mp3", "track2.mp3", true},
+		{"track2.mp3", "track002.mp3", false},
+		{"track0.mp3", "track00.mp3", true},
+		{"track00.mp3", "track0.mp3", false},
+
+		// Case is ignored, except to break ties.
+		{"Track2.mp3", "track10.mp3", true},
+		{"track2.mp3", "Track10.mp3", true},
+		{"apple", "Banana", true},
+		{"Banana", "apple", false},
+		{"ABC", "abc", true},
+		{"abc", "ABC", false},
+		{"élan", "Éclair", false},
+
+		// Equal prefixes: the shorter string sorts first.
+		{"track", "track1", true},
+		{"track1", "track", false},
+		{"track1", "track1a", true},
+		{"", "a", true},
+		{"a", "", false},
+
+		// Equal strings are not less than each other.
+		{"", "", false},
+		{"track1.mp3", "track1.mp3", false},
+	}
+
+	for _, test := range tests {
+		if got := naturalLess(test.a, test.b); got != test.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+		if test.want && naturalLess(test.b, test.a) {
+			t.Errorf("naturalLess(%q, %q) and its reverse are both true", test.a, test.b)
+		}
+	}
+}
+
+func TestNaturalPathLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"disc2/track10.mp3", "disc10/track1.mp3", true},
+		{"Disc 1/02.mp3", "disc 1/10.mp3", true},
+
+		// Paths compare by component, so a directory sorts before a longer name with the same
+		// prefix, although ' ' and '-' are less than '/' bytewise.
+		{"book/01.mp3", "book 2/01.mp3", true},
+		{"book 2/01.mp3", "book/01.mp3", false},
+		{"book/01.mp3", "book-extra.mp3", true},
+
+		// A directory's files sort after the directory's own path.
+		{"book", "book/01.mp3", true},
+		{"book/01.mp3", "book", false},
+
+		// Files in a directory sort together, in natural order.
+		{"a/track9.mp3", "a/track10.mp3", true},
+		{"a/track10.mp3", "b/track9.mp3", true},
+		{"a/b/c.mp3", "a/b/c.mp3", false},
+	}
+
+	for _, test := range tests {
+		if got := naturalPathLess(test.a, test.b); got != test.want {
+			t.Errorf("naturalPathLess(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+		if test.want && naturalPathLess(test.b, test.a) {
+			t.Errorf("naturalPathLess(%q, %q) and its reverse are both true", test.a, test.b)
+		}
+	}
+}
+
+func TestSortNatural(t *testing.T) {
+	paths := []string{
+		"Part 10/track1.mp3",
+		"part 2/Track10.mp3",
+		"part 2/track02.mp3",
+		"part 2/track2.mp3",
+		"Part 1/track9.mp3",
+		"intro.mp3",
+	}
+	want := []string{
+		"intro.mp3",
+		"Part 1/track9.mp3",
+		"part 2/track02.mp3",
+		"part 2/track2.mp3",
+		"part 2/Track10.mp3",
+		"Part 10/track1.mp3",
+	}
+
+	sortNatural(paths)
+	if !slices.Equal(paths, want) {
+		t.Errorf("sortNatural() = %q, want %q", paths, want)
+	}
+}
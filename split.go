@@ -0,0 +1,417 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+var splitHelptext = fmt.Sprintf(`
+Usage: %s split <file>
+
+  Splits an MP3 file into parts without re-encoding, e.g. a lecture
+  recording at its pauses, or a book at its chapters:
+
+    $ mp3cat split lecture.mp3 --on-silence 2s --threshold -45dB
+    $ mp3cat split book.mp3 --by-chapters
+
+  Silence is found without decoding the audio, from an estimate of each
+  frame's level. The file is split in the middle of each silent gap at
+  least as long as the --on-silence duration.
+
+  With --by-chapters, the file is split at the start of each chapter in
+  its ID3v2 tag, as written by 'mp3cat --chapters'.
+
+  Parts are named by chapter title, or by the input's filename and their
+  number, e.g. 'lecture 1.mp3'. With --number-outputs, every name starts
+  with the part's number, e.g. '01 Introduction.mp3', padded with zeros so
+  the parts sort in order, and each part's track number is set to
+  'n/total'.
+
+  Each part keeps the album, artist, year, genre, and cover art from the
+  input's ID3 tag, and gets its own title: its chapter title, or the
+  input's title and its number.
+
+Arguments:
+  <file>                  File to split.
+
+Options:
+  -o, --out-dir <dir>     Directory for the parts. Defaults to the input
+                          file's directory.
+  --on-silence <time>     Split at silent gaps at least this long, e.g. '2s'.
+  --threshold <level>     Level below which audio counts as silent, e.g.
+                          '-45dB'. Defaults to -50dB.
+
+Flags:
+  --by-chapters           Split the file at its chapters.
+  -f, --force             Overwrite existing parts.
+  -h, --help              Display this help text and exit.
+  --number-outputs        Number the parts' filenames and track numbers.
+  -q, --quiet             Quiet mode. Only output warnings and error messages.
+`, filepath.Base(os.Args[0]))
+
+// A part of the input file to write as a separate file: its audio frames from [first] up to but
+// not including [end]. Frames are counted from zero, not counting any VBR header.
+type splitPart struct {
+	first int
+	end   int
+	title string // The part's chapter title, if any.
+}
+
+// Run the 'split' command. Returns the process exit code.
+func runSplit(ctx context.Context, parser *argo.ArgParser) int {
+	setQuiet(parser.Found("quiet"), false)
+
+	if len(parser.Args) != 1 {
+		printErrorf(errUsage, "you must specify a single file to split")
+		return 1
+	}
+	inpath := fixLongPath(parser.Args[0])
+
+	var parts []splitPart
+	var err error
+	switch {
+	case parser.Found("on-silence") && parser.Found("by-chapters"):
+		printErrorf(errUsage, "--on-silence and --by-chapters can't be used together")
+		return 1
+	case parser.Found("on-silence"):
+		minGap, err := time.ParseDuration(parser.StringValue("on-silence"))
+		if err != nil || minGap <= 0 {
+			printErrorf(errUsage, "invalid --on-silence duration '%v'", parser.StringValue("on-silence"))
+			return 1
+		}
+		threshold, err := parseDecibels(parser.StringValue("threshold"))
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		parts, err = findSilences(ctx, inpath, minGap, threshold)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		if len(parts) == 1 {
+			printInfo("No silent gaps of %v or longer found; nothing to split.", minGap)
+			return 0
+		}
+	case parser.Found("by-chapters"):
+		tag := readTag(inpath)
+		if tag == nil || len(tag.Chapters()) == 0 {
+			printErrorf(errNoMetadata, "'%v' has no chapters", inpath)
+			return 1
+		}
+		parts, err = findChapterParts(ctx, inpath, tag.Chapters())
+		if err != nil {
+			printError(err)
+			return 1
+		}
+	default:
+		printErrorf(errUsage, "you must specify where to split the file with --on-silence or --by-chapters")
+		return 1
+	}
+
+	outdir := parser.StringValue("out-dir")
+	if outdir == "" {
+		outdir = filepath.Dir(inpath)
+	}
+	err = writeSplitParts(ctx, inpath, parts, fixLongPath(outdir), parser.Found("force"), parser.Found("number-outputs"))
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	printInfo("Split into %v parts.", len(parts))
+	return 0
+}
+
+// Parses a level in decibels, e.g. '-45dB' or '-45'.
+func parseDecibels(arg string) (float64, error) {
+	text := strings.TrimSpace(arg)
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "dB"), "db")
+	level, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || level > 0 {
+		return 0, conditionErrorf(errUsage, "invalid level '%v', expected decibels below full scale, e.g. '-45dB'", arg)
+	}
+	return level, nil
+}
+
+// Reads the file at [path] and returns the parts to split it into: the file is split in the
+// middle of each run of frames quieter than [threshold] lasting at least [minGap]. Silence at the
+// start or end of the file doesn't split it.
+func findSilences(ctx context.Context, path string, minGap time.Duration, threshold float64) ([]splitPart, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := mp3lib.NewFrameReader(mp3lib.NewContextReader(ctx, file))
+	frame := &mp3lib.MP3Frame{}
+
+	var parts []splitPart
+	var index, read int
+	var first int     // First frame of the current part.
+	var gapStart = -1 // First frame of the current silent run, if any.
+	var gapLength time.Duration
+
+	for {
+		err := reader.ReadInto(frame)
+		if isEndOfStream(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		read++
+		if read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+			continue
+		}
+		if frame.MPEGLayer != mp3lib.MPEGLayerIII {
+			return nil, errors.New("silence can only be found in layer III audio")
+		}
+
+		if mp3lib.Level(frame) < threshold {
+			if gapStart < 0 {
+				gapStart, gapLength = index, 0
+			}
+			gapLength += frame.Duration()
+		} else {
+			if gapStart > first && gapLength >= minGap {
+				cut := gapStart + (index-gapStart)/2
+				parts = append(parts, splitPart{first: first, end: cut})
+				first = cut
+			}
+			gapStart = -1
+		}
+		index++
+	}
+
+	if index == 0 {
+		return nil, conditionErrorf(errNotMP3, "'%v' contains no MP3 frames", path)
+	}
+	return append(parts, splitPart{first: first, end: index}), nil
+}
+
+// Reads the file at [path] and returns the parts to split it into, one per chapter. Each part
+// starts with the first frame at or after its chapter's start time. Any audio before the first
+// chapter is included in the first part, and the last part runs to the end of the file.
+func findChapterParts(ctx context.Context, path string, chapters []mp3lib.ID3v2Chapter) ([]splitPart, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := mp3lib.NewFrameReader(mp3lib.NewContextReader(ctx, file))
+	frame := &mp3lib.MP3Frame{}
+
+	parts := []splitPart{{title: chapters[0].Title}}
+	next := 1 // The next chapter to start a part.
+	var index, read int
+	var timestamp time.Duration
+
+	for {
+		err := reader.ReadInto(frame)
+		if isEndOfStream(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		read++
+		if read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+			continue
+		}
+
+		// Start a part for each chapter which has started by this frame. A chapter too short
+		// to contain a frame is merged into the next one.
+		for next < len(chapters) && timestamp >= chapters[next].Start {
+			if current := &parts[len(parts)-1]; index > current.first {
+				current.end = index
+				parts = append(parts, splitPart{first: index})
+			}
+			parts[len(parts)-1].title = chapters[next].Title
+			next++
+		}
+
+		timestamp += frame.Duration()
+		index++
+	}
+
+	if index == 0 {
+		return nil, conditionErrorf(errNotMP3, "'%v' contains no MP3 frames", path)
+	}
+	if next < len(chapters) {
+		printWarning(warnChaptersPastEnd, "%v of the chapters in '%v' start after the end of its audio", len(chapters)-next, path)
+	}
+	parts[len(parts)-1].end = index
+	return parts, nil
+}
+
+// Writes each of [parts] of the file at [inpath] to its own file in [outdir], named as described
+// by splitPartNames. Each part's ID3v2 tag has the album-level frames of the input's tag, such as
+// its album, artists, and cover art, and the part's own title: its chapter title, or the input's
+// title and the part's number. With [number], its track number is set too. Layer III parts with
+// multiple bitrates get a VBR header, or an Info header if space for one was reserved because
+// the input was predicted to have multiple bitrates. Unless [force] is true, existing files
+// aren't overwritten.
+func writeSplitParts(ctx context.Context, inpath string, parts []splitPart, outdir string, force, number bool) error {
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		return err
+	}
+	tag := readTag(inpath)
+
+	var outpaths []string
+	for _, name := range splitPartNames(inpath, parts, number) {
+		outpath := filepath.Join(outdir, name)
+		if isSameFile(inpath, outpath) || isSameFile(inpath, outpath+".partial") {
+			return conditionErrorf(errUsage, "the part '%v' would overwrite the input file", outpath)
+		}
+		if _, err := os.Stat(outpath); err == nil && !force {
+			return conditionErrorf(errOutputExists, "'%v' already exists (use --force to overwrite)", outpath)
+		}
+		outpaths = append(outpaths, outpath)
+	}
+
+	file, err := os.Open(inpath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reserveVBR := predictVBR([]string{inpath})
+	reader := mp3lib.NewFrameReader(mp3lib.NewContextReader(ctx, file))
+	frame := &mp3lib.MP3Frame{}
+	var index, read int
+
+	// As with a merge, each part is written to a '.partial' file which is only moved into place
+	// once it's complete, so a failed split never leaves a truncated part.
+	var partpath string
+	defer func() {
+		if partpath != "" {
+			os.Remove(partpath)
+		}
+	}()
+
+	for i, part := range parts {
+		partpath = outpaths[i] + ".partial"
+		output, err := newOutputWriter(partpath, "", 0, nil)
+		if err != nil {
+			return err
+		}
+		frames := albumFrames(tag)
+		title := part.title
+		if title == "" {
+			title = fmt.Sprintf("%v (part %v)", inputTitle(inpath, tag), i+1)
+		}
+		frames = setFrame(frames, mp3lib.NewTextFrame("TIT2", title))
+		if number {
+			frames = setFrame(frames, mp3lib.NewTextFrame("TRCK", fmt.Sprintf("%v/%v", i+1, len(parts))))
+		}
+		if err := output.WriteTag(newTag(frames)); err != nil {
+			output.Close()
+			return err
+		}
+
+		var stats mp3lib.Stats
+		var toc mp3lib.XingTOC
+		var template *mp3lib.MP3Frame
+		for index < part.end {
+			err := reader.ReadInto(frame)
+			if isEndOfStream(err) {
+				break
+			} else if err != nil {
+				output.Close()
+				return err
+			}
+			read++
+			if read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+				continue
+			}
+			index++
+
+			// The first frame of a part can't borrow audio data from the end of the previous
+			// part. With --on-silence it's in a silent gap, so the brief dropout this causes
+			// isn't heard.
+			if template == nil {
+				if i > 0 {
+					mp3lib.ClearMainDataBegin(frame)
+				}
+				template = &mp3lib.MP3Frame{}
+				*template = *frame
+				template.RawBytes = nil
+				if reserveVBR && template.MPEGLayer == mp3lib.MPEGLayerIII {
+					if err := output.ReserveVBRHeader(template); err != nil {
+						output.Close()
+						return err
+					}
+				}
+			}
+			if _, err := output.Write(frame.RawBytes); err != nil {
+				output.Close()
+				return err
+			}
+			stats.Add(frame)
+			toc.Add(frame)
+		}
+
+		if err := output.Close(); err != nil {
+			return err
+		}
+		if template != nil && template.MPEGLayer == mp3lib.MPEGLayerIII && (stats.IsVBR() || output.VBRHeaderReserved()) {
+			if err := output.FillVBRHeader(template, stats.Frames, stats.Bytes, &toc, !stats.IsVBR()); err != nil {
+				return err
+			}
+		}
+		if err := output.Finish(); err != nil {
+			return err
+		}
+		if err := os.Rename(partpath, outpaths[i]); err != nil {
+			return err
+		}
+		partpath = ""
+		printInfo("Part %v: %v, %v.", i+1, outpaths[i], formatDuration(stats.Duration))
+	}
+	return nil
+}
+
+// Returns the filenames for [parts] of the file at [inpath]. Parts are named by their titles, or
+// by the input's filename and their number if they have no title or share one with an earlier
+// part. With [number], every name starts with the part's number, padded with zeros to the width
+// of the last part's number, and at least two digits, so the names sort in order.
+func splitPartNames(inpath string, parts []splitPart, number bool) []string {
+	stem := strings.TrimSuffix(filepath.Base(inpath), filepath.Ext(inpath))
+	width := max(2, len(strconv.Itoa(len(parts))))
+
+	var names []string
+	used := make(map[string]bool)
+	for i, part := range parts {
+		name := safeFilename(part.title)
+		if number {
+			name = fmt.Sprintf("%0*d %v", width, i+1, cmp.Or(name, stem))
+		} else if name == "" || used[strings.ToLower(name)] {
+			name = fmt.Sprintf("%v %d", cmp.Or(name, stem), i+1)
+		}
+		used[strings.ToLower(name)] = true
+		names = append(names, name+".mp3")
+	}
+	return names
+}
+
+// Returns [name] with the characters which aren't allowed in filenames on some platforms
+// replaced with underscores.
+func safeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	return strings.TrimRight(name, ". ")
+}
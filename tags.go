@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Text encoding of the tags we write, set with --tag-encoding.
+var tagEncoding = mp3lib.EncodingAuto
+
+// Returns an ID3v2 tag containing [frames], with its text in the --tag-encoding. Warns if any
+// text can't be written in the encoding.
+func newTag(frames []*mp3lib.ID3v2Frame) *mp3lib.ID3v2Tag {
+	tag, lossy := mp3lib.NewID3v2TagEncoded(frames, tagEncoding)
+	if lossy {
+		printWarning(warnTagText, "some tag text can't be written in ISO-8859-1 and has been replaced with '?'")
+	}
+	return tag
+}
+
+// Parses a --tag-encoding argument: 'utf8', 'utf16', or 'latin1'.
+func parseTagEncoding(arg string) (byte, error) {
+	switch strings.ToLower(strings.ReplaceAll(arg, "-", "")) {
+	case "utf8":
+		return mp3lib.EncodingUTF8, nil
+	case "utf16":
+		return mp3lib.EncodingUTF16, nil
+	case "latin1", "iso88591":
+		return mp3lib.EncodingLatin1, nil
+	}
+	return 0, conditionErrorf(errUsage, "invalid tag encoding '%v', expected 'utf8', 'utf16', or 'latin1'", arg)
+}
+
+// Builds the tag written with --auto-tags: the album is the name of the directory holding the
+// first input file, and the title is the output's filename without its extension. Returns nil if
+// neither can be inferred.
+func autoTag(inpath, outpath string) *mp3lib.ID3v2Tag {
+	var frames []*mp3lib.ID3v2Frame
+
+	if dir, err := filepath.Abs(filepath.Dir(inpath)); err == nil {
+		album := filepath.Base(dir)
+		if album != string(filepath.Separator) && album != "." && filepath.VolumeName(dir) != dir {
+			frames = append(frames, mp3lib.NewTextFrame("TALB", album))
+		}
+	}
+
+	title := strings.TrimSuffix(filepath.Base(outpath), filepath.Ext(outpath))
+	if title != "" {
+		frames = append(frames, mp3lib.NewTextFrame("TIT2", title))
+	}
+
+	if len(frames) == 0 {
+		return nil
+	}
+	return newTag(frames)
+}
+
+// Reads the ID3v2 tag from the file at [tagpath] for --meta and rebuilds it without its images
+// if [stripArt] is true, and without its largest frames until it's no bigger than [maxSize]
+// bytes if [maxSize] is greater than zero. Dropped frames are reported. Returns nil if the file
+// has no tag.
+func trimTag(tagpath string, stripArt bool, maxSize int64) (*mp3lib.ID3v2Tag, error) {
+	file, err := os.Open(tagpath)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := mp3lib.ReadID3v2Tag(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the ID3 tag from '%v': %w", tagpath, err)
+	}
+	if tag == nil {
+		return nil, nil
+	}
+
+	var frames []*mp3lib.ID3v2Frame
+	for _, frame := range copyableFrames(tag) {
+		if stripArt && frame.ID == "APIC" {
+			printDebug("dropping a %v image from the tag", formatBytes(uint64(len(frame.Data))))
+			continue
+		}
+		frames = append(frames, frame)
+	}
+
+	// Each frame adds a 10-byte header to the tag's own 10-byte header.
+	size := int64(10)
+	for _, frame := range frames {
+		size += int64(10 + len(frame.Data))
+	}
+
+	for maxSize > 0 && size > maxSize && len(frames) > 0 {
+		largest := 0
+		for i, frame := range frames {
+			if len(frame.Data) > len(frames[largest].Data) {
+				largest = i
+			}
+		}
+		frame := frames[largest]
+		printWarning(warnTagFrameDropped, "dropping the %v frame (%v) from the tag to fit --max-tag-size",
+			frame.ID, formatBytes(uint64(len(frame.Data))))
+		size -= int64(10 + len(frame.Data))
+		frames = append(frames[:largest], frames[largest+1:]...)
+	}
+
+	return newTag(frames), nil
+}
+
+// Returns [frames] with [frame] in place of the first frame with the same key, and any others with
+// that key removed, or with [frame] appended if there isn't one.
+func setFrame(frames []*mp3lib.ID3v2Frame, frame *mp3lib.ID3v2Frame) []*mp3lib.ID3v2Frame {
+	var result []*mp3lib.ID3v2Frame
+	replaced := false
+	for _, existing := range frames {
+		if existing.Key() != frame.Key() {
+			result = append(result, existing)
+		} else if !replaced {
+			result = append(result, frame)
+			replaced = true
+		}
+	}
+	if !replaced {
+		result = append(result, frame)
+	}
+	return result
+}
+
+// Returns a copy of [tag] with [frames] set, replacing any frames with the same keys. The new tag
+// has only the frames of [tag] which can be copied. If [tag] is nil, the new tag has only
+// [frames].
+func withFrames(tag *mp3lib.ID3v2Tag, frames ...*mp3lib.ID3v2Frame) *mp3lib.ID3v2Tag {
+	var existing []*mp3lib.ID3v2Frame
+	if tag != nil {
+		existing = copyableFrames(tag)
+	}
+	for _, frame := range frames {
+		existing = setFrame(existing, frame)
+	}
+	return newTag(existing)
+}
+
+// Parses a --genre argument, an ID3v1 genre code or a name, and returns the genre's name. Names
+// of ID3v1 genres are normalised, e.g. 'audiobook' to 'Audiobook'; other names are kept as given
+// since ID3v2 tags can hold any genre.
+func parseGenre(arg string) (string, error) {
+	if _, err := strconv.Atoi(strings.TrimSpace(arg)); err == nil {
+		name := mp3lib.ParseGenre(arg)
+		if name == "" {
+			return "", conditionErrorf(errUsage, "'%v' isn't an ID3v1 genre code", arg)
+		}
+		return name, nil
+	}
+	if code := mp3lib.GenreCode(arg); code >= 0 {
+		return mp3lib.GenreName(code), nil
+	}
+	return strings.TrimSpace(arg), nil
+}
+
+// Returns a COMM frame for the --comment, --comment-lang, and --comment-desc options.
+func commentFrame(parser *argo.ArgParser) (*mp3lib.ID3v2Frame, error) {
+	language := parser.StringValue("comment-lang")
+	valid := len(language) == 3
+	for _, c := range []byte(language) {
+		valid = valid && ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z')
+	}
+	if !valid {
+		return nil, conditionErrorf(errUsage, "invalid --comment-lang '%v': expected a 3-letter language code, e.g. 'eng'", language)
+	}
+	return mp3lib.NewCommentFrame(language, parser.StringValue("comment-desc"), parser.StringValue("comment")), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Writes a table of contents for the merged file to [path], written with --write-toc. Each line
+// gives the timestamp at which an input starts, its filename, and its title from its ID3 tag,
+// if it has one, in a format which can be pasted into video descriptions or show notes.
+func writeTOC(path string, starts []inputStart) error {
+	var builder strings.Builder
+	for _, start := range starts {
+		line := fmt.Sprintf("%v  %v  %v", formatTimestamp(start.timestamp),
+			filepath.Base(start.path), readTitle(start.path))
+		builder.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return os.WriteFile(path, []byte(builder.String()), 0644)
+}
+
+// Returns the title from the ID3v2 tag at the start of the file at [path], or an empty string
+// if it doesn't have one.
+func readTitle(path string) string {
+	tag := readTag(path)
+	if tag == nil {
+		return ""
+	}
+	return strings.TrimSpace(tag.Text("TIT2"))
+}
+
+// Returns the ID3v2 tag at the start of the file at [path], or nil if it doesn't have one or
+// can't be read.
+func readTag(path string) *mp3lib.ID3v2Tag {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	tag, err := mp3lib.ReadID3v2Tag(file)
+	if err != nil {
+		return nil
+	}
+	return tag
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Returns a frame listing the time at which each input starts and its title, for players which
+// don't support chapters. With [mode] 'uslt' the list is a lyrics frame, with 'comment' a comment
+// frame; both are described as 'Tracklist'.
+func tracklistFrame(mode string, starts []inputStart) *mp3lib.ID3v2Frame {
+	var lines []string
+	for _, start := range starts {
+		title := inputTitle(start.path, readTag(start.path))
+		lines = append(lines, fmt.Sprintf("%v %v", formatDuration(start.timestamp), title))
+	}
+	text := strings.Join(lines, "\n")
+
+	if mode == "uslt" {
+		return mp3lib.NewLyricsFrame("eng", "Tracklist", text)
+	}
+	return mp3lib.NewCommentFrame("eng", "Tracklist", text)
+}
+
+// Returns the title of the input file at [path] from its ID3v2 tag, or its filename without the
+// extension if [tag] is nil or has no title.
+func inputTitle(path string, tag *mp3lib.ID3v2Tag) string {
+	if tag != nil {
+		if title := strings.TrimSpace(tag.Text("TIT2")); title != "" {
+			return title
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
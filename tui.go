@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dmulholl/argo/v4"
+	"golang.org/x/term"
+)
+
+var tuiHelptext = fmt.Sprintf(`
+Usage: %s tui [files]
+
+  Opens an interactive list of the files to merge, so they can be checked,
+  reordered, and left out before merging:
+
+    $ mp3cat tui --dir book/ -o book.mp3
+
+  Files from --dir, or the current directory if no files are listed, start
+  in natural order, e.g. 'track2.mp3' before 'track10.mp3'. Each file's
+  duration is shown once it's been read.
+
+  Keys:
+    up, down              Move through the list.
+    enter                 Pick up the file to move it with up and down, or
+                          put it down again.
+    space                 Include or leave out the file.
+    m                     Merge the included files.
+    q, esc                Quit without merging.
+
+Arguments:
+  [files]                 List of files to merge.
+
+Options:
+  -d, --dir <path>        Directory of files to merge.
+  -o, --out <path>        Output filepath. Defaults to 'output.mp3'.
+
+Flags:
+  -f, --force             Overwrite an existing output file.
+  -h, --help              Display this help text and exit.
+  --include-hidden        Include hidden files and directories when scanning
+                          a directory.
+`, filepath.Base(os.Args[0]))
+
+// A file in the picker's list.
+type pickerEntry struct {
+	path     string
+	name     string // The path shown in the list.
+	included bool
+	duration time.Duration
+	status   string // Shown instead of the duration until it's known, e.g. '...'.
+}
+
+// The state of the interactive file picker.
+type picker struct {
+	entries []*pickerEntry
+	cursor  int
+	offset  int  // Index of the first entry on screen.
+	holding bool // The entry at the cursor is picked up and moves with it.
+	outpath string
+}
+
+// The duration of an entry, read in the background.
+type pickerDuration struct {
+	entry    *pickerEntry
+	duration time.Duration
+	err      error
+}
+
+// Run the 'tui' command. Returns the process exit code.
+func runTUI(ctx context.Context, parser *argo.ArgParser) int {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		printErrorf(errUsage, "the tui command needs an interactive terminal")
+		return 1
+	}
+
+	outpath := fixLongPath(parser.StringValue("out"))
+	if _, err := os.Stat(outpath); err == nil && !parser.Found("force") {
+		printErrorf(errOutputExists, "the file '%v' already exists (use --force to overwrite)", outpath)
+		return 1
+	}
+
+	// Files are listed relative to the directory they were found in.
+	var files []string
+	dir := ""
+	if parser.Found("dir") || len(parser.Args) == 0 {
+		dir = "."
+		if parser.Found("dir") {
+			dir = fixLongPath(parser.StringValue("dir"))
+		}
+		var err error
+		files, err = findFiles(dir, outpath, parser.Found("include-hidden"))
+		if err != nil {
+			printError(err)
+			return 1
+		}
+	} else {
+		for _, arg := range expandGlobs(parser.Args) {
+			files = append(files, fixLongPath(arg))
+		}
+	}
+	if len(files) == 0 {
+		printErrorf(errNoFiles, "no files found")
+		return 1
+	}
+
+	p := &picker{outpath: outpath}
+	for _, file := range files {
+		name := file
+		if rel, err := filepath.Rel(dir, file); dir != "" && err == nil {
+			name = rel
+		}
+		p.entries = append(p.entries, &pickerEntry{path: file, name: name, included: true, status: "..."})
+	}
+
+	selected, ok, err := p.run(ctx)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	if !ok {
+		return 0
+	}
+	if len(selected) == 0 {
+		printErrorf(errNoFiles, "no files included")
+		return 1
+	}
+
+	lock, err := acquireLock(outpath, 0)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	defer lock.release()
+
+	if err := validateFiles(selected); err != nil {
+		printError(err)
+		return 1
+	}
+	err = merge(ctx, selected, &mergeOptions{
+		outpath: outpath,
+		force:   parser.Found("force"),
+	})
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+// Runs the picker until the user merges or quits. Returns the included files in order, and
+// false if the user quit.
+func (p *picker) run(ctx context.Context) ([]string, bool, error) {
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, false, err
+	}
+	restoreConsole, err := enableANSI(os.Stdout)
+	if err != nil {
+		term.Restore(int(os.Stdin.Fd()), state)
+		return nil, false, err
+	}
+
+	// Use the terminal's alternate screen, so the list doesn't clutter its scrollback.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		restoreConsole()
+		term.Restore(int(os.Stdin.Fd()), state)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+
+	// Durations are read in the list's original order. The entries are only changed here, as
+	// they may have moved by the time their durations arrive.
+	durations := make(chan pickerDuration)
+	entries := append([]*pickerEntry(nil), p.entries...)
+	go func() {
+		for _, entry := range entries {
+			duration, err := fileDuration(ctx, entry.path)
+			select {
+			case durations <- pickerDuration{entry, duration, err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		p.draw()
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case result := <-durations:
+			if result.err != nil {
+				result.entry.status = "?"
+			} else {
+				result.entry.duration, result.entry.status = result.duration, ""
+			}
+		case key, ok := <-keys:
+			if !ok {
+				return nil, false, nil
+			}
+			switch key {
+			case "q", "esc", "ctrl-c":
+				return nil, false, nil
+			case "m":
+				var selected []string
+				for _, entry := range p.entries {
+					if entry.included {
+						selected = append(selected, entry.path)
+					}
+				}
+				return selected, true, nil
+			default:
+				p.handle(key)
+			}
+		}
+	}
+}
+
+// Updates the picker for a key which moves the cursor or changes the list.
+func (p *picker) handle(key string) {
+	target := p.cursor
+	switch key {
+	case "up", "k":
+		target--
+	case "down", "j":
+		target++
+	case "pgup":
+		target -= p.rows()
+	case "pgdown":
+		target += p.rows()
+	case "home":
+		target = 0
+	case "end":
+		target = len(p.entries) - 1
+	case "enter":
+		p.holding = !p.holding
+	case "space":
+		p.entries[p.cursor].included = !p.entries[p.cursor].included
+	}
+	target = max(0, min(target, len(p.entries)-1))
+
+	// A picked-up entry moves with the cursor, shifting the entries it passes.
+	if p.holding && target != p.cursor {
+		entry := p.entries[p.cursor]
+		if target < p.cursor {
+			copy(p.entries[target+1:p.cursor+1], p.entries[target:p.cursor])
+		} else {
+			copy(p.entries[p.cursor:target], p.entries[p.cursor+1:target+1])
+		}
+		p.entries[target] = entry
+	}
+	p.cursor = target
+}
+
+// Returns the size of the terminal, or a standard size if it can't be found.
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// Returns the number of entries which fit on screen, below the header and above the key help.
+func (p *picker) rows() int {
+	_, height := terminalSize()
+	return max(1, height-4)
+}
+
+// Redraws the screen.
+func (p *picker) draw() {
+	width, _ := terminalSize()
+	rows := p.rows()
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	} else if p.cursor >= p.offset+rows {
+		p.offset = p.cursor - rows + 1
+	}
+
+	var included int
+	var total time.Duration
+	for _, entry := range p.entries {
+		if entry.included {
+			included++
+			total += entry.duration
+		}
+	}
+
+	var lines []string
+	lines = append(lines, truncate(fmt.Sprintf("Merge %v of %v files (%v) into '%v'",
+		included, len(p.entries), formatDuration(total), p.outpath), width), "")
+	for i := p.offset; i < len(p.entries) && i < p.offset+rows; i++ {
+		entry := p.entries[i]
+		marker := " "
+		if i == p.cursor && p.holding {
+			marker = "*"
+		} else if i == p.cursor {
+			marker = ">"
+		}
+		check := " "
+		if entry.included {
+			check = "x"
+		}
+		duration := entry.status
+		if duration == "" {
+			duration = formatDuration(entry.duration)
+		}
+		line := truncate(fmt.Sprintf("%v [%v] %3d %9v  %v", marker, check, i+1, duration, entry.name), width)
+		if i == p.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < rows+2 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "", truncate(
+		"up/down: move  enter: pick up/put down  space: include/leave out  m: merge  q: quit", width))
+
+	var builder strings.Builder
+	builder.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString(line)
+		builder.WriteString("\x1b[K")
+	}
+	builder.WriteString("\x1b[J")
+	fmt.Print(builder.String())
+}
+
+// Cuts [line] to at most [width] characters.
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:max(0, width)])
+}
+
+// Reads keypresses from the terminal in raw mode and sends their names to [keys], e.g. 'up' or
+// 'q'. The channel is closed if reading fails.
+func readKeys(file *os.File, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// Escape sequences sent by terminals for the keys the picker uses.
+var keySequences = map[string]string{
+	"\x1b[A": "up", "\x1bOA": "up", "\x1b[B": "down", "\x1bOB": "down",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdown",
+	"\x1b[H": "home", "\x1bOH": "home", "\x1b[1~": "home",
+	"\x1b[F": "end", "\x1bOF": "end", "\x1b[4~": "end",
+}
+
+// Splits the bytes of one read from the terminal into key names. A lone escape byte is the
+// escape key; unrecognised escape sequences are dropped.
+func parseKeys(data []byte) []string {
+	var keys []string
+	for len(data) > 0 {
+		if data[0] == 0x1b && len(data) > 2 && (data[1] == '[' || data[1] == 'O') {
+			found := false
+			for seq, key := range keySequences {
+				if strings.HasPrefix(string(data), seq) {
+					keys, data, found = append(keys, key), data[len(seq):], true
+					break
+				}
+			}
+			if !found {
+				// Skip an unknown sequence: ESC, '[' or 'O', then up to its final byte, e.g. a
+				// letter or '~'.
+				i := 2
+				for i < len(data) && !(data[i] >= 0x40 && data[i] <= 0x7e) {
+					i++
+				}
+				data = data[min(i+1, len(data)):]
+			}
+			continue
+		}
+		switch data[0] {
+		case 0x1b:
+			keys = append(keys, "esc")
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case ' ':
+			keys = append(keys, "space")
+		default:
+			keys = append(keys, string(data[:1]))
+		}
+		data = data[1:]
+	}
+	return keys
+}
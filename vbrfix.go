@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// The result of scanning a file's frames for a VBR header.
+type vbrScan struct {
+	stats     mp3lib.Stats
+	toc       mp3lib.XingTOC
+	template  *mp3lib.MP3Frame // The first audio frame, without its data.
+	offset    int64            // Offset of the first frame in the file.
+	hasHeader bool             // Whether the file has a VBR header of any kind.
+}
+
+// Read the frames of the file at [path], recording their statistics and whether the file has a
+// VBR header.
+func scanVBR(ctx context.Context, path string) (*vbrScan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scan := &vbrScan{offset: -1}
+	reader := mp3lib.NewFrameReader(mp3lib.NewContextReader(ctx, file))
+	frame := &mp3lib.MP3Frame{}
+	for read := 1; ; read++ {
+		err := reader.ReadInto(frame)
+		if isEndOfStream(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if scan.offset < 0 {
+			scan.offset = reader.Offset()
+		}
+		if read <= mp3lib.VBRHeaderSearchFrames && mp3lib.IsVBRHeaderFrame(frame) {
+			scan.hasHeader = true
+			continue
+		}
+		if scan.template == nil {
+			scan.template = &mp3lib.MP3Frame{}
+			*scan.template = *frame
+			scan.template.RawBytes = nil
+		}
+		scan.stats.Add(frame)
+		scan.toc.Add(frame)
+	}
+	return scan, nil
+}
+
+// Returns true if the file is layer III audio with more than one bitrate but no VBR header.
+// Without one, players estimate the file's duration from the bitrate of its first frame, which
+// is usually wrong.
+func (scan *vbrScan) missingHeader() bool {
+	return !scan.hasHeader && scan.template != nil &&
+		scan.template.MPEGLayer == mp3lib.MPEGLayerIII && scan.stats.IsVBR()
+}
+
+// Rewrites the file at [path] with an Xing VBR header built from [scan] inserted before its first
+// frame. The temporary copy goes in [tmpdir], or the file's own directory if it's empty.
+func addVBRHeader(path, tmpdir string, scan *vbrScan) error {
+	header := mp3lib.NewVBRHeaderFrame(scan.template, scan.stats.Frames, scan.stats.Bytes, &scan.toc, false, 0)
+
+	return rewriteFile(path, tmpdir, func(w io.Writer) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := io.CopyN(w, file, scan.offset); err != nil {
+			return err
+		}
+		if _, err := w.Write(header.RawBytes); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		return err
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+var verifyHelptext = fmt.Sprintf(`
+Usage: %s verify [files]
+
+  Checks MP3 files for garbage data between frames, truncated frames, and
+  frames which fail their CRC check. Exits with a non-zero status code if
+  any problems are found. The first change of bitrate in a file is also
+  noted but isn't counted as a problem.
+
+  A file with a variable bitrate but no VBR header is a problem as players
+  will usually show the wrong duration for it. With --fix, a VBR header is
+  added to the file instead.
+
+Arguments:
+  [files]                 List of files to check.
+
+Options:
+  -t, --tmpdir <path>     Directory for temporary files with --fix. Defaults
+                          to each file's own directory.
+  --workers <n>           Check up to n files at once. Defaults to 1.
+
+Flags:
+  --fix                   Add a VBR header to files which are missing one.
+  -h, --help              Display this help text and exit.
+  -q, --quiet             Only report files with problems.
+`, filepath.Base(os.Args[0]))
+
+// Run the 'verify' command. Returns the process exit code.
+func runVerify(ctx context.Context, parser *argo.ArgParser) int {
+	if len(parser.Args) == 0 {
+		printErrorf(errUsage, "you must specify files to verify")
+		return 1
+	}
+
+	workers, err := parseWorkers(parser)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	exitCode := 0
+	work := func(ctx context.Context, i int) verifyResult {
+		return checkFile(ctx, fixLongPath(parser.Args[i]), parser.Found("fix"), fixLongPath(parser.StringValue("tmpdir")))
+	}
+	runWorkers(ctx, len(parser.Args), workers, work, func(i int, result verifyResult) bool {
+		path := parser.Args[i]
+		if ctx.Err() != nil {
+			printError(ctx.Err())
+			exitCode = 1
+			return false
+		}
+		if result.err != nil {
+			printError(result.err)
+			exitCode = 1
+			return true
+		}
+
+		var problems int
+		for _, issue := range result.issues {
+			if issue.Severity != mp3lib.SeverityInfo {
+				problems++
+			}
+		}
+
+		if problems == 0 {
+			if parser.Found("quiet") && !result.fixed {
+				return true
+			}
+			fmt.Printf("• %v: ok\n", path)
+		} else {
+			exitCode = 1
+			fmt.Printf("• %v: %v problem(s)\n", path, problems)
+		}
+
+		for _, issue := range result.issues {
+			fmt.Printf("  offset %v: %v %v: %v\n", issue.Offset, issue.Severity, issue.condition.code, issue.Description)
+		}
+		if result.fixed {
+			fmt.Printf("  fixed: added a VBR header\n")
+		}
+		return true
+	})
+
+	return exitCode
+}
+
+// The result of checking a file with 'verify'.
+type verifyResult struct {
+	issues []verifyIssue
+	fixed  bool // A missing VBR header was added with --fix.
+	err    error
+}
+
+// An issue found by 'verify', with the condition it reports.
+type verifyIssue struct {
+	mp3lib.Issue
+	condition condition
+}
+
+// Check the file at [path] for problems. If [fix] is true, a missing VBR header is added to the
+// file instead of being reported as a problem, rewriting it via a temporary file in [tmpdir].
+func checkFile(ctx context.Context, path string, fix bool, tmpdir string) verifyResult {
+	found, err := verifyFile(ctx, path)
+	if err != nil {
+		return verifyResult{err: err}
+	}
+	var issues []verifyIssue
+	for _, issue := range found {
+		issues = append(issues, verifyIssue{issue, issueCondition(issue)})
+	}
+
+	// A missing VBR header isn't found by the validator, which reads frames one at a time.
+	scan, err := scanVBR(ctx, path)
+	if err != nil {
+		return verifyResult{err: err}
+	}
+	if !scan.missingHeader() {
+		return verifyResult{issues: issues}
+	}
+	if fix {
+		if err := addVBRHeader(path, tmpdir, scan); err != nil {
+			return verifyResult{err: fmt.Errorf("cannot add a VBR header to '%v': %w", path, err)}
+		}
+		return verifyResult{issues: issues, fixed: true}
+	}
+	issues = append(issues, verifyIssue{mp3lib.Issue{
+		Severity:    mp3lib.SeverityWarning,
+		Offset:      scan.offset,
+		Description: "a variable bitrate but no VBR header (see --fix)",
+	}, warnMissingVBRHeader})
+	return verifyResult{issues: issues}
+}
+
+// Validate the file at [path], returning a list of the issues found.
+func verifyFile(ctx context.Context, path string) ([]mp3lib.Issue, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return mp3lib.Validate(mp3lib.NewContextReader(ctx, file))
+}
+
+// Re-parse a freshly written output file and check that its frame count and byte count match
+// what was written, and that any Xing header agrees with them. If [keptHeaders] is true, the
+// output's frames were copied verbatim with --keep-headers, so a leading Xing frame is counted
+// as an ordinary frame. Used by --verify-output.
+func verifyOutput(ctx context.Context, path string, stats *mp3lib.Stats, hasVBRHeader, keptHeaders bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var frames, bytes uint64
+	var xing *mp3lib.XingInfo
+	var xingLen int
+
+	reader := mp3lib.NewFrameReader(mp3lib.NewContextReader(ctx, file))
+	frame := &mp3lib.MP3Frame{}
+	for {
+		err := reader.ReadInto(frame)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return conditionErrorf(errVerifyOutput, "output verification failed: %w", err)
+		}
+		if !keptHeaders && frames == 0 && xing == nil && mp3lib.IsXingHeader(frame) {
+			xing, err = mp3lib.ParseXingHeader(frame)
+			if err != nil {
+				return conditionErrorf(errVerifyOutput, "output verification failed: %w", err)
+			}
+			xingLen = len(frame.RawBytes)
+			continue
+		}
+		frames++
+		bytes += uint64(len(frame.RawBytes))
+	}
+
+	if frames != stats.Frames {
+		return conditionErrorf(errVerifyOutput, "output verification failed: wrote %v frames but found %v", stats.Frames, frames)
+	}
+	if bytes != stats.Bytes {
+		return conditionErrorf(errVerifyOutput, "output verification failed: wrote %v bytes of frames but found %v", stats.Bytes, bytes)
+	}
+	if hasVBRHeader != (xing != nil) {
+		return conditionErrorf(errVerifyOutput, "output verification failed: VBR header expected: %v, found: %v", hasVBRHeader, xing != nil)
+	}
+	if xing != nil {
+		if xing.Flags&mp3lib.XingFramesFlag != 0 && uint64(xing.Frames) != frames {
+			return conditionErrorf(errVerifyOutput, "output verification failed: VBR header records %v frames but found %v", xing.Frames, frames)
+		}
+		// The byte count includes the header frame itself.
+		if xing.Flags&mp3lib.XingBytesFlag != 0 && uint64(xing.Bytes) != bytes+uint64(xingLen) {
+			return conditionErrorf(errVerifyOutput, "output verification failed: VBR header records %v bytes but found %v", xing.Bytes, bytes+uint64(xingLen))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/dmulholl/mp3cat/mp3lib"
+)
+
+// Build metadata. Packagers can set these with -ldflags, e.g.
+//
+//	go build -ldflags "-X main.commit=abc1234 -X main.buildDate=2024-01-01"
+//
+// If unset, they're filled in from the version control information Go embeds in the binary.
+var (
+	commit    string
+	buildDate string
+)
+
+var versionHelptext = fmt.Sprintf(`
+Usage: %s version
+
+  Prints the version number. With --verbose, also prints the git commit and
+  build date, the Go version used to build the binary, and the mp3lib
+  version.
+
+Flags:
+  -h, --help              Display this help text and exit.
+  -v, --verbose           Print build metadata.
+`, filepath.Base(os.Args[0]))
+
+// Run the 'version' command. Returns the process exit code.
+func runVersion(parser *argo.ArgParser) int {
+	fmt.Println(version)
+	if !parser.Found("verbose") {
+		return 0
+	}
+
+	rev, date, modified := commit, buildDate, false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if rev == "" {
+					rev = setting.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	} else if modified {
+		rev += " (modified)"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	fmt.Printf("commit:     %s\n", rev)
+	fmt.Printf("built:      %s\n", date)
+	fmt.Printf("go:         %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("mp3lib:     %s\n", mp3lib.Version)
+	return 0
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dmulholl/argo/v4"
+	"github.com/fsnotify/fsnotify"
+)
+
+var watchHelptext = fmt.Sprintf(`
+Usage: %s watch
+
+  Watches a drop folder and merges each batch of files once it's complete.
+  Each subdirectory of the drop folder is a batch. A batch is merged once
+  its files have stopped changing for the settle time, and is merged again
+  if files are later added or changed.
+
+    $ mp3cat watch --dir incoming/ --out-dir done/
+
+  The output filename is set by the --name template, which can contain the
+  placeholders {batch} for the subdirectory's name and {date} for the
+  current date as YYYY-MM-DD.
+
+Options:
+  -d, --dir <path>        Directory to watch. Required.
+  -n, --name <template>   Output filename template. Defaults to '{batch}.mp3'.
+  --out-dir <path>        Directory for merged files. Defaults to the watched
+                          directory. If it's inside the watched directory,
+                          it's never treated as part of a batch.
+  --settle <seconds>      Time a batch must be unchanged before it's merged.
+                          Defaults to 10.
+
+Flags:
+  -h, --help              Display this help text and exit.
+  --include-hidden        Include hidden files and directories in batches.
+`, filepath.Base(os.Args[0]))
+
+// A batch of files waiting to be merged.
+type pendingBatch struct {
+	changed   time.Time // Time of the most recent change to the batch.
+	signature string    // Sizes and modification times of the batch's files at the last check.
+}
+
+// Watches a drop folder for the 'watch' command.
+type watcher struct {
+	dir           string
+	outdir        string
+	name          string
+	settle        time.Duration
+	includeHidden bool
+	pending       map[string]*pendingBatch
+	skip          string // Path of the output directory relative to dir, if it's inside dir.
+}
+
+// Run the 'watch' command. Returns the process exit code.
+func runWatch(ctx context.Context, parser *argo.ArgParser) int {
+	if !parser.Found("dir") {
+		printErrorf(errUsage, "you must specify a directory to watch with --dir")
+		return 1
+	}
+
+	w := &watcher{
+		dir:           fixLongPath(parser.StringValue("dir")),
+		outdir:        fixLongPath(parser.StringValue("out-dir")),
+		name:          parser.StringValue("name"),
+		settle:        time.Duration(parser.IntValue("settle")) * time.Second,
+		includeHidden: parser.Found("include-hidden"),
+		pending:       make(map[string]*pendingBatch),
+	}
+	if w.outdir == "" {
+		w.outdir = w.dir
+	}
+
+	if info, err := os.Stat(w.dir); err != nil || !info.IsDir() {
+		printErrorf(errFileNotFound, "the directory '%v' does not exist", w.dir)
+		return 1
+	}
+	if err := os.MkdirAll(w.outdir, 0755); err != nil {
+		printError(err)
+		return 1
+	}
+
+	// An output directory inside a batch would make each merge look like a change to the batch,
+	// and one at the top level would look like a batch itself, so it's left out of the watch.
+	if rel, err := filepath.Rel(resolvePath(w.dir), resolvePath(w.outdir)); err == nil &&
+		rel != "." && isWithin(".", rel) {
+		w.skip = rel
+	}
+
+	if err := w.run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		printError(err)
+		return 1
+	}
+
+	return 0
+}
+
+// Watch the drop folder until the context is cancelled.
+func (w *watcher) run(ctx context.Context) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsw.Close()
+
+	if err := fsw.Add(w.dir); err != nil {
+		return err
+	}
+
+	// Batches which already exist are checked on startup, and merged if they have no
+	// up-to-date output.
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			w.addBatch(fsw, filepath.Join(w.dir, entry.Name()))
+		}
+	}
+
+	printInfo("Watching %v", w.dir)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(fsw, event)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			printWarning(warnWatchFailed, "%v", err)
+		case <-ticker.C:
+			if err := w.mergeSettled(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Start watching a batch directory and mark it as pending.
+func (w *watcher) addBatch(fsw *fsnotify.Watcher, batch string) {
+	if w.isSkipped(batch) {
+		return
+	}
+	if !w.includeHidden {
+		if info, err := os.Stat(batch); err != nil || isHidden(batch, info) {
+			return
+		}
+	}
+	filepath.WalkDir(batch, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && entry.IsDir() && w.isSkipped(path) {
+			return filepath.SkipDir
+		}
+		if err == nil && entry.IsDir() {
+			if err := fsw.Add(path); err != nil {
+				printWarning(warnWatchFailed, "cannot watch '%v': %v", path, err)
+			}
+		}
+		return nil
+	})
+	w.touch(batch)
+}
+
+// Record a change to the drop folder.
+func (w *watcher) handleEvent(fsw *fsnotify.Watcher, event fsnotify.Event) {
+	rel, err := filepath.Rel(w.dir, event.Name)
+	if err != nil || rel == "." || w.isSkipped(event.Name) {
+		return
+	}
+
+	// Files at the top level of the drop folder, including our own output files, aren't part
+	// of any batch.
+	batch := filepath.Join(w.dir, strings.Split(rel, string(filepath.Separator))[0])
+	if info, err := os.Stat(batch); err != nil || !info.IsDir() {
+		delete(w.pending, batch)
+		return
+	}
+	if batch == event.Name && event.Has(fsnotify.Create) {
+		w.addBatch(fsw, batch)
+		return
+	}
+
+	// Watch new subdirectories inside a batch.
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			fsw.Add(event.Name)
+		}
+	}
+
+	w.touch(batch)
+}
+
+// Returns true if [path], a path in the watched directory, is the output directory or inside it.
+func (w *watcher) isSkipped(path string) bool {
+	if w.skip == "" {
+		return false
+	}
+	rel, err := filepath.Rel(w.dir, path)
+	return err == nil && isWithin(w.skip, rel)
+}
+
+// Mark a batch as changed now.
+func (w *watcher) touch(batch string) {
+	if p, ok := w.pending[batch]; ok {
+		p.changed = time.Now()
+		return
+	}
+	w.pending[batch] = &pendingBatch{changed: time.Now()}
+}
+
+// Merge the pending batches which have been unchanged for the settle time.
+func (w *watcher) mergeSettled(ctx context.Context) error {
+	var settled []string
+	for batch, p := range w.pending {
+		if time.Since(p.changed) >= w.settle {
+			settled = append(settled, batch)
+		}
+	}
+	sort.Strings(settled)
+
+	for _, batch := range settled {
+		p := w.pending[batch]
+		files, err := findFiles(batch, "", w.includeHidden)
+		if err != nil {
+			printWarning(warnWatchFailed, "cannot read '%v': %v", batch, err)
+			delete(w.pending, batch)
+			continue
+		}
+		files = slices.DeleteFunc(files, w.isSkipped)
+
+		// Events can be missed, e.g. on network filesystems, so check that the files have
+		// also stopped changing size between two checks.
+		signature := fileSignature(files)
+		if signature != p.signature {
+			if p.signature != "" {
+				p.changed = time.Now()
+			}
+			p.signature = signature
+			continue
+		}
+		delete(w.pending, batch)
+
+		if err := w.mergeBatch(ctx, batch, files); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			printError(err)
+		}
+	}
+
+	return nil
+}
+
+// Merge a batch's files, unless the output is already newer than all of them.
+func (w *watcher) mergeBatch(ctx context.Context, batch string, files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	outpath := filepath.Join(w.outdir, w.outputName(filepath.Base(batch)))
+	if info, err := os.Stat(outpath); err == nil && info.ModTime().After(latestModTime(files)) {
+		return nil
+	}
+
+	if err := validateFiles(files); err != nil {
+		return err
+	}
+
+	lock, err := acquireLock(outpath, 0)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	return merge(ctx, files, &mergeOptions{
+		outpath: outpath,
+		force:   true,
+	})
+}
+
+// Expand the --name template for a batch.
+func (w *watcher) outputName(batch string) string {
+	return strings.NewReplacer(
+		"{batch}", batch,
+		"{date}", time.Now().Format("2006-01-02"),
+	).Replace(w.name)
+}
+
+// Returns a string describing the sizes and modification times of the files.
+func fileSignature(files []string) string {
+	var builder strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&builder, "%v:%v:%v\n", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return builder.String()
+}
+
+// Returns the absolute path of [path] with any symbolic links resolved, or as far as it can be.
+func resolvePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dmulholl/argo/v4"
+)
+
+// Calls [work] for each of [n] items on up to [workers] goroutines, and [report] with each item's
+// result in order, as soon as that item and all the items before it are done. Output from
+// [report] is the same whatever the number of workers. If [report] returns false, the context
+// passed to [work] is cancelled, the remaining items are skipped, and runWorkers returns once the
+// items in progress have stopped.
+func runWorkers[T any](ctx context.Context, n, workers int, work func(ctx context.Context, i int) T, report func(i int, result T) bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]T, n)
+	done := make([]chan struct{}, n)
+	queue := make(chan int, n)
+	for i := range n {
+		done[i] = make(chan struct{})
+		queue <- i
+	}
+	close(queue)
+
+	var group sync.WaitGroup
+	for range max(1, min(workers, n)) {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for i := range queue {
+				if ctx.Err() == nil {
+					results[i] = work(ctx, i)
+				}
+				close(done[i])
+			}
+		}()
+	}
+
+	for i := range n {
+		<-done[i]
+		if !report(i, results[i]) {
+			break
+		}
+	}
+	cancel()
+	group.Wait()
+}
+
+// Returns the value of a command's --workers option, which must be at least 1.
+func parseWorkers(parser *argo.ArgParser) (int, error) {
+	workers := parser.IntValue("workers")
+	if workers < 1 {
+		return 0, conditionErrorf(errUsage, "invalid --workers count '%v', expected 1 or more", workers)
+	}
+	return workers, nil
+}